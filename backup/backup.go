@@ -0,0 +1,446 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"distributedstore/lsm"
+	"distributedstore/vfs"
+)
+
+// A backup directory holds one meta file per backup under meta/, the table
+// and blob files of all backups under shared/, and the remaining per-backup
+// files under private/<id>/. SSTables and blob files are immutable, so one
+// that is already in shared/ from an earlier backup is not copied again.
+//
+// Shared files are named after the table, its size and its checksum, so two
+// different tables can never collide on a name.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type BackupInfo struct {
+	ID int
+	Timestamp time.Time
+	Size int64
+	NumFiles int
+}
+
+type backupFile struct {
+	// path is relative to the restored data dir, stored to the backup dir.
+	path string
+	stored string
+	size int64
+	crc uint32
+}
+
+type backupMeta struct {
+	id int
+	timestamp time.Time
+	files []backupFile
+}
+
+type Engine struct {
+	fs vfs.FS
+	dir string
+	mu sync.Mutex
+}
+
+// Open opens the backup directory dir on the operating system's
+// filesystem, creating it if needed.
+func Open(dir string) (*Engine, error) {
+	return OpenFS(vfs.Default, dir)
+}
+
+// OpenFS opens the backup directory dir on fs, which restores also write
+// to.
+func OpenFS(fs vfs.FS, dir string) (*Engine, error) {
+	for _, sub := range []string{"meta", "shared", "private"} {
+		if err := fs.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Engine{fs: fs, dir: dir}, nil
+}
+
+func (e *Engine) CreateBackup(db *lsm.DB) (BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.backupIds()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	// The checkpoint is taken on the DB's filesystem, next to the DB, and
+	// copied from there into the backup directory.
+	dbfs := db.FS()
+	checkpoint := fmt.Sprintf("%s.backup-%06d", filepath.Clean(db.Dir()), id)
+	dbfs.RemoveAll(checkpoint)
+	if err := db.Checkpoint(checkpoint); err != nil {
+		return BackupInfo{}, err
+	}
+	defer dbfs.RemoveAll(checkpoint)
+
+	private := filepath.Join(e.dir, "private", strconv.Itoa(id))
+	e.fs.RemoveAll(private)
+	meta := &backupMeta{id: id, timestamp: time.Now()}
+	err = walkFiles(dbfs, checkpoint, "", func(rel string) error {
+		path := filepath.Join(checkpoint, rel)
+		crc, size, err := checksumFile(dbfs, path)
+		if err != nil {
+			return err
+		}
+
+		var stored string
+		if ext := filepath.Ext(rel); ext == ".sst" || ext == ".blob" {
+			name := strings.TrimSuffix(filepath.Base(rel), ext)
+			stored = filepath.Join("shared", fmt.Sprintf("%s_%d_%08x%s", name, size, crc, ext))
+		} else {
+			stored = filepath.Join("private", strconv.Itoa(id), rel)
+		}
+		target := filepath.Join(e.dir, stored)
+		if _, err := e.fs.Stat(target); err != nil {
+			if err := copyFile(dbfs, path, e.fs, target); err != nil {
+				return err
+			}
+		}
+		meta.files = append(meta.files, backupFile{path: rel, stored: stored, size: size, crc: crc})
+		return nil
+	})
+	if err != nil {
+		e.fs.RemoveAll(private)
+		return BackupInfo{}, err
+	}
+
+	if err := e.writeMeta(meta); err != nil {
+		e.fs.RemoveAll(private)
+		return BackupInfo{}, err
+	}
+	return meta.info(), nil
+}
+
+func (e *Engine) ListBackups() ([]BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.backupIds()
+	if err != nil {
+		return nil, err
+	}
+	var infos []BackupInfo
+	for _, id := range ids {
+		meta, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, meta.info())
+	}
+	return infos, nil
+}
+
+// VerifyBackup checks that every file of the backup is present with the
+// size and checksum it was backed up with.
+func (e *Engine) VerifyBackup(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	for _, f := range meta.files {
+		crc, size, err := checksumFile(e.fs, filepath.Join(e.dir, f.stored))
+		if err != nil {
+			return fmt.Errorf("backup %d: %s: %w", id, f.path, err)
+		}
+		if size != f.size {
+			return fmt.Errorf("backup %d: %s: size %d, expected %d", id, f.path, size, f.size)
+		}
+		if crc != f.crc {
+			return fmt.Errorf("backup %d: %s: checksum %08x, expected %08x", id, f.path, crc, f.crc)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) DeleteBackup(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.readMeta(id); err != nil {
+		return err
+	}
+	if err := e.fs.Remove(e.metaPath(id)); err != nil {
+		return err
+	}
+	e.fs.RemoveAll(filepath.Join(e.dir, "private", strconv.Itoa(id)))
+	return e.garbageCollect()
+}
+
+// PurgeOldBackups deletes all but the newest keep backups.
+func (e *Engine) PurgeOldBackups(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.backupIds()
+	if err != nil {
+		return err
+	}
+	for len(ids) > keep {
+		id := ids[0]
+		ids = ids[1:]
+		if err := e.fs.Remove(e.metaPath(id)); err != nil {
+			return err
+		}
+		e.fs.RemoveAll(filepath.Join(e.dir, "private", strconv.Itoa(id)))
+	}
+	return e.garbageCollect()
+}
+
+// RestoreBackup copies backup id into dataDir on the engine's filesystem,
+// which must be empty or not exist, verifying every file as it goes. The
+// result can be opened with lsm.Open.
+func (e *Engine) RestoreBackup(id int, dataDir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	if names, err := e.fs.List(dataDir); err == nil && len(names) > 0 {
+		return fmt.Errorf("backup: restore target %s is not empty", dataDir)
+	}
+	for _, sub := range []string{"wals", "ssts"} {
+		if err := e.fs.MkdirAll(filepath.Join(dataDir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	for _, f := range meta.files {
+		target := filepath.Join(dataDir, f.path)
+		if err := copyFile(e.fs, filepath.Join(e.dir, f.stored), e.fs, target); err != nil {
+			return err
+		}
+		crc, size, err := checksumFile(e.fs, target)
+		if err != nil {
+			return err
+		}
+		if size != f.size || crc != f.crc {
+			return fmt.Errorf("backup %d: %s is corrupt", id, f.path)
+		}
+	}
+	return nil
+}
+
+// garbageCollect removes shared files no remaining backup refers to, along
+// with leftovers of backups that failed half way.
+func (e *Engine) garbageCollect() error {
+	ids, err := e.backupIds()
+	if err != nil {
+		return err
+	}
+	live := make(map[string]struct{})
+	liveIds := make(map[string]struct{})
+	for _, id := range ids {
+		meta, err := e.readMeta(id)
+		if err != nil {
+			return err
+		}
+		for _, f := range meta.files {
+			live[f.stored] = struct{}{}
+		}
+		liveIds[strconv.Itoa(id)] = struct{}{}
+	}
+
+	shared, _ := e.fs.List(filepath.Join(e.dir, "shared"))
+	for _, name := range shared {
+		stored := filepath.Join("shared", name)
+		if _, ok := live[stored]; !ok {
+			e.fs.Remove(filepath.Join(e.dir, stored))
+		}
+	}
+	private, _ := e.fs.List(filepath.Join(e.dir, "private"))
+	for _, name := range private {
+		if _, ok := liveIds[name]; !ok {
+			e.fs.RemoveAll(filepath.Join(e.dir, "private", name))
+		}
+	}
+	return nil
+}
+
+var metaRe = regexp.MustCompile(`^(\d+)$`)
+
+func (e *Engine) backupIds() ([]int, error) {
+	names, err := e.fs.List(filepath.Join(e.dir, "meta"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, name := range names {
+		m := metaRe.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(m[1])
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (e *Engine) metaPath(id int) string {
+	return filepath.Join(e.dir, "meta", strconv.Itoa(id))
+}
+
+// The meta file is written last, through a rename, so a backup either
+// exists completely or not at all.
+func (e *Engine) writeMeta(meta *backupMeta) error {
+	tmp := e.metaPath(meta.id) + ".tmp"
+	file, err := e.fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "timestamp %d\n", meta.timestamp.Unix())
+	for _, f := range meta.files {
+		fmt.Fprintf(writer, "file %s %s %d %08x\n", filepath.ToSlash(f.path), filepath.ToSlash(f.stored), f.size, f.crc)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	return e.fs.Rename(tmp, e.metaPath(meta.id))
+}
+
+func (e *Engine) readMeta(id int) (*backupMeta, error) {
+	file, err := e.fs.Open(e.metaPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("backup %d: %w", id, err)
+	}
+	defer file.Close()
+
+	meta := &backupMeta{id: id}
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		parts := strings.Fields(sc.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		switch parts[0] {
+		case "timestamp":
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("backup %d: malformed meta line %q", id, sc.Text())
+			}
+			ts, _ := strconv.ParseInt(parts[1], 10, 64)
+			meta.timestamp = time.Unix(ts, 0)
+		case "file":
+			if len(parts) != 5 {
+				return nil, fmt.Errorf("backup %d: malformed meta line %q", id, sc.Text())
+			}
+			size, _ := strconv.ParseInt(parts[3], 10, 64)
+			crc, _ := strconv.ParseUint(parts[4], 16, 32)
+			meta.files = append(meta.files, backupFile{
+				path: filepath.FromSlash(parts[1]),
+				stored: filepath.FromSlash(parts[2]),
+				size: size,
+				crc: uint32(crc),
+			})
+		}
+	}
+	return meta, sc.Err()
+}
+
+func (meta *backupMeta) info() BackupInfo {
+	info := BackupInfo{ID: meta.id, Timestamp: meta.timestamp, NumFiles: len(meta.files)}
+	for _, f := range meta.files {
+		info.Size += f.size
+	}
+	return info
+}
+
+// walkFiles calls fn with the path of every file under dir/rel, relative
+// to dir.
+func walkFiles(fs vfs.FS, dir string, rel string, fn func(rel string) error) error {
+	names, err := fs.List(filepath.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(rel, name)
+		info, err := fs.Stat(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = walkFiles(fs, dir, path, fn)
+		} else {
+			err = fn(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checksumFile(fs vfs.FS, path string) (uint32, int64, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	h := crc32.New(castagnoli)
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return h.Sum32(), n, nil
+}
+
+// copyFile copies src on srcFS to dst on dstFS through a temporary file, so
+// dst only ever appears complete.
+func copyFile(srcFS vfs.FS, src string, dstFS vfs.FS, dst string) error {
+	if err := dstFS.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := dstFS.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		dstFS.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		dstFS.Remove(tmp)
+		return err
+	}
+	out.Close()
+	return dstFS.Rename(tmp, dst)
+}
@@ -0,0 +1,109 @@
+package backup_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"distributedstore/backup"
+	"distributedstore/lsm"
+	"distributedstore/vfs"
+)
+
+func openDB(t *testing.T, fs vfs.FS, dir string) *lsm.DB {
+	t.Helper()
+	opts := lsm.DefaultOptions()
+	opts.FS = fs
+	db, err := lsm.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("open %s: %v", dir, err)
+	}
+	return db
+}
+
+func TestBackupRestoreSharesTables(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openDB(t, fs, "/db")
+	defer db.Close()
+	engine, err := backup.OpenFS(fs, "/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Put("a", "1")
+	db.Flush()
+	first, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatalf("first backup: %v", err)
+	}
+	shared, _ := fs.List("/backups/shared")
+	db.Put("b", "2")
+	second, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatalf("second backup: %v", err)
+	}
+	if after, _ := fs.List("/backups/shared"); len(after) != len(shared)+1 {
+		t.Fatalf("shared files went from %d to %d; want only the new table added", len(shared), len(after))
+	}
+
+	if err := engine.PurgeOldBackups(1); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if infos, _ := engine.ListBackups(); len(infos) != 1 || infos[0].ID != second.ID {
+		t.Fatalf("backups after purge: %+v", infos)
+	}
+	if err := engine.RestoreBackup(first.ID, "/gone"); err == nil {
+		t.Fatal("restored a purged backup")
+	}
+	if err := engine.VerifyBackup(second.ID); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := engine.RestoreBackup(second.ID, "/restored"); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	restored := openDB(t, fs, "/restored")
+	defer restored.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if v, ok, err := restored.Get(key); err != nil || !ok || v != want {
+			t.Fatalf("restored %s = %q, %v", key, v, ok)
+		}
+	}
+}
+
+func TestBackupDetectsCorruption(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openDB(t, fs, "/db")
+	defer db.Close()
+	engine, err := backup.OpenFS(fs, "/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("a", "1")
+	db.Flush()
+	info, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.RestoreBackup(info.ID, "/db"); err == nil {
+		t.Fatal("restored over a live DB")
+	}
+	shared, _ := fs.List("/backups/shared")
+	if len(shared) == 0 {
+		t.Fatal("no shared files")
+	}
+	f, err := fs.Create(filepath.Join("/backups/shared", shared[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("garbage\n"))
+	f.Close()
+	if err := engine.VerifyBackup(info.ID); err == nil {
+		t.Fatal("verify passed a corrupt backup")
+	}
+	if err := engine.RestoreBackup(info.ID, "/restored"); err == nil {
+		t.Fatal("restore passed a corrupt backup")
+	}
+	if err := engine.DeleteBackup(info.ID + 1); err == nil {
+		t.Fatal("deleted a backup that does not exist")
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"distributedstore/lsm"
+)
+
+// dumpFlags are the options shared by sst dump and wal dump. Keys from start
+// to end, both inclusive, and sequence numbers from minSeq to maxSeq are
+// printed; an empty bound is open.
+type dumpFlags struct {
+	cmp lsm.Comparator
+	start string
+	end string
+	minSeq int
+	maxSeq int
+	hex bool
+	json bool
+	entries bool
+	index bool
+}
+
+func parseDumpArgs(name string, args []string) (*dumpFlags, []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	d := &dumpFlags{}
+	comparator := fs.String("comparator", "bytewise", "key order for -start and -end: bytewise, reverse or numeric")
+	fs.StringVar(&d.start, "start", "", "first key to print")
+	fs.StringVar(&d.end, "end", "", "last key to print")
+	fs.IntVar(&d.minSeq, "min-seq", 0, "lowest sequence number to print")
+	fs.IntVar(&d.maxSeq, "max-seq", math.MaxInt, "highest sequence number to print")
+	fs.BoolVar(&d.hex, "hex", false, "print keys and values in hex instead of escaped text")
+	fs.BoolVar(&d.json, "json", false, "print JSON")
+	fs.BoolVar(&d.entries, "entries", true, "print entries, not just the summary")
+	if name == "sst dump" {
+		fs.BoolVar(&d.index, "index", true, "print index entries")
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+	d.cmp = comparatorByFlag(*comparator)
+	if d.cmp == nil {
+		d.cmp = lsm.BytewiseComparator
+	}
+	return d, fs.Args()
+}
+
+func (d *dumpFlags) match(seq int, key string) bool {
+	if seq < d.minSeq || seq > d.maxSeq {
+		return false
+	}
+	if d.start != "" && d.cmp.Compare(key, d.start) < 0 {
+		return false
+	}
+	return d.end == "" || d.cmp.Compare(key, d.end) <= 0
+}
+
+// text formats a key or value for printing.
+func (d *dumpFlags) text(s string) string {
+	if d.hex {
+		return hex.EncodeToString([]byte(s))
+	}
+	if d.json {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// keyRange is the count and the smallest and largest of the keys printed.
+type keyRange struct {
+	Entries int
+	Smallest string
+	Largest string
+}
+
+func (r *keyRange) add(cmp lsm.Comparator, key string) {
+	if r.Entries == 0 || cmp.Compare(key, r.Smallest) < 0 {
+		r.Smallest = key
+	}
+	if r.Entries == 0 || cmp.Compare(key, r.Largest) > 0 {
+		r.Largest = key
+	}
+	r.Entries++
+}
+
+func (r keyRange) format(d *dumpFlags) keyRange {
+	if r.Entries > 0 {
+		r.Smallest, r.Largest = d.text(r.Smallest), d.text(r.Largest)
+	}
+	return r
+}
+
+func (r keyRange) String() string {
+	if r.Entries == 0 {
+		return "0 entries"
+	}
+	return fmt.Sprintf("%d entries, keys [%s, %s]", r.Entries, r.Smallest, r.Largest)
+}
+
+type entryJSON struct {
+	Line int
+	Family int
+	Seq int
+	Kind string
+	Key string
+	Value string
+	Batch int
+	BatchSize int
+}
+
+type indexJSON struct {
+	Key string
+	Offset int64
+	Size int64
+}
+
+type sstJSON struct {
+	Properties lsm.TableProperties
+	Filter lsm.TableFilterStats
+	Index []indexJSON
+	Entries []entryJSON
+	Matched keyRange
+}
+
+func sstDump(args []string) error {
+	d, paths := parseDumpArgs("sst dump", args)
+	for _, path := range paths {
+		if err := dumpTable(d, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpTable(d *dumpFlags, path string) error {
+	info, err := lsm.InspectTable(path, lsm.DefaultColumnFamilyOptions())
+	if err != nil {
+		return err
+	}
+	p := info.Properties
+	out := sstJSON{Properties: p, Filter: info.Filter}
+	out.Properties.Smallest, out.Properties.Largest = d.text(p.Smallest), d.text(p.Largest)
+	if d.index {
+		for _, e := range info.Index {
+			out.Index = append(out.Index, indexJSON{Key: d.text(e.Key), Offset: e.Offset, Size: e.Size})
+		}
+	}
+
+	if !d.json {
+		fmt.Println(path)
+		fmt.Printf("  size %d bytes, checksum %08x\n", p.Size, p.Checksum)
+		fmt.Printf("  %d entries (%d puts, %d blob references, %d deletes), %d key bytes, %d value bytes\n", p.Entries, p.Puts, p.BlobRefs, p.Deletes, p.KeyBytes, p.ValueBytes)
+		fmt.Printf("  keys [%s, %s], seqs [%d, %d]\n", out.Properties.Smallest, out.Properties.Largest, p.MinSeq, p.MaxSeq)
+		fmt.Printf("  filter %d bits, %d hashes, %d set, estimated false positive rate %.3g%%\n",
+			info.Filter.Bits, info.Filter.Hashes, info.Filter.BitsSet, 100*info.Filter.FalsePositiveRate)
+		if info.IndexPartitions > 0 {
+			fmt.Printf("  index %d entries in %d partitions\n", len(info.Index), info.IndexPartitions)
+		} else {
+			fmt.Printf("  index %d entries\n", len(info.Index))
+		}
+		for _, e := range out.Index {
+			fmt.Printf("    %s @%d+%d\n", e.Key, e.Offset, e.Size)
+		}
+	}
+
+	var matched keyRange
+	it := lsm.NewSSTableIter(path)
+	defer it.Close()
+	for it.Next(); it.Valid(); it.Next() {
+		if !d.match(it.Seq(), it.Key()) {
+			continue
+		}
+		matched.add(d.cmp, it.Key())
+		if !d.entries {
+			continue
+		}
+		e := entryJSON{Seq: it.Seq(), Kind: it.Kind().String(), Key: d.text(it.Key())}
+		switch it.Kind() {
+		case lsm.KindPut:
+			e.Value = d.text(it.Value())
+		case lsm.KindBlobIndex:
+			// The value is in a blob file; show where.
+			e.Value = it.Value()
+		}
+		if d.json {
+			out.Entries = append(out.Entries, e)
+			continue
+		}
+		if e.Kind == "DEL" {
+			fmt.Printf("    seq=%d DEL %s\n", e.Seq, e.Key)
+		} else {
+			fmt.Printf("    seq=%d %s %s %s\n", e.Seq, e.Kind, e.Key, e.Value)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	out.Matched = matched.format(d)
+
+	if d.json {
+		return printJSON(out)
+	}
+	fmt.Printf("  matched %s\n", out.Matched)
+	return nil
+}
+
+type walJSON struct {
+	Path string
+	Entries []entryJSON
+	Errors []string
+	Torn bool
+	Records int
+	Puts int
+	Deletes int
+	Batches int
+	MinSeq int
+	MaxSeq int
+	Families map[string]keyRange
+}
+
+func walDump(args []string) error {
+	d, paths := parseDumpArgs("wal dump", args)
+	for _, path := range paths {
+		if err := dumpWAL(d, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpWAL(d *dumpFlags, path string) error {
+	out := walJSON{Path: path, Families: make(map[string]keyRange)}
+	families := make(map[int]*keyRange)
+	lastBatch := 0
+	if !d.json {
+		fmt.Println(path)
+	}
+	torn, err := lsm.ReadWAL(path, func(r lsm.WALRecord) {
+		if !d.match(r.Seq, r.Key) {
+			return
+		}
+		out.Records++
+		if r.Kind == lsm.KindPut {
+			out.Puts++
+		} else {
+			out.Deletes++
+		}
+		if r.Batch != 0 && r.Batch != lastBatch {
+			out.Batches++
+		}
+		lastBatch = r.Batch
+		if out.Records == 1 {
+			out.MinSeq = r.Seq
+		}
+		out.MinSeq = min(out.MinSeq, r.Seq)
+		out.MaxSeq = max(out.MaxSeq, r.Seq)
+		if families[r.ColumnFamily] == nil {
+			families[r.ColumnFamily] = &keyRange{}
+		}
+		families[r.ColumnFamily].add(d.cmp, r.Key)
+		if !d.entries {
+			return
+		}
+		e := entryJSON{Line: r.Line, Family: r.ColumnFamily, Seq: r.Seq, Kind: r.Kind.String(), Key: d.text(r.Key), Batch: r.Batch, BatchSize: r.BatchSize}
+		if r.Kind == lsm.KindPut {
+			e.Value = d.text(r.Value)
+		}
+		if d.json {
+			out.Entries = append(out.Entries, e)
+			return
+		}
+		fmt.Printf("  %d: seq=%d cf=%d %s %s", e.Line, e.Seq, e.Family, e.Kind, e.Key)
+		if r.Kind == lsm.KindPut {
+			fmt.Printf(" %s", e.Value)
+		}
+		if e.Batch != 0 {
+			fmt.Printf(" batch=%d/%d", e.Batch, e.BatchSize)
+		}
+		fmt.Println()
+	}, func(line int, err error) {
+		msg := fmt.Sprintf("%s:%d: %v", filepath.Base(path), line, err)
+		out.Errors = append(out.Errors, msg)
+		if !d.json {
+			fmt.Printf("  %s (skipped on replay)\n", msg)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	out.Torn = torn
+	for id, r := range families {
+		out.Families[strconv.Itoa(id)] = r.format(d)
+	}
+
+	if d.json {
+		return printJSON(out)
+	}
+	if torn {
+		fmt.Println("  ends in a torn batch, dropped on replay")
+	}
+	fmt.Printf("  %d records (%d puts, %d deletes) in %d batches, seqs [%d, %d]\n", out.Records, out.Puts, out.Deletes, out.Batches, out.MinSeq, out.MaxSeq)
+	ids := make([]int, 0, len(families))
+	for id := range families {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Printf("  cf %d: %s\n", id, out.Families[strconv.Itoa(id)])
+	}
+	return nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,98 @@
+package lsm
+
+// ApproximateSize estimates the bytes the default column family holds for
+// keys from start to end inclusive, where an empty bound is unbounded. No
+// data blocks are read: SSTables are measured between the index entries
+// around the range, with their share of the blob file values they reference, and
+// memtables by the entries they hold in it. Versions that compaction has yet
+// to drop are counted too.
+func (db *DB) ApproximateSize(start string, end string) int64 {
+	return db.ApproximateSizeCF(db.DefaultColumnFamily(), start, end)
+}
+
+func (db *DB) ApproximateSizeCF(cf *ColumnFamily, start string, end string) int64 {
+	size, _ := db.approximateRange(cf, start, end)
+	return size
+}
+
+// ApproximateKeyCount estimates the entries of the default column family
+// with keys from start to end inclusive, the same way ApproximateSize does.
+func (db *DB) ApproximateKeyCount(start string, end string) int64 {
+	return db.ApproximateKeyCountCF(db.DefaultColumnFamily(), start, end)
+}
+
+func (db *DB) ApproximateKeyCountCF(cf *ColumnFamily, start string, end string) int64 {
+	_, keys := db.approximateRange(cf, start, end)
+	return keys
+}
+
+func (db *DB) approximateRange(cf *ColumnFamily, start string, end string) (int64, int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var size, keys int64
+	for _, t := range cf.sstables {
+		s, k := db.approximateTableRange(t, start, end)
+		size += s
+		keys += k
+	}
+	for _, m := range append([]*Memtable{cf.memtable}, cf.imm...) {
+		s, k := m.approximateRange(start, end)
+		size += s
+		keys += k
+	}
+	return size, keys
+}
+
+// approximateTableRange estimates the bytes and entries of sstable from start
+// to end, taking the blocks from the one that may hold start up to the one
+// that starts after end, and a share of the entries in proportion. A table
+// whose index partitions cannot be read counts whole.
+func (db *DB) approximateTableRange(sstable *SSTable, start string, end string) (int64, int64) {
+	if len(sstable.index) == 0 || !db.overlaps(sstable, start, end) {
+		return 0, 0
+	}
+	from, to := int64(-1), int64(0)
+	err := db.forEachBlock(sstable, func(key string) bool { return start != "" && db.cmp.Compare(key, start) <= 0 }, func(block IndexEntry) bool {
+		if from < 0 {
+			from = block.offset
+		} else if end != "" && db.cmp.Compare(block.key, end) > 0 {
+			return false
+		}
+		to = block.offset + block.size
+		return true
+	})
+	if err != nil {
+		from, to = 0, sstable.dataSize()
+	}
+	if from < 0 {
+		return 0, 0
+	}
+	size := to - from
+	keys := int64(float64(sstable.entries) * float64(size) / float64(sstable.dataSize()))
+
+	var blobBytes int64
+	for _, n := range sstable.blobs {
+		blobBytes += n
+	}
+	if blobBytes > 0 && sstable.size > 0 {
+		size += int64(float64(blobBytes) * float64(size) / float64(sstable.size))
+	}
+	return size, keys
+}
+
+// approximateRange counts the entries of a memtable from start to end and the
+// bytes of their keys and values.
+func (memtable *Memtable) approximateRange(start string, end string) (int64, int64) {
+	skipList := memtable.skipList
+	x := skipList.header.forward[0]
+	if start != "" {
+		x = skipList.seek(start)
+	}
+	var size, keys int64
+	for ; x != nil && (end == "" || skipList.cmp.Compare(x.key, end) <= 0); x = x.forward[0] {
+		size += int64(len(x.key) + len(x.value))
+		keys++
+	}
+	return size, keys
+}
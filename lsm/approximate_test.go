@@ -0,0 +1,55 @@
+package lsm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApproximateSizeAndKeyCount(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.BlockSize = 1 << 10 })
+	defer db.Close()
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 1000; i++ {
+		db.Put(fmt.Sprintf("k%04d", i), value)
+	}
+	db.Flush()
+	for i := 0; i < 10; i++ {
+		db.Put(fmt.Sprintf("m%d", i), value)
+	}
+
+	total := db.ApproximateSize("", "")
+	if total < 1000*100 {
+		t.Fatalf("total size %d for 100KB of values", total)
+	}
+	half := db.ApproximateSize("k0000", "k0499")
+	if ratio := float64(half) / float64(total); ratio < 0.4 || ratio > 0.6 {
+		t.Fatalf("half the keys take %d of %d bytes", half, total)
+	}
+	if n := db.ApproximateKeyCount("", ""); n < 950 || n > 1060 {
+		t.Fatalf("key count %d, want about 1010", n)
+	}
+	if n := db.ApproximateKeyCount("k0100", "k0199"); n < 80 || n > 130 {
+		t.Fatalf("key count of k0100..k0199 = %d, want about 100", n)
+	}
+	// Memtable entries are counted exactly.
+	if n := db.ApproximateKeyCount("m", "n"); n != 10 {
+		t.Fatalf("memtable key count %d, want 10", n)
+	}
+}
+
+func TestApproximateSizeOutsideData(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	if size := db.ApproximateSize("", ""); size != 0 {
+		t.Fatalf("empty db size %d", size)
+	}
+	db.Put("b", "1")
+	db.Flush()
+	db.Put("c", "1")
+	for _, r := range [][2]string{{"d", "z"}, {"", "a"}, {"ba", "bz"}} {
+		if size, n := db.ApproximateSize(r[0], r[1]), db.ApproximateKeyCount(r[0], r[1]); size != 0 || n != 0 {
+			t.Errorf("%s..%s: %d bytes, %d keys", r[0], r[1], size, n)
+		}
+	}
+}
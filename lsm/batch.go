@@ -0,0 +1,64 @@
+package lsm
+
+import "sort"
+
+type batchOp struct {
+	cf *ColumnFamily
+	kind Kind
+	key string
+	value string
+}
+
+// family resolves the op's column family, a nil family meaning the default.
+func (op batchOp) family(db *DB) *ColumnFamily {
+	if op.cf == nil {
+		return db.DefaultColumnFamily()
+	}
+	return op.cf
+}
+
+// batchFamilies returns the distinct families ops write to, by id.
+func batchFamilies(db *DB, ops []batchOp) []*ColumnFamily {
+	var families []*ColumnFamily
+	for _, op := range ops {
+		cf := op.family(db)
+		i := sort.Search(len(families), func(i int) bool { return families[i].id >= cf.id })
+		if i < len(families) && families[i] == cf {
+			continue
+		}
+		families = append(families[:i], append([]*ColumnFamily{cf}, families[i:]...)...)
+	}
+	return families
+}
+
+type WriteBatch struct {
+	ops []batchOp
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (batch *WriteBatch) Put(key string, value string) {
+	batch.PutCF(nil, key, value)
+}
+
+func (batch *WriteBatch) PutCF(cf *ColumnFamily, key string, value string) {
+	batch.ops = append(batch.ops, batchOp{cf: cf, kind: KindPut, key: key, value: value})
+}
+
+func (batch *WriteBatch) Delete(key string) {
+	batch.DeleteCF(nil, key)
+}
+
+func (batch *WriteBatch) DeleteCF(cf *ColumnFamily, key string) {
+	batch.ops = append(batch.ops, batchOp{cf: cf, kind: KindDelete, key: key})
+}
+
+func (batch *WriteBatch) Len() int {
+	return len(batch.ops)
+}
+
+func (batch *WriteBatch) Clear() {
+	batch.ops = batch.ops[:0]
+}
@@ -0,0 +1,301 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"distributedstore/vfs"
+)
+
+// Values of at least a family's MinBlobSize are separated from their keys
+// when a memtable is flushed: the value is appended to a blob file in the
+// family's directory, and the SSTable stores a reference to it as a
+// "B <seq> <shared> <suffix> <ref>" entry, prefix compressed like the others
+// (tables from before that hold "BLOB <seq> <key> <ref>" lines). Until then
+// the value lives in the WAL and memtable as usual. Compactions carry
+// references along without reading the values, except to apply a TTL or
+// compaction filter, or to move values out of a blob file whose live ratio
+// has fallen below BlobGCLiveRatio. A blob file no live table references is
+// deleted.
+//
+// A blob file holds one "<key> <value>" line per value, each sealed on its
+// own line if the file is encrypted.
+
+var blobFileRe = regexp.MustCompile(`^blob-(\d+)\.blob$`)
+
+func blobFileName(id int) string {
+	return fmt.Sprintf("blob-%06d.blob", id)
+}
+
+// blobRef locates a value in a blob file: the line holding it starts at
+// offset and is size bytes long, newline included. It is stored as
+// "<file>:<offset>:<size>".
+type blobRef struct {
+	file int
+	offset int64
+	size int64
+}
+
+func (r blobRef) String() string {
+	return fmt.Sprintf("%d:%d:%d", r.file, r.offset, r.size)
+}
+
+func parseBlobRef(s string) (blobRef, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return blobRef{}, fmt.Errorf("malformed blob reference %q", s)
+	}
+	file, err1 := strconv.Atoi(parts[0])
+	offset, err2 := strconv.ParseInt(parts[1], 10, 64)
+	size, err3 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || offset < 0 || size <= 0 {
+		return blobRef{}, fmt.Errorf("malformed blob reference %q", s)
+	}
+	return blobRef{file: file, offset: offset, size: size}, nil
+}
+
+// blobFile is a blob file installed in a family. size counts the bytes of
+// its values' lines, against which the bytes live tables reference give its
+// live ratio.
+type blobFile struct {
+	id int
+	path string
+	size int64
+	cipher *fileCipher
+}
+
+func discoverBlobFiles(fs vfs.FS, dir string) []tableMeta {
+	names, _ := fs.List(dir)
+	var files []tableMeta
+	for _, name := range names {
+		m := blobFileRe.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(m[1])
+		files = append(files, tableMeta{id: id, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].id < files[j].id
+	})
+	return files
+}
+
+// openBlobFile reads the header of an existing blob file.
+func openBlobFile(fs vfs.FS, keys KeyProvider, id int, path string) (*blobFile, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sc, err := newLineScanner(file, keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &blobFile{id: id, path: path, size: info.Size() - sc.End(), cipher: sc.cipher}, nil
+}
+
+// blobWriter appends values to a new blob file.
+type blobWriter struct {
+	fs vfs.FS
+	blob *blobFile
+	file vfs.File
+	writer *bufio.Writer
+	offset int64
+	limiter *RateLimiter
+	pri IOPriority
+	unpaid int64
+}
+
+func newBlobWriter(fs vfs.FS, keys KeyProvider, id int, dir string) (*blobWriter, error) {
+	c, err := currentCipher(keys)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, blobFileName(id))
+	file, err := fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &blobWriter{
+		fs: fs,
+		blob: &blobFile{id: id, path: path, cipher: c},
+		file: file,
+		writer: bufio.NewWriterSize(file, 64<<10),
+	}
+	if c != nil {
+		n, _ := w.writer.WriteString(c.header())
+		w.offset += int64(n)
+	}
+	return w, nil
+}
+
+func (w *blobWriter) rateLimit(rl *RateLimiter, pri IOPriority) {
+	w.limiter = rl
+	w.pri = pri
+}
+
+func (w *blobWriter) add(key string, value string) blobRef {
+	line := []byte(key + " " + value + "\n")
+	if w.blob.cipher != nil {
+		line = w.blob.cipher.seal(line, w.offset)
+	}
+	if w.limiter != nil {
+		w.unpaid += int64(len(line))
+		if w.unpaid >= rateLimitChunk {
+			w.limiter.Request(w.unpaid, w.pri)
+			w.unpaid = 0
+		}
+	}
+	n, _ := w.writer.Write(line)
+	ref := blobRef{file: w.blob.id, offset: w.offset, size: int64(n)}
+	w.offset += int64(n)
+	w.blob.size += int64(n)
+	return ref
+}
+
+// finish syncs the blob file, which must be durable before any table
+// referencing it is installed.
+func (w *blobWriter) finish() (*blobFile, error) {
+	w.limiter.Request(w.unpaid, w.pri)
+	if err := w.writer.Flush(); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	w.file.Close()
+	return w.blob, nil
+}
+
+func (w *blobWriter) abandon() {
+	w.file.Close()
+	w.fs.Remove(w.blob.path)
+}
+
+// readBlob reads the value ref points to for key, through the block cache.
+func (db *DB) readBlob(blob *blobFile, ref blobRef, key string) (string, error) {
+	if blob == nil {
+		return "", fmt.Errorf("lsm: blob file %s of key %q is missing", blobFileName(ref.file), key)
+	}
+	cacheKey := blockKey{path: blob.path, offset: ref.offset}
+	if value, ok := db.cache.get(cacheKey); ok {
+		return value.(string), nil
+	}
+
+	file, err := db.fs.Open(blob.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, ref.size)
+	if n, err := file.ReadAt(buf, ref.offset); n < len(buf) {
+		return "", fmt.Errorf("lsm: %s: short read at %d: %v", blob.path, ref.offset, err)
+	}
+	data := bytes.TrimSuffix(buf, []byte("\n"))
+	if blob.cipher != nil {
+		if data, err = blob.cipher.open(data, ref.offset); err != nil {
+			return "", fmt.Errorf("lsm: %s at %d: %v", blob.path, ref.offset, err)
+		}
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	stored, value, ok := strings.Cut(string(data), " ")
+	if !ok || stored != key {
+		return "", fmt.Errorf("lsm: %s at %d does not hold key %q", blob.path, ref.offset, key)
+	}
+	db.cache.put(cacheKey, value, ref.size)
+	return value, nil
+}
+
+// separates reports whether a value is big enough to go to a blob file.
+func (cf *ColumnFamily) separates(value string) bool {
+	return cf.opts.MinBlobSize > 0 && len(value) >= cf.opts.MinBlobSize
+}
+
+// blobLiveBytes sums the bytes the live tables of cf reference in each of
+// its blob files.
+func (cf *ColumnFamily) blobLiveBytes() map[int]int64 {
+	live := make(map[int]int64)
+	for _, t := range cf.sstables {
+		for id, n := range t.blobs {
+			live[id] += n
+		}
+	}
+	return live
+}
+
+// blobGCFiles returns the blob files of cf that are still referenced but
+// whose live ratio is below BlobGCLiveRatio, so compaction should move their
+// values elsewhere.
+func (cf *ColumnFamily) blobGCFiles() map[int]bool {
+	if len(cf.blobs) == 0 {
+		return nil
+	}
+	live := cf.blobLiveBytes()
+	gc := make(map[int]bool)
+	for id, blob := range cf.blobs {
+		if n := live[id]; n > 0 && float64(n) < cf.opts.BlobGCLiveRatio*float64(blob.size) {
+			gc[id] = true
+		}
+	}
+	return gc
+}
+
+// removeDeadBlobs drops the blob files no live table references from cf and
+// returns them for deletion.
+func (cf *ColumnFamily) removeDeadBlobs() []*blobFile {
+	live := cf.blobLiveBytes()
+	var dead []*blobFile
+	for id, blob := range cf.blobs {
+		if live[id] == 0 {
+			dead = append(dead, blob)
+			delete(cf.blobs, id)
+		}
+	}
+	return dead
+}
+
+// blobGCSpanLocked returns the key range of the tables of cf referencing
+// blob files due for garbage collection.
+func (db *DB) blobGCSpanLocked(cf *ColumnFamily) (string, string, bool) {
+	gc := cf.blobGCFiles()
+	var lo, hi string
+	found := false
+	for _, t := range cf.sstables {
+		referenced := false
+		for id := range t.blobs {
+			referenced = referenced || gc[id]
+		}
+		if !referenced {
+			continue
+		}
+		if !found || db.cmp.Compare(t.smallest, lo) < 0 {
+			lo = t.smallest
+		}
+		if !found || db.cmp.Compare(t.largest, hi) > 0 {
+			hi = t.largest
+		}
+		found = true
+	}
+	return lo, hi, found
+}
+
+// blobCompaction is what a compaction needs to handle separated values: the
+// blob files of the family, and those being garbage collected, whose values
+// it moves to a blob file of its own.
+type blobCompaction struct {
+	files map[int]*blobFile
+	gc map[int]bool
+}
@@ -0,0 +1,127 @@
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"distributedstore/vfs"
+)
+
+func TestLargeValuesGoToBlobFiles(t *testing.T) {
+	fs := vfs.NewMemFS()
+	separate := func(opts *Options) {
+		opts.MinBlobSize = 100
+		opts.MinCompact = 100
+	}
+	db := openTestDBOn(t, fs, separate)
+	big := strings.Repeat("b", 1000)
+	db.Put("small", "s")
+	db.Put("big", big)
+	db.Flush()
+
+	s := db.Stats()
+	if s.BlobFiles != 1 || s.BlobLiveBytes < 1000 || s.BlobBytesWritten < 1000 {
+		t.Fatalf("blob files %d, live bytes %d, written %d", s.BlobFiles, s.BlobLiveBytes, s.BlobBytesWritten)
+	}
+	if table := familyTables(db, db.DefaultColumnFamily())[0]; table.size >= 1000 {
+		t.Fatalf("table of %d bytes holds the large value", table.size)
+	}
+	mustGet(t, db, "big", big)
+	mustGet(t, db, "small", "s")
+	db.Close()
+
+	db = openTestDBOn(t, fs, separate)
+	defer db.Close()
+	mustGet(t, db, "big", big)
+}
+
+func TestCompactionCollectsMostlyDeadBlobFiles(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinBlobSize = 100
+		opts.MinCompact = 100
+	})
+	defer db.Close()
+	big := strings.Repeat("b", 1000)
+	for i := 0; i < 10; i++ {
+		db.Put(fmt.Sprintf("k%d", i), big)
+	}
+	db.Flush()
+	old := db.Stats().BlobFileBytes
+	for i := 2; i < 10; i++ {
+		db.Put(fmt.Sprintf("k%d", i), "small")
+	}
+	db.Flush()
+
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// The compaction drops the overwritten values, leaving two of ten live
+	// in the blob file. That is below the live ratio, so a background
+	// compaction moves them and the old file goes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := db.Stats()
+		if s.BlobFiles == 1 && s.BlobFileBytes < old/2 && s.BlobLiveBytes == s.BlobFileBytes {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no gc: %d files, %d bytes of which %d live; was %d bytes", s.BlobFiles, s.BlobFileBytes, s.BlobLiveBytes, old)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mustGet(t, db, "k0", big)
+	mustGet(t, db, "k1", big)
+	mustGet(t, db, "k5", "small")
+}
+
+func TestVerifyReportsMissingBlobFile(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, func(opts *Options) { opts.MinBlobSize = 100 })
+	db.Put("big", strings.Repeat("b", 1000))
+	db.Flush()
+	db.Close()
+
+	blobs := discoverBlobFiles(fs, "/db/ssts")
+	if len(blobs) != 1 {
+		t.Fatalf("%d blob files", len(blobs))
+	}
+	fs.Remove(blobs[0].path)
+	opts := DefaultOptions()
+	opts.FS = fs
+	report, err := Verify("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Fatal("verify passed a table whose blob file is gone")
+	}
+}
+
+func TestUnreadableBlobFileFailsReads(t *testing.T) {
+	mem := vfs.NewMemFS()
+	separate := func(opts *Options) { opts.MinBlobSize = 100 }
+	db := openTestDBOn(t, mem, separate)
+	db.Put("big", strings.Repeat("b", 1000))
+	db.Flush()
+	db.Close()
+
+	fault := vfs.NewFaultFS(mem)
+	db = openTestDBOn(t, fault, separate)
+	defer db.Close()
+	fault.FailOn(vfs.OpRead, "blob-*.blob")
+	if _, _, err := db.Get("big"); err == nil {
+		t.Fatal("read a value whose blob file cannot be read")
+	}
+	it := db.NewPrefixIterator("b")
+	for ; it.Valid(); it.Next() {
+	}
+	if it.Err() == nil {
+		t.Fatal("prefix iterator hid an unreadable blob file")
+	}
+	it.Close()
+	fault.SetInjector(nil)
+	mustGet(t, db, "big", strings.Repeat("b", 1000))
+}
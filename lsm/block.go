@@ -0,0 +1,181 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An SSTable is a text file of blocks, each listed in the table's index by
+// its first key. A block holds entry lines
+//
+//	P <seq> <shared> <suffix> <value>
+//	B <seq> <shared> <suffix> <blob ref>
+//	D <seq> <shared> <suffix>
+//
+// whose key is the first <shared> bytes of the key before it followed by
+// <suffix>, and ends with a line "R <offset>..." giving where its restart
+// points start within it. A restart point stores its key in full; there is
+// one every BlockRestartInterval entries from the start of the block.
+// Tables from before prefix compression hold "PUT <seq> <key> <value>",
+// "BLOB <seq> <key> <ref>" and "DEL <seq> <key>" lines, and no restart
+// lines.
+
+// blockEntry is one decoded entry of a block.
+type blockEntry struct {
+	key string
+	seq int
+	kind Kind
+	value string
+}
+
+// dataBlock is a block as read from its table, with the restart line split
+// off.
+type dataBlock struct {
+	data string
+	restarts []int
+}
+
+// parseBlock reads a block. One without a restart line, from before prefix
+// compression, has a restart point at every line.
+func parseBlock(data string) *dataBlock {
+	b := &dataBlock{data: data}
+	body := strings.TrimSuffix(data, "\n")
+	i := strings.LastIndexByte(body, '\n')
+	if last := body[i+1:]; isRestartLine(last) {
+		b.data = data[:i+1]
+		for _, field := range strings.Fields(last)[1:] {
+			if offset, err := strconv.Atoi(field); err == nil && offset < len(b.data) {
+				b.restarts = append(b.restarts, offset)
+			}
+		}
+		return b
+	}
+	for offset := 0; offset < len(b.data); {
+		b.restarts = append(b.restarts, offset)
+		n := strings.IndexByte(b.data[offset:], '\n')
+		if n < 0 {
+			break
+		}
+		offset += n + 1
+	}
+	return b
+}
+
+func isRestartLine(line string) bool {
+	return strings.HasPrefix(line, "R ")
+}
+
+// entryAt decodes the entry whose line starts at offset, following the one
+// with key prev, and returns it with the offset of the next line.
+func (b *dataBlock) entryAt(offset int, prev string) (blockEntry, int, error) {
+	line := b.data[offset:]
+	next := len(b.data)
+	if n := strings.IndexByte(line, '\n'); n >= 0 {
+		line, next = line[:n], offset+n+1
+	}
+	e, err := parseTableEntry(line, prev)
+	return e, next, err
+}
+
+// seek returns the offset of the last restart point whose key is at or
+// before key, or of the first one if there is none.
+func (b *dataBlock) seek(cmp Comparator, key string) int {
+	found := 0
+	for l, r := 0, len(b.restarts)-1; l <= r; {
+		m := (l + r) / 2
+		e, _, err := b.entryAt(b.restarts[m], "")
+		if err == nil && cmp.Compare(e.key, key) <= 0 {
+			found = m
+			l = m + 1
+		} else {
+			r = m - 1
+		}
+	}
+	if len(b.restarts) == 0 {
+		return len(b.data)
+	}
+	return b.restarts[found]
+}
+
+// entries decodes the whole block. An entry that does not parse is left
+// out, as are those after it up to the next restart point.
+func (b *dataBlock) entries() []blockEntry {
+	var entries []blockEntry
+	prev := ""
+	for offset := 0; offset < len(b.data); {
+		e, next, err := b.entryAt(offset, prev)
+		offset, prev = next, e.key
+		if err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// sharedPrefix returns the length of the longest common prefix of a and b.
+func sharedPrefix(a string, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// parseTableEntry decodes an entry line of either format, following the
+// entry with key prev.
+func parseTableEntry(line string, prev string) (blockEntry, error) {
+	op, rest, _ := strings.Cut(line, " ")
+	var seqField, key, value string
+	hasValue := false
+	switch op {
+	case "P", "B", "D":
+		parts := strings.SplitN(rest, " ", 4)
+		if len(parts) < 3 {
+			return blockEntry{}, errors.New("malformed entry")
+		}
+		shared, err := strconv.Atoi(parts[1])
+		if err != nil || shared < 0 || shared > len(prev) {
+			return blockEntry{}, fmt.Errorf("bad shared prefix length %q", parts[1])
+		}
+		seqField, key = parts[0], prev[:shared]+parts[2]
+		if len(parts) == 4 {
+			value, hasValue = parts[3], true
+		}
+	case "PUT", "BLOB", "DEL":
+		parts := strings.SplitN(rest, " ", 3)
+		if len(parts) < 2 {
+			return blockEntry{}, errors.New("malformed entry")
+		}
+		seqField, key = parts[0], parts[1]
+		if len(parts) == 3 {
+			value, hasValue = parts[2], true
+		}
+	default:
+		return blockEntry{}, errors.New("malformed entry")
+	}
+	isDelete := op == "D" || op == "DEL"
+	if key == "" || isDelete == hasValue {
+		return blockEntry{}, errors.New("malformed entry")
+	}
+	seq, err := strconv.Atoi(seqField)
+	if err != nil || seq < 0 {
+		return blockEntry{}, fmt.Errorf("bad sequence number %q", seqField)
+	}
+	e := blockEntry{key: key, seq: seq, kind: KindPut}
+	switch {
+	case isDelete:
+		e.kind = KindDelete
+	case op == "B" || op == "BLOB":
+		if _, err := parseBlobRef(value); err != nil {
+			return blockEntry{}, err
+		}
+		e.kind, e.value = KindBlobIndex, value
+	default:
+		e.value = value
+	}
+	return e, nil
+}
@@ -0,0 +1,93 @@
+package lsm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseTableEntry(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		prev string
+		want blockEntry
+	}{
+		{"P 7 0 apple red", "", blockEntry{key: "apple", seq: 7, kind: KindPut, value: "red"}},
+		{"P 8 2 ricot orange", "apple", blockEntry{key: "apricot", seq: 8, kind: KindPut, value: "orange"}},
+		{"D 9 5 s", "apple", blockEntry{key: "apples", seq: 9, kind: KindDelete}},
+		{"PUT 3 key two words", "ignored", blockEntry{key: "key", seq: 3, kind: KindPut, value: "two words"}},
+		{"DEL 4 key", "", blockEntry{key: "key", seq: 4, kind: KindDelete}},
+	} {
+		got, err := parseTableEntry(c.line, c.prev)
+		if err != nil || got != c.want {
+			t.Errorf("parseTableEntry(%q, %q) = %+v, %v; want %+v", c.line, c.prev, got, err, c.want)
+		}
+	}
+	for _, c := range [][2]string{
+		{"P 1 6 x v", "apple"},
+		{"P 1 -1 x v", "apple"},
+		{"P x 0 k v", ""},
+		{"D 1 0 k v", ""},
+		{"P 1 0 k", ""},
+		{"P 1 0  v", ""},
+		{"Q 1 0 k v", ""},
+	} {
+		if e, err := parseTableEntry(c[0], c[1]); err == nil {
+			t.Errorf("parseTableEntry(%q, %q) = %+v, want an error", c[0], c[1], e)
+		}
+	}
+}
+
+func TestPrefixCompressedBlocks(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.BlockRestartInterval = 4 })
+	defer db.Close()
+	prefix := strings.Repeat("common-prefix/", 4)
+	var keyBytes int
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("%s%04d", prefix, i)
+		keyBytes += len(key)
+		db.Put(key, "v")
+	}
+	db.Flush()
+
+	table := familyTables(db, db.DefaultColumnFamily())[0]
+	if table.size >= int64(keyBytes) {
+		t.Fatalf("table of %d bytes for %d bytes of keys", table.size, keyBytes)
+	}
+	for i := 0; i < 200; i += 7 {
+		mustGet(t, db, fmt.Sprintf("%s%04d", prefix, i), "v")
+	}
+	mustMiss(t, db, prefix+"0200")
+}
+
+func TestBlockSeekUsesRestartPoints(t *testing.T) {
+	var b strings.Builder
+	prev := ""
+	var restarts []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		shared := sharedPrefix(prev, key)
+		if i%4 == 0 {
+			shared = 0
+			restarts = append(restarts, fmt.Sprint(b.Len()))
+		}
+		fmt.Fprintf(&b, "P %d %d %s v\n", i, shared, key[shared:])
+		prev = key
+	}
+	block := parseBlock(b.String() + "R " + strings.Join(restarts, " ") + "\n")
+	if len(block.restarts) != 3 || len(block.entries()) != 10 {
+		t.Fatalf("%d restarts, %d entries", len(block.restarts), len(block.entries()))
+	}
+	if offset := block.seek(BytewiseComparator, "key06"); offset != block.restarts[1] {
+		t.Fatalf("seek(key06) = %d, want the restart at %d", offset, block.restarts[1])
+	}
+	if offset := block.seek(BytewiseComparator, "a"); offset != block.restarts[0] {
+		t.Fatalf("seek before the block = %d", offset)
+	}
+
+	// A block from before prefix compression restarts at every line.
+	legacy := parseBlock("PUT 1 a 1\nPUT 2 b 2\nDEL 3 c\n")
+	if len(legacy.restarts) != 3 || len(legacy.entries()) != 3 {
+		t.Fatalf("legacy block: %d restarts, %d entries", len(legacy.restarts), len(legacy.entries()))
+	}
+}
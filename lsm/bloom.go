@@ -2,6 +2,7 @@ package lsm
 
 import (
 	"hash/fnv"
+	"math/bits"
 )
 
 type BloomFilter struct {
@@ -61,3 +62,11 @@ func (bf *BloomFilter) mightContain(key string) bool {
     }
     return true
 }
+
+func (bf *BloomFilter) bitsSet() int {
+	n := 0
+	for _, w := range bf.bits {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
@@ -0,0 +1,91 @@
+package lsm
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+type blockKey struct {
+	path string
+	offset int64
+}
+
+type cachedBlock struct {
+	key blockKey
+	value any
+	size int64
+}
+
+// blockCache is an LRU cache of SSTable blocks and blob file values, bounded
+// by their size on disk. A zero capacity disables caching but still counts misses.
+type blockCache struct {
+	mu sync.Mutex
+	capacity int64
+	usage int64
+	// indexUsage is the part of usage taken by index partitions.
+	indexUsage int64
+	ll *list.List
+	items map[blockKey]*list.Element
+	hits atomic.Int64
+	misses atomic.Int64
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		ll: list.New(),
+		items: make(map[blockKey]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key blockKey) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).value, true
+}
+
+func (c *blockCache) put(key blockKey, value any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.capacity {
+		return
+	}
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cachedBlock{key: key, value: value, size: size})
+	c.usage += size
+	if _, ok := value.([]IndexEntry); ok {
+		c.indexUsage += size
+	}
+	for c.usage > c.capacity {
+		oldest := c.ll.Back()
+		block := oldest.Value.(*cachedBlock)
+		c.ll.Remove(oldest)
+		delete(c.items, block.key)
+		c.usage -= block.size
+		if _, ok := block.value.([]IndexEntry); ok {
+			c.indexUsage -= block.size
+		}
+	}
+}
+
+func (c *blockCache) Usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+func (c *blockCache) IndexUsage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.indexUsage
+}
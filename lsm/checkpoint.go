@@ -0,0 +1,184 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"distributedstore/vfs"
+)
+
+// Checkpoint writes a consistent copy of the DB into dir, which must not
+// exist yet, while writes continue. The memtables are flushed first to keep
+// the WALs short, every live SSTable and blob file is hard-linked (or copied
+// when dir is on another filesystem), and the WALs are copied, so writes a
+// failed flush left only in a memtable are kept too. The result can be
+// opened with Open.
+func (db *DB) Checkpoint(dir string) error {
+	if _, err := db.fs.Stat(dir); err == nil {
+		return fmt.Errorf("lsm: checkpoint dir %s already exists", dir)
+	}
+
+	db.disableFileDeletions()
+	defer db.enableFileDeletions()
+
+	db.Flush()
+
+	tmp := dir + ".tmp"
+	db.fs.RemoveAll(tmp)
+	if err := db.fs.MkdirAll(filepath.Join(tmp, "wals"), 0o755); err != nil {
+		return err
+	}
+	if err := db.fs.MkdirAll(filepath.Join(tmp, "ssts"), 0o755); err != nil {
+		return err
+	}
+
+	// Writes append to the WAL under the write lock, so holding it shared
+	// while copying them yields whole records that match the manifest.
+	db.mu.RLock()
+	m := db.manifestLocked()
+	type liveTable struct {
+		path string
+		dir string
+	}
+	var tables []liveTable
+	for _, cf := range db.families {
+		rel, _ := filepath.Rel(db.dir, cf.dir)
+		for _, sstable := range cf.sstables {
+			tables = append(tables, liveTable{path: sstable.path, dir: rel})
+		}
+		for _, blob := range cf.blobs {
+			tables = append(tables, liveTable{path: blob.path, dir: rel})
+		}
+	}
+	for _, w := range discoverWALs(db.fs, filepath.Join(db.dir, "wals")) {
+		if db.deletePending(w.path) {
+			continue
+		}
+		if err := copyFile(db.fs, w.path, filepath.Join(tmp, "wals", filepath.Base(w.path))); err != nil {
+			db.mu.RUnlock()
+			db.fs.RemoveAll(tmp)
+			return err
+		}
+	}
+	db.mu.RUnlock()
+
+	for _, f := range m.families {
+		if err := db.fs.MkdirAll(filepath.Join(tmp, "cf", f.name), 0o755); err != nil {
+			db.fs.RemoveAll(tmp)
+			return err
+		}
+	}
+	for _, table := range tables {
+		target := filepath.Join(tmp, table.dir, filepath.Base(table.path))
+		if err := linkOrCopy(db.fs, table.path, target); err != nil {
+			db.fs.RemoveAll(tmp)
+			return err
+		}
+	}
+	if err := writeManifest(db.fs, tmp, m); err != nil {
+		db.fs.RemoveAll(tmp)
+		return err
+	}
+	return db.fs.Rename(tmp, dir)
+}
+
+func (db *DB) disableFileDeletions() {
+	db.deleteMu.Lock()
+	db.deletionsDisabled++
+	db.deleteMu.Unlock()
+}
+
+// deletePending reports whether path is obsolete and waiting to be removed
+// until file deletions are enabled again.
+func (db *DB) deletePending(path string) bool {
+	db.deleteMu.Lock()
+	defer db.deleteMu.Unlock()
+	for _, f := range db.pendingDeletes {
+		if f.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) enableFileDeletions() {
+	db.deleteMu.Lock()
+	db.deletionsDisabled--
+	var pending []obsoleteFile
+	if db.deletionsDisabled == 0 {
+		pending = db.pendingDeletes
+		db.pendingDeletes = nil
+	}
+	db.deleteMu.Unlock()
+
+	for _, f := range pending {
+		db.removeFile(f)
+	}
+}
+
+// obsoleteFile is a WAL or SSTable waiting to be removed. table is set for
+// SSTables, to tell listeners once the file is actually gone.
+type obsoleteFile struct {
+	path string
+	table *TableFileInfo
+}
+
+func (db *DB) deleteFile(path string) {
+	db.scheduleDelete(obsoleteFile{path: path})
+}
+
+func (db *DB) deleteTable(cf *ColumnFamily, t *SSTable, reason TableFileReason) {
+	info := db.tableFileInfo(cf, t, reason)
+	db.scheduleDelete(obsoleteFile{path: t.path, table: &info})
+}
+
+// scheduleDelete removes an obsolete file, or defers the removal while a
+// checkpoint is linking files.
+func (db *DB) scheduleDelete(f obsoleteFile) {
+	db.deleteMu.Lock()
+	if db.deletionsDisabled > 0 {
+		db.pendingDeletes = append(db.pendingDeletes, f)
+		db.deleteMu.Unlock()
+		return
+	}
+	db.deleteMu.Unlock()
+	db.removeFile(f)
+}
+
+func (db *DB) removeFile(f obsoleteFile) {
+	if err := db.fs.Remove(f.path); err != nil {
+		return
+	}
+	if f.table != nil {
+		db.notify(func(l EventListener) { l.OnTableFileDeleted(*f.table) })
+	}
+}
+
+func linkOrCopy(fs vfs.FS, src string, dst string) error {
+	if err := fs.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(fs, src, dst)
+}
+
+func copyFile(fs vfs.FS, src string, dst string) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
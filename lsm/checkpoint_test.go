@@ -0,0 +1,76 @@
+package lsm
+
+import (
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestCheckpointOpensAsCopy(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, nil)
+	defer db.Close()
+	db.Put("flushed", "1")
+	db.Flush()
+	db.Put("unflushed", "2")
+
+	// The flush the checkpoint starts with fails, so the second write is
+	// only in the WAL.
+	fault.FailOn(vfs.OpCreate, "sst-*.tmp")
+	if err := db.Checkpoint("/snap"); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	fault.SetInjector(nil)
+	db.Put("later", "3")
+
+	opts := DefaultOptions()
+	opts.FS = fault
+	snap, err := Open("/snap", opts)
+	if err != nil {
+		t.Fatalf("open checkpoint: %v", err)
+	}
+	defer snap.Close()
+	mustGet(t, snap, "flushed", "1")
+	mustGet(t, snap, "unflushed", "2")
+	mustMiss(t, snap, "later")
+	snap.Put("flushed", "changed")
+	mustGet(t, db, "flushed", "1")
+}
+
+func TestCheckpointFailureLeavesNoDir(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, nil)
+	defer db.Close()
+	db.Put("a", "1")
+	db.Flush()
+
+	if err := db.fs.MkdirAll("/exists", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint("/exists"); err == nil {
+		t.Fatal("checkpoint into an existing dir succeeded")
+	}
+
+	// A link is reported by its source, and the copy it falls back to by
+	// its target.
+	fault.SetInjector(func(op vfs.Op, name string) error {
+		if (op == vfs.OpLink || op == vfs.OpCreate && strings.HasPrefix(name, "/snap.tmp/")) && strings.HasSuffix(name, ".sst") {
+			return vfs.ErrInjected
+		}
+		return nil
+	})
+	if err := db.Checkpoint("/snap"); err == nil {
+		t.Fatal("checkpoint succeeded without its tables")
+	}
+	fault.SetInjector(nil)
+	for _, dir := range []string{"/snap", "/snap.tmp"} {
+		if _, err := fault.Stat(dir); err == nil {
+			t.Errorf("%s left behind by a failed checkpoint", dir)
+		}
+	}
+	mustGet(t, db, "a", "1")
+	if err := db.Checkpoint("/snap"); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
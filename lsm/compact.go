@@ -2,31 +2,475 @@ package lsm
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
-	"os"
-	"bufio"
+	"maps"
 	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 )
 
+// minSubcompactionBytes is the least input a subcompaction is given; smaller
+// compactions are not split.
+const minSubcompactionBytes = 1 << 20
+
+// compactionCancelCheck is how many keys a subcompaction merges between
+// checks for cancellation.
+const compactionCancelCheck = 256
+
+// compactor schedules compactions onto at most maxCompactions workers. A
+// family is compacted by one worker at a time, so running compactions never
+// share input tables; different families compact in parallel.
 func (db *DB) compactor() {
-    defer db.compactWg.Done()
-    for range db.compactCh {
-        db.compact()
-    }
+	defer db.compactWg.Done()
+	done := make(chan *ColumnFamily)
+	signals := db.compactCh
+	running := 0
+	closing := false
+	for !closing || running > 0 {
+		select {
+		case _, ok := <-signals:
+			if !ok {
+				closing = true
+				signals = nil
+			}
+		case cf := <-done:
+			running--
+			db.mu.Lock()
+			cf.compacting = false
+			db.compactCond.Broadcast()
+			db.mu.Unlock()
+		}
+		if closing {
+			continue
+		}
+
+		db.mu.Lock()
+		for _, cf := range db.families {
+			if running >= db.maxCompactions {
+				break
+			}
+			if cf.compacting || !cf.compactionDue() {
+				continue
+			}
+			cf.compacting = true
+			running++
+			go func() {
+				db.compact(context.Background(), cf, "", "", false)
+				done <- cf
+			}()
+		}
+		db.mu.Unlock()
+	}
 }
 
-func (db *DB) compact() {
-	h := &IterHeap{}
-	heap.Init(h)
+// maybeScheduleCompaction wakes the compactor. Signals coalesce, since it
+// looks at every family each time it wakes.
+func (db *DB) maybeScheduleCompaction() {
+	select {
+	case db.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+// CompactRange compacts the tables holding keys from start to end inclusive,
+// after flushing the memtables so their entries are compacted too. An empty
+// bound leaves that side unbounded. It waits for a running compaction of the
+// family to finish first, and returns ctx.Err() if cancelled, in which case
+// the tables are left as they were.
+func (db *DB) CompactRange(ctx context.Context, start string, end string) error {
+	return db.CompactRangeCF(ctx, db.DefaultColumnFamily(), start, end)
+}
+
+func (db *DB) CompactRangeCF(ctx context.Context, cf *ColumnFamily, start string, end string) error {
+	db.Flush()
+
+	stop := context.AfterFunc(ctx, func() {
+		db.mu.Lock()
+		db.compactCond.Broadcast()
+		db.mu.Unlock()
+	})
+	defer stop()
+	db.mu.Lock()
+	for cf.compacting {
+		if err := ctx.Err(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		db.compactCond.Wait()
+	}
+	cf.compacting = true
+	db.mu.Unlock()
+
+	err := db.compact(ctx, cf, start, end, true)
+
+	db.mu.Lock()
+	cf.compacting = false
+	db.compactCond.Broadcast()
+	db.mu.Unlock()
+	db.maybeScheduleCompaction()
+	return err
+}
+
+// keyRange bounds a subcompaction to keys in [start, end). An empty bound is
+// unbounded.
+type keyRange struct {
+	start string
+	end string
+}
+
+// compact merges the tables of cf holding keys from start to end, or all of
+// them if both are empty. An automatic compaction takes the level-0 tables
+// instead, with the level-1 tables they overlap. The caller must have set
+// cf.compacting.
+func (db *DB) compact(ctx context.Context, cf *ColumnFamily, start string, end string, manual bool) error {
+	now := time.Now()
+
+	db.mu.Lock()
+	if !manual {
+		var ok bool
+		if start, end, ok = db.autoCompactionSpanLocked(cf); !ok {
+			db.mu.Unlock()
+			return nil
+		}
+	}
+	tables := db.compactionInputsLocked(cf, start, end)
+	bottommost := db.bottommostLocked(cf, tables)
+	blobs := blobCompaction{files: maps.Clone(cf.blobs), gc: cf.blobGCFiles()}
+	db.mu.Unlock()
+	if len(tables) == 0 {
+		return nil
+	}
+	var inputBytes int64
+	for _, t := range tables {
+		inputBytes += t.size
+	}
+	n := int(min(int64(db.maxSubcompactions), max(inputBytes/minSubcompactionBytes, 1)))
+
+	began := time.Now()
+	info := CompactionInfo{ColumnFamily: cf.name, InputBytes: inputBytes, Manual: manual}
+	for _, t := range tables {
+		info.Inputs = append(info.Inputs, t.path)
+	}
+	db.notify(func(l EventListener) { l.OnCompactionBegin(info) })
+
+	// Subcompactions cover disjoint key ranges, so their outputs can be
+	// written in parallel and installed side by side. A bottommost
+	// compaction writes to the bottom level; any other keeps its inputs'
+	// level, and its tombstones, above the tables it left out.
+	filterCtx := CompactionFilterContext{
+		ColumnFamily: cf.name,
+		OutputLevel: 1,
+		Bottommost: bottommost,
+		Manual: manual,
+	}
+	if !bottommost {
+		filterCtx.OutputLevel = 0
+		for _, t := range tables {
+			filterCtx.OutputLevel = max(filterCtx.OutputLevel, t.level)
+		}
+	}
+	ranges := db.splitCompaction(tables, n)
+	results := make([][]*SSTable, len(ranges))
+	blobResults := make([]*blobFile, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], blobResults[i], errs[i] = db.subcompact(ctx, cf, tables, r, filterCtx, now, blobs)
+		}()
+	}
+	wg.Wait()
+
+	var outputs []*SSTable
+	for _, result := range results {
+		outputs = append(outputs, result...)
+	}
+	var newBlobs []*blobFile
+	for _, blob := range blobResults {
+		if blob != nil {
+			newBlobs = append(newBlobs, blob)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			for _, output := range outputs {
+				db.fs.Remove(output.path)
+			}
+			for _, blob := range newBlobs {
+				db.fs.Remove(blob.path)
+			}
+			if ctx.Err() == nil {
+				db.backgroundError("compaction", cf, err)
+			}
+			return err
+		}
+	}
+
+	// Tables flushed while this compaction ran are newer than its outputs,
+	// so the outputs of a bottommost compaction go in front of them. Those of
+	// any other take the place of its newest input, above the older tables
+	// it left out. Nothing may survive at all, in which case there are no
+	// outputs.
+	for _, output := range outputs {
+		output.level = filterCtx.OutputLevel
+		db.stats.compactionBytesWritten.Add(output.size)
+		info.Outputs = append(info.Outputs, output.path)
+		info.OutputBytes += output.size
+	}
+	for _, blob := range newBlobs {
+		db.stats.blobBytesWritten.Add(blob.size)
+		info.OutputBytes += blob.size
+	}
+	db.stats.compactions.Add(1)
+	db.stats.compactionBytesRead.Add(inputBytes)
+
+	compacted := make(map[*SSTable]struct{}, len(tables))
+	for _, t := range tables {
+		compacted[t] = struct{}{}
+	}
+
+	db.mu.Lock()
+	var keep []*SSTable
+	at := 0
+	for _, t := range cf.sstables {
+		if _, wasCompacted := compacted[t]; wasCompacted {
+			at = len(keep)
+		} else {
+			keep = append(keep, t)
+		}
+	}
+	if bottommost {
+		at = 0
+	}
+	cf.sstables = slices.Concat(keep[:at], outputs, keep[at:])
+	for _, blob := range newBlobs {
+		cf.blobs[blob.id] = blob
+	}
+	dead := cf.removeDeadBlobs()
+	saveErr := db.saveManifestLocked()
+    db.mu.Unlock()
+
+	for _, output := range outputs {
+		created := db.tableFileInfo(cf, output, TableFileCompaction)
+		db.notify(func(l EventListener) { l.OnTableFileCreated(created) })
+	}
+	if saveErr != nil {
+		// The recorded manifest still lists the inputs, so they stay on
+		// disk until a later one drops them.
+		db.backgroundError("compaction", cf, saveErr)
+		return saveErr
+	}
+	for _, t := range tables {
+		db.deleteTable(cf, t, TableFileCompaction)
+	}
+	for _, blob := range dead {
+		db.deleteFile(blob.path)
+	}
+	info.Duration = time.Since(began)
+	db.notify(func(l EventListener) { l.OnCompactionCompleted(info) })
+	db.refreshWriteStall()
+	return nil
+}
+
+// compactionInputsLocked picks the tables overlapping start to end, then
+// keeps adding tables that overlap the picked ones until no table left out
+// shares a key with them.
+func (db *DB) compactionInputsLocked(cf *ColumnFamily, start string, end string) []*SSTable {
+	if start == "" && end == "" {
+		return append([]*SSTable(nil), cf.sstables...)
+	}
+	var inputs, rest []*SSTable
+	for _, t := range cf.sstables {
+		if db.overlaps(t, start, end) {
+			inputs = append(inputs, t)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	lo, hi := inputs[0].smallest, inputs[0].largest
+	for grown := true; grown; {
+		grown = false
+		for _, t := range inputs {
+			if db.cmp.Compare(t.smallest, lo) < 0 {
+				lo = t.smallest
+			}
+			if db.cmp.Compare(t.largest, hi) > 0 {
+				hi = t.largest
+			}
+		}
+		var left []*SSTable
+		for _, t := range rest {
+			if db.overlaps(t, lo, hi) {
+				inputs = append(inputs, t)
+				grown = true
+			} else {
+				left = append(left, t)
+			}
+		}
+		rest = left
+	}
+	return inputs
+}
+
+// bottommostLocked reports whether no table of cf but inputs may hold keys
+// in their range. No older version of an input key then survives outside
+// the compaction, so its tombstones can be dropped, and the outputs can go
+// anywhere in the table order.
+func (db *DB) bottommostLocked(cf *ColumnFamily, inputs []*SSTable) bool {
+	if len(inputs) == 0 {
+		return true
+	}
+	lo, hi := inputs[0].smallest, inputs[0].largest
+	for _, t := range inputs[1:] {
+		if db.cmp.Compare(t.smallest, lo) < 0 {
+			lo = t.smallest
+		}
+		if db.cmp.Compare(t.largest, hi) > 0 {
+			hi = t.largest
+		}
+	}
+	for _, t := range cf.sstables {
+		if !slices.Contains(inputs, t) && db.overlaps(t, lo, hi) {
+			return false
+		}
+	}
+	return true
+}
+
+// level0SpanLocked returns the key range covered by the level-0 tables of cf.
+func (db *DB) level0SpanLocked(cf *ColumnFamily) (string, string, bool) {
+	var lo, hi string
+	found := false
+	for _, t := range cf.sstables {
+		if t.level != 0 {
+			continue
+		}
+		if !found || db.cmp.Compare(t.smallest, lo) < 0 {
+			lo = t.smallest
+		}
+		if !found || db.cmp.Compare(t.largest, hi) > 0 {
+			hi = t.largest
+		}
+		found = true
+	}
+	return lo, hi, found
+}
+
+// autoCompactionSpanLocked returns the key range an automatic compaction of
+// cf covers: that of its level-0 tables once there are enough of them, and
+// that of the tables referencing blob files due for garbage collection.
+func (db *DB) autoCompactionSpanLocked(cf *ColumnFamily) (string, string, bool) {
+	var lo, hi string
+	found := false
+	if cf.level0Due() {
+		lo, hi, found = db.level0SpanLocked(cf)
+	}
+	if start, end, ok := db.blobGCSpanLocked(cf); ok {
+		if !found || db.cmp.Compare(start, lo) < 0 {
+			lo = start
+		}
+		if !found || db.cmp.Compare(end, hi) > 0 {
+			hi = end
+		}
+		found = true
+	}
+	return lo, hi, found
+}
+
+// compactionDue reports whether cf has gathered enough level-0 tables for an
+// automatic compaction, or has blob files to garbage collect.
+func (cf *ColumnFamily) compactionDue() bool {
+	return cf.level0Due() || len(cf.blobGCFiles()) > 0
+}
+
+func (cf *ColumnFamily) level0Due() bool {
+	n := 0
+	for _, t := range cf.sstables {
+		if t.level == 0 {
+			n++
+		}
+	}
+	return n >= cf.opts.MinCompact
+}
+
+// pendingCompactionBytesLocked estimates what the next automatic compaction
+// of cf rewrites.
+func (db *DB) pendingCompactionBytesLocked(cf *ColumnFamily) int64 {
+	start, end, ok := db.autoCompactionSpanLocked(cf)
+	if !ok {
+		return 0
+	}
+	var bytes int64
+	for _, t := range db.compactionInputsLocked(cf, start, end) {
+		bytes += t.size
+	}
+	return bytes
+}
+
+// overlaps reports whether t may hold keys from start to end inclusive, where
+// an empty bound is unbounded.
+func (db *DB) overlaps(t *SSTable, start string, end string) bool {
+	return (end == "" || db.cmp.Compare(t.smallest, end) <= 0) &&
+		(start == "" || db.cmp.Compare(t.largest, start) >= 0)
+}
 
-	db.mu.RLock()
-	tables := append([]*SSTable(nil), db.sstables...)
-	db.mu.RUnlock()
+// splitCompaction divides the key space of tables into at most n ranges of
+// roughly equal numbers of index entries.
+func (db *DB) splitCompaction(tables []*SSTable, n int) []keyRange {
+	var keys []string
+	if n > 1 {
+		for _, t := range tables {
+			for _, entry := range t.index {
+				keys = append(keys, entry.key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return []keyRange{{}}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return db.cmp.Compare(keys[i], keys[j]) < 0
+	})
 
+	var ranges []keyRange
+	last := ""
+	for i := 1; i < n; i++ {
+		boundary := keys[len(keys)*i/n]
+		if boundary == last || (last == "" && boundary == keys[0]) {
+			continue
+		}
+		ranges = append(ranges, keyRange{start: last, end: boundary})
+		last = boundary
+	}
+	return append(ranges, keyRange{start: last})
+}
+
+// subcompact merges the entries of tables within r into output tables of
+// about the family's target file size, keeping the newest version of each key
+// and dropping tombstones, expired values and whatever the compaction filter
+// removes; unless the compaction is bottommost, a dropped key leaves a
+// tombstone, over the older versions outside it. Outputs are cut between keys,
+// so each key lives in exactly one of them; none are written if nothing
+// survives. Separated values are only read when there is a TTL or filter to
+// apply, or when their blob file is being garbage collected; values that
+// change or move go to a blob file of the subcompaction's own.
+func (db *DB) subcompact(ctx context.Context, cf *ColumnFamily, tables []*SSTable, r keyRange, filterCtx CompactionFilterContext, now time.Time, blobs blobCompaction) ([]*SSTable, *blobFile, error) {
+	h := NewIterHeap(db.cmp)
+	heap.Init(h)
+	var inputs []*rangeIter
 	for _, sstable := range tables {
-		it := NewSSTableIter(sstable.path)
+		it := db.newRangeIter(sstable, r)
 		defer it.Close()
-		it.Next()
+		inputs = append(inputs, it)
 		if it.Valid() {
 			heap.Push(h, &HeapItem{
 				it: it,
@@ -35,21 +479,59 @@ func (db *DB) compact() {
 				kind: it.Kind(),
 				value: it.Value(),
 			})
-		} 
+		}
 	}
 
-	id := db.allocFileId()
-	tmp := filepath.Join(db.dir, "ssts", fmt.Sprintf("sst-%06d.compact.tmp", id))
-	target := filepath.Join(db.dir, "ssts", fmt.Sprintf("sst-%06d.sst", id))
-	filter := NewBloomFilter(bloomM, bloomK)
-	sstable := &SSTable{path: target, index: []IndexEntry{}, filter: filter}
-	file, _ := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-	writer := bufio.NewWriterSize(file, 64<<10)
-
-	var offset int64
-	i := 0
+	var outputs []*SSTable
+	var w *tableWriter
+	var bw *blobWriter
+	fail := func(err error) ([]*SSTable, *blobFile, error) {
+		if w != nil {
+			w.abandon()
+		}
+		if bw != nil {
+			bw.abandon()
+		}
+		for _, output := range outputs {
+			db.fs.Remove(output.path)
+		}
+		return nil, nil, err
+	}
+	// emit adds an entry to the current output, starting one if there is
+	// none and cutting it once it reaches the target file size.
+	emit := func(seq int, kind Kind, key string, value string) error {
+		if w == nil {
+			id := db.allocFileId()
+			tmp := filepath.Join(cf.dir, fmt.Sprintf("sst-%06d.compact.tmp", id))
+			target := filepath.Join(cf.dir, fmt.Sprintf("sst-%06d.sst", id))
+			var err error
+			if w, err = newTableWriter(db.fs, db.keys, tmp, target, cf.newFilter(), cf.blockFormat()); err != nil {
+				return err
+			}
+			w.rateLimit(db.limiter, IOPriorityLow)
+		}
+		w.add(seq, kind, key, value)
+		if w.offset >= cf.opts.TargetFileSize {
+			output, err := w.finish()
+			w = nil
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, output)
+		}
+		return nil
+	}
+	drop := func(seq int, key string) error {
+		if filterCtx.Bottommost {
+			return nil
+		}
+		return emit(seq, KindDelete, key, "")
+	}
 
-	for h.Len() > 0 {
+	for n := 0; h.Len() > 0; n++ {
+		if n%compactionCancelCheck == 0 && ctx.Err() != nil {
+			return fail(ctx.Err())
+		}
 		item := heap.Pop(h).(*HeapItem)
 		currentKey := item.key
 		newestKind := item.kind
@@ -68,7 +550,7 @@ func (db *DB) compact() {
 		}
 
 		for h.Len() > 0 {
-			top := (*h)[0]
+			top := h.Top()
 			if top.key != currentKey {
 				break
 			}
@@ -87,47 +569,122 @@ func (db *DB) compact() {
 			}
 		}
 
-		if newestKind == KindPut {
-			line := fmt.Sprintf("PUT %d %s %s\n", newestSeq, currentKey, newestVal)
-
-			filter.add(currentKey)
-
-			if i % stride == 0 {
-				sstable.index = append(sstable.index, IndexEntry{
-					key: currentKey,
-					offset: offset,
-				})
+		if newestKind == KindDelete {
+			if err := drop(newestSeq, currentKey); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		var ref blobRef
+		if newestKind == KindBlobIndex {
+			var err error
+			if ref, err = parseBlobRef(newestVal); err != nil {
+				return fail(fmt.Errorf("lsm: key %q: %v", currentKey, err))
+			}
+			if !blobs.gc[ref.file] && cf.opts.TTL <= 0 && cf.opts.CompactionFilter == nil {
+				if err := emit(newestSeq, KindBlobIndex, currentKey, newestVal); err != nil {
+					return fail(err)
+				}
+				continue
+			}
+			if newestVal, err = db.readBlob(blobs.files[ref.file], ref, currentKey); err != nil {
+				return fail(err)
+			}
+		}
+		value, live := cf.unwrapValue(newestVal, now)
+		if !live {
+			if err := drop(newestSeq, currentKey); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		stored, keep, err := cf.applyCompactionFilter(filterCtx, currentKey, newestVal, value)
+		if err != nil {
+			return fail(err)
+		}
+		if !keep {
+			db.stats.compactionFilterRemoved.Add(1)
+			if err := drop(newestSeq, currentKey); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		if stored != newestVal {
+			db.stats.compactionFilterChanged.Add(1)
+		}
+		kind := KindPut
+		switch {
+		case newestKind == KindBlobIndex && stored == newestVal && !blobs.gc[ref.file]:
+			kind, stored = KindBlobIndex, ref.String()
+		case cf.separates(stored):
+			if bw == nil {
+				if bw, err = newBlobWriter(db.fs, db.keys, db.allocFileId(), cf.dir); err != nil {
+					return fail(err)
+				}
+				bw.rateLimit(db.limiter, IOPriorityLow)
 			}
-			n, _ := writer.WriteString(line)
-			offset += int64(n)
-			i++
-		} 
+			kind, stored = KindBlobIndex, bw.add(currentKey, stored).String()
+		}
+		if err := emit(newestSeq, kind, currentKey, stored); err != nil {
+			return fail(err)
+		}
 	}
 
-	writer.Flush()
-	file.Sync()
-	file.Close()
-	os.Rename(tmp, target)
+	// An input that stopped early left entries out, and dropping its
+	// tombstones or its versions of keys could bring older ones back.
+	for _, it := range inputs {
+		if err := it.Err(); err != nil {
+			return fail(err)
+		}
+	}
 
-	compacted := make(map[*SSTable]struct{}, len(tables))
-	for _, t := range tables {
-		compacted[t] = struct{}{}
+	if w != nil {
+		output, err := w.finish()
+		w = nil
+		if err != nil {
+			return fail(err)
+		}
+		outputs = append(outputs, output)
 	}
+	var blob *blobFile
+	if bw != nil {
+		var err error
+		blob, err = bw.finish()
+		bw = nil
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return outputs, blob, nil
+}
 
-	db.mu.Lock()
-    current := db.sstables
-	var keep []*SSTable
-	for _, t := range current {
-		if _, wasCompacted := compacted[t]; !wasCompacted {
-			keep = append(keep, t)
+// rangeIter iterates the entries of an SSTable within a key range.
+type rangeIter struct {
+	*SSTableIter
+	end string
+	cmp Comparator
+}
+
+// newRangeIter positions an iterator on the first entry of sstable at or
+// after r.start, seeking through the index.
+func (db *DB) newRangeIter(sstable *SSTable, r keyRange) *rangeIter {
+	it := &rangeIter{SSTableIter: newSSTableIter(db.fs, db.keys, sstable.path), end: r.end, cmp: db.cmp}
+	if r.start != "" {
+		err := db.forEachBlock(sstable, func(key string) bool { return db.cmp.Compare(key, r.start) <= 0 }, func(block IndexEntry) bool {
+			it.seek(block.offset)
+			return false
+		})
+		if err != nil {
+			it.fail(err)
 		}
 	}
-	db.sstables = append(keep, sstable)
-    db.mu.Unlock()
+	it.Next()
+	for it.SSTableIter.Valid() && r.start != "" && db.cmp.Compare(it.Key(), r.start) < 0 {
+		it.Next()
+	}
+	return it
+}
 
-	for _, t := range tables {
-        if t.path != target { 
-            _ = os.Remove(t.path)
-        }
-    }
-}
\ No newline at end of file
+func (it *rangeIter) Valid() bool {
+	return it.SSTableIter.Valid() && (it.end == "" || it.cmp.Compare(it.Key(), it.end) < 0)
+}
@@ -0,0 +1,120 @@
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"distributedstore/vfs"
+)
+
+func TestSplitCompactionCoversKeySpace(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	var index []IndexEntry
+	for i := 0; i < 100; i++ {
+		index = append(index, IndexEntry{key: fmt.Sprintf("k%03d", i)})
+	}
+	tables := []*SSTable{{index: index}}
+
+	if ranges := db.splitCompaction(tables, 1); len(ranges) != 1 || ranges[0] != (keyRange{}) {
+		t.Fatalf("one subcompaction split into %v", ranges)
+	}
+	ranges := db.splitCompaction(tables, 4)
+	if len(ranges) != 4 || ranges[0].start != "" || ranges[3].end != "" {
+		t.Fatalf("ranges = %v, want 4 covering everything", ranges)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].start != ranges[i-1].end || ranges[i-1].start >= ranges[i-1].end && i > 1 {
+			t.Fatalf("ranges %v and %v are not adjacent", ranges[i-1], ranges[i])
+		}
+	}
+}
+
+func TestSubcompactionsKeepEveryKey(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.MaxSubcompactions = 4 })
+	defer db.Close()
+	value := strings.Repeat("v", 1<<10)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 3000; i++ {
+			db.Put(fmt.Sprintf("k%05d", i), fmt.Sprintf("%d%s", round, value))
+		}
+		db.Flush()
+	}
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := familyTables(db, db.DefaultColumnFamily())
+	if len(tables) < 2 {
+		t.Fatalf("%d output tables from 6MB of input", len(tables))
+	}
+	// Outputs of different subcompactions hold disjoint, ordered ranges.
+	for _, table := range tables {
+		for _, other := range tables {
+			if other != table && db.overlaps(other, table.smallest, table.largest) {
+				t.Fatalf("tables %s..%s and %s..%s overlap", table.smallest, table.largest, other.smallest, other.largest)
+			}
+		}
+	}
+	for i := 0; i < 3000; i += 97 {
+		mustGet(t, db, fmt.Sprintf("k%05d", i), "1"+value)
+	}
+}
+
+func TestFamiliesCompactInBackground(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinCompact = 2
+		opts.MaxBackgroundCompactions = 2
+		opts.ColumnFamilies = map[string]ColumnFamilyOptions{"other": {MinCompact: 2}}
+	})
+	defer db.Close()
+	other, _ := db.ColumnFamily("other")
+	for i := 0; i < 2; i++ {
+		db.Put(fmt.Sprintf("a%d", i), "1")
+		db.PutCF(other, fmt.Sprintf("b%d", i), "1")
+		db.Flush()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, cf := range []*ColumnFamily{db.DefaultColumnFamily(), other} {
+		for {
+			tables := familyTables(db, cf)
+			if len(tables) == 1 && tables[0].level == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still has %d tables", cf.Name(), len(tables))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	mustGet(t, db, "a0", "1")
+}
+
+func TestFailedCompactionKeepsInputs(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, nil)
+	defer db.Close()
+	db.Put("a", "1")
+	db.Flush()
+	db.Put("b", "2")
+	db.Flush()
+	inputs := familyTables(db, db.DefaultColumnFamily())
+
+	fault.FailOn(vfs.OpCreate, "*.compact.tmp")
+	if err := db.CompactRange(context.Background(), "", ""); err == nil {
+		t.Fatal("compaction succeeded without writing its output")
+	}
+	fault.SetInjector(nil)
+	if tables := familyTables(db, db.DefaultColumnFamily()); len(tables) != len(inputs) {
+		t.Fatalf("%d tables after a failed compaction of %d", len(tables), len(inputs))
+	}
+	mustGet(t, db, "a", "1")
+	mustGet(t, db, "b", "2")
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
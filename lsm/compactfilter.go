@@ -0,0 +1,59 @@
+package lsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type CompactionDecision int
+
+const (
+	CompactionKeep CompactionDecision = iota
+	CompactionRemove
+	CompactionChangeValue
+)
+
+// CompactionFilterContext describes the compaction a filter is called from.
+// Bottommost is set when no older version of the compaction's keys remains
+// outside it, so a removed key cannot reappear from a lower level.
+type CompactionFilterContext struct {
+	ColumnFamily string
+	OutputLevel int
+	Bottommost bool
+	Manual bool
+}
+
+// CompactionFilter lets an application drop or rewrite entries while they are
+// compacted, instead of deleting them. Filter is called with the newest live
+// value of each key that survives the compaction, without any TTL prefix,
+// and returns the decision together with the new value for
+// CompactionChangeValue. It may be called from several subcompactions at once.
+type CompactionFilter interface {
+	Filter(ctx CompactionFilterContext, key string, value string) (CompactionDecision, string)
+}
+
+// applyCompactionFilter runs the family's filter on one stored value. It
+// returns the value to write, or false to drop the key.
+func (cf *ColumnFamily) applyCompactionFilter(ctx CompactionFilterContext, key string, stored string, value string) (string, bool, error) {
+	if cf.opts.CompactionFilter == nil {
+		return stored, true, nil
+	}
+	decision, changed := cf.opts.CompactionFilter.Filter(ctx, key, value)
+	switch decision {
+	case CompactionRemove:
+		return "", false, nil
+	case CompactionChangeValue:
+		if strings.ContainsRune(changed, '\n') {
+			return "", false, fmt.Errorf("lsm: compaction filter value for key %q contains a newline", key)
+		}
+		// A changed value keeps its original write time, so it expires as
+		// the old one would have.
+		if cf.opts.TTL > 0 {
+			if ts, _, ok := strings.Cut(stored, ":"); ok {
+				return ts + ":" + changed, true, nil
+			}
+		}
+		return changed, true, nil
+	}
+	return stored, true, nil
+}
@@ -0,0 +1,71 @@
+package lsm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// funcFilter adapts a function to CompactionFilter.
+type funcFilter func(ctx CompactionFilterContext, key string, value string) (CompactionDecision, string)
+
+func (f funcFilter) Filter(ctx CompactionFilterContext, key string, value string) (CompactionDecision, string) {
+	return f(ctx, key, value)
+}
+
+func TestCompactionFilterDropsAndRewrites(t *testing.T) {
+	var mu sync.Mutex
+	var seen []CompactionFilterContext
+	filter := funcFilter(func(ctx CompactionFilterContext, key string, value string) (CompactionDecision, string) {
+		mu.Lock()
+		seen = append(seen, ctx)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(key, "tmp"):
+			return CompactionRemove, ""
+		case strings.HasPrefix(key, "up"):
+			return CompactionChangeValue, strings.ToUpper(value)
+		}
+		return CompactionKeep, ""
+	})
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.CompactionFilter = filter
+	})
+	defer db.Close()
+	db.Put("tmp1", "x")
+	db.Put("up1", "old")
+	db.Flush()
+	db.Put("up1", "new")
+	db.Put("keep", "k")
+
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	mustMiss(t, db, "tmp1")
+	mustGet(t, db, "up1", "NEW")
+	mustGet(t, db, "keep", "k")
+	if len(seen) != 3 || !seen[0].Manual || !seen[0].Bottommost || seen[0].ColumnFamily != DefaultColumnFamilyName {
+		t.Fatalf("filter called %d times, first with %+v", len(seen), seen[0])
+	}
+	if s := db.Stats(); s.CompactionFilterRemoved != 1 || s.CompactionFilterChanged != 1 {
+		t.Fatalf("removed %d changed %d, want 1 1", s.CompactionFilterRemoved, s.CompactionFilterChanged)
+	}
+}
+
+func TestCompactionFilterRejectsNewlines(t *testing.T) {
+	filter := funcFilter(func(ctx CompactionFilterContext, key string, value string) (CompactionDecision, string) {
+		return CompactionChangeValue, "two\nlines"
+	})
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.CompactionFilter = filter
+	})
+	defer db.Close()
+	db.Put("a", "1")
+	if err := db.CompactRange(context.Background(), "", ""); err == nil || !strings.Contains(err.Error(), "newline") {
+		t.Fatalf("compaction with a multi-line value: %v", err)
+	}
+	mustGet(t, db, "a", "1")
+}
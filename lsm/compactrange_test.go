@@ -0,0 +1,61 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// openManualCompactionDB opens a DB that never compacts on its own.
+func openManualCompactionDB(t *testing.T) *DB {
+	t.Helper()
+	return openTestDB(t, func(opts *Options) { opts.MinCompact = 100 })
+}
+
+func TestCompactRangeTakesOnlyOverlappingTables(t *testing.T) {
+	db := openManualCompactionDB(t)
+	defer db.Close()
+	for _, pair := range [][2]string{{"a", "b"}, {"c", "d"}, {"x", "y"}} {
+		db.Put(pair[0], "1")
+		db.Put(pair[1], "1")
+		db.Flush()
+	}
+	db.Put("c", "2")
+
+	// The memtable is flushed first, so its c is compacted with the table
+	// holding c and d; a..b and x..y are left alone.
+	if err := db.CompactRange(context.Background(), "c", "c"); err != nil {
+		t.Fatal(err)
+	}
+	levels := map[string]int{}
+	for _, table := range familyTables(db, db.DefaultColumnFamily()) {
+		levels[table.smallest+".."+table.largest] = table.level
+	}
+	if len(levels) != 3 || levels["c..d"] != 1 || levels["a..b"] != 0 || levels["x..y"] != 0 {
+		t.Fatalf("tables by range and level: %v", levels)
+	}
+	mustGet(t, db, "c", "2")
+}
+
+func TestCompactRangeCancelled(t *testing.T) {
+	db := openManualCompactionDB(t)
+	defer db.Close()
+	db.Put("a", "1")
+	db.Flush()
+	db.Put("b", "1")
+	db.Flush()
+	before := familyTables(db, db.DefaultColumnFamily())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.CompactRange(ctx, "", ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled compaction returned %v", err)
+	}
+	after := familyTables(db, db.DefaultColumnFamily())
+	if len(after) != len(before) || after[0] != before[0] {
+		t.Fatal("cancelled compaction changed the tables")
+	}
+	if s := db.Stats(); s.Compactions != 0 {
+		t.Fatalf("compactions = %d after cancelling", s.Compactions)
+	}
+}
@@ -0,0 +1,56 @@
+package lsm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Comparator defines the key order of a DB. Compare must only return 0 for
+// identical keys, since bloom filters and equality checks work on the raw
+// key bytes. The name is recorded on disk and must change whenever the order
+// does.
+type Comparator interface {
+	Compare(a, b string) int
+	Name() string
+}
+
+var (
+	BytewiseComparator Comparator = bytewiseComparator{}
+	ReverseBytewiseComparator Comparator = reverseBytewiseComparator{}
+	NumericComparator Comparator = numericComparator{}
+)
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b string) int { return strings.Compare(a, b) }
+func (bytewiseComparator) Name() string { return "lsm.BytewiseComparator" }
+
+type reverseBytewiseComparator struct{}
+
+func (reverseBytewiseComparator) Compare(a, b string) int { return strings.Compare(b, a) }
+func (reverseBytewiseComparator) Name() string { return "lsm.ReverseBytewiseComparator" }
+
+// numericComparator orders keys that parse as base-10 integers by value,
+// ahead of all other keys, which fall back to bytewise order.
+type numericComparator struct{}
+
+func (numericComparator) Compare(a, b string) int {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func (numericComparator) Name() string { return "lsm.NumericComparator" }
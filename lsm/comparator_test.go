@@ -0,0 +1,65 @@
+package lsm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestNumericComparatorOrdersTablesAndIteration(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.Comparator = NumericComparator })
+	defer db.Close()
+	for _, key := range []string{"100", "9", "x", "-3"} {
+		db.Put(key, "v"+key)
+	}
+	db.Flush()
+	for _, key := range []string{"10", "2"} {
+		db.Put(key, "v"+key)
+	}
+	want := []string{"-3", "2", "9", "10", "100", "x"}
+	if got := prefixKeys(db, db.DefaultColumnFamily(), ""); !slices.Equal(got, want) {
+		t.Fatalf("keys = %v, want %v", got, want)
+	}
+
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	db.mu.RLock()
+	tables := slices.Clone(db.defaultFamily.sstables)
+	db.mu.RUnlock()
+	if len(tables) != 1 {
+		t.Fatalf("compacted into %d tables", len(tables))
+	}
+	if tables[0].smallest != "-3" || tables[0].largest != "x" {
+		t.Fatalf("table spans %q..%q, want -3..x", tables[0].smallest, tables[0].largest)
+	}
+	for _, key := range want {
+		mustGet(t, db, key, "v"+key)
+	}
+	if got := prefixKeys(db, db.DefaultColumnFamily(), "1"); !slices.Equal(got, []string{"10", "100"}) {
+		t.Fatalf("keys with prefix 1 = %v", got)
+	}
+}
+
+func TestOpenRejectsDifferentComparator(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, func(opts *Options) { opts.Comparator = ReverseBytewiseComparator })
+	db.Put("a", "1")
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.FS = fs
+	if _, err := Open("/db", opts); err == nil || !strings.Contains(err.Error(), ReverseBytewiseComparator.Name()) {
+		t.Fatalf("open with bytewise order: %v, want comparator mismatch", err)
+	}
+	opts.Comparator = ReverseBytewiseComparator
+	db, err := Open("/db", opts)
+	if err != nil {
+		t.Fatalf("open with the recorded comparator: %v", err)
+	}
+	defer db.Close()
+	mustGet(t, db, "a", "1")
+}
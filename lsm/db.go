@@ -3,31 +3,57 @@ package lsm
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
-	"os"
-	"bufio"
-	"strings"
-	"io"
+	"sync/atomic"
+	"time"
+
+	"distributedstore/vfs"
 )
 
 const (
-	stride = 2
 	bloomM = 1024
 	bloomK = 7
 	dbFlushThreshold = 100 
 	minCompact = 4  
+	defaultBlockCacheSize = 8 << 20
+	defaultBlockSize = 4 << 10
+	defaultBlockRestartInterval = 16
+	defaultIndexPartitionSize = 4 << 10
+	defaultTargetFileSize = 2 << 20
+	defaultMaxWriteDelay = 20 * time.Millisecond
+	defaultMaxBackgroundCompactions = 2
+	defaultBlobGCLiveRatio = 0.5
+	// maxLineSize bounds a line read back from a WAL or table, and with it
+	// the largest record that can be read.
+	maxLineSize = 256 << 20
+	// maxBlockSize bounds BlockSize and IndexPartitionSize.
+	maxBlockSize = 64 << 20
 )
 
 type DB struct {
 	dir string
+	fs vfs.FS
+	keys KeyProvider
+	cmp Comparator
 	mu sync.RWMutex
-	memtable *Memtable
-	flushCh chan *Memtable
+	families []*ColumnFamily
+	// defaultFamily is set while opening and never changes, so it is read
+	// without db.mu.
+	defaultFamily *ColumnFamily
+	// closed is set under db.mu when Close begins; writes check it under the
+	// same lock, so none lands after the final flush.
+	closed bool
+	familiesByName map[string]*ColumnFamily
+	familiesById map[int]*ColumnFamily
+	flushCh chan *flushJob
 	compactCh chan struct{}
-	sstables []*SSTable
 	wal *WAL
+	// wals are the WALs some family may still need to replay, oldest first.
+	// The last one is being written.
+	wals []tableMeta
 	seq int
-	flushThreshold int
 	nextFileId int
 	flushWg sync.WaitGroup
 	compactWg sync.WaitGroup
@@ -35,6 +61,24 @@ type DB struct {
 	pendingFlushes int
 	flushMu sync.Mutex
 	flushCond *sync.Cond
+	locks *lockManager
+	nextTxnId atomic.Uint64
+	ingestMu sync.Mutex
+	deleteMu sync.Mutex
+	deletionsDisabled int
+	pendingDeletes []obsoleteFile
+	cache *blockCache
+	listeners []EventListener
+	limiter *RateLimiter
+	maxCompactions int
+	// compactCond is signalled on mu whenever a family stops compacting.
+	compactCond *sync.Cond
+	maxSubcompactions int
+	maxWriteDelay time.Duration
+	stallMu sync.Mutex
+	stallCond *sync.Cond
+	stall writeStall
+	stats dbStats
 }
 
 func (db *DB) nextSeq() int {
@@ -50,33 +94,94 @@ func (db *DB) allocFileId() int {
     return id
 }
 
-func Open(dir string) *DB {
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.Comparator == nil {
+		opts.Comparator = BytewiseComparator
+	}
+	if opts.InMemory {
+		opts.FS = vfs.NewMemFS()
+	} else if opts.FS == nil {
+		opts.FS = vfs.Default
+	}
 	db := &DB{
 		dir: dir,
-		memtable: NewMemtable(),
-		sstables: []*SSTable{},
-		flushThreshold: dbFlushThreshold,
-		flushCh: make(chan *Memtable, 8),
+		fs: opts.FS,
+		keys: opts.KeyProvider,
+		cmp: opts.Comparator,
+		familiesByName: make(map[string]*ColumnFamily),
+		familiesById: make(map[int]*ColumnFamily),
+		flushCh: make(chan *flushJob, 8),
 		compactCh: make(chan struct{}, 1),
+		locks: newLockManager(),
+		cache: newBlockCache(opts.BlockCacheSize),
+		listeners: opts.EventListeners,
+		limiter: opts.RateLimiter,
+		maxCompactions: opts.MaxBackgroundCompactions,
+		maxSubcompactions: max(opts.MaxSubcompactions, 1),
+		maxWriteDelay: opts.MaxWriteDelay,
+	}
+	if db.maxWriteDelay <= 0 {
+		db.maxWriteDelay = defaultMaxWriteDelay
+	}
+	if db.maxCompactions <= 0 {
+		db.maxCompactions = defaultMaxBackgroundCompactions
 	}
 	db.flushCond = sync.NewCond(&db.flushMu)
+	db.stallCond = sync.NewCond(&db.stallMu)
+	db.compactCond = sync.NewCond(&db.mu)
 
 	walsPath := filepath.Join(dir, "wals")
 	sstsPath := filepath.Join(dir, "ssts")
-	os.MkdirAll(walsPath, 0o755)
-	os.MkdirAll(sstsPath, 0o755)
-	walMetas := discoverWALs(walsPath)
+	if err := db.fs.MkdirAll(walsPath, 0o755); err != nil {
+		return nil, err
+	}
+	if err := db.fs.MkdirAll(sstsPath, 0o755); err != nil {
+		return nil, err
+	}
+
+	m, err := db.openFamilies(opts)
+	if err != nil {
+		return nil, err
+	}
 
+	logs := make(map[int]int)
+	for _, f := range m.families {
+		logs[f.id] = f.log
+	}
+	walMetas := discoverWALs(db.fs, walsPath)
 	for _, walMeta := range walMetas {
-		db.seq = ReplayWAL(
+		// Writes in WALs older than a family's log number are already in its
+		// tables.
+		memtable := func(cf int) *Memtable {
+			family, ok := db.familiesById[cf]
+			if !ok || walMeta.id < logs[cf] {
+				return nil
+			}
+			if family.memtable.Size() == 0 {
+				family.memtable.walId = walMeta.id
+			}
+			return family.memtable
+		}
+		seq, err := replayWAL(
+			db.fs,
+			db.keys,
 			walMeta.path,
-			func(s int, k string, v string) {
-				db.memtable.Put(s, k, v)
+			func(cf int, s int, k string, v string) {
+				if m := memtable(cf); m != nil {
+					m.Put(s, k, v)
+				}
 			},
-			func(s int, k string) {
-				db.memtable.Delete(s, k)
+			func(cf int, s int, k string) {
+				if m := memtable(cf); m != nil {
+					m.Delete(s, k)
+				}
 			},
 		)
+		if err != nil {
+			return nil, err
+		}
+		db.seq = max(db.seq, seq)
+		db.wals = append(db.wals, walMeta)
 	}
 
 	lastWalId := 0
@@ -85,42 +190,201 @@ func Open(dir string) *DB {
 	}
 	nextWalId := lastWalId + 1
 	db.nextWalId = nextWalId
-	tables := discoverSSTables(sstsPath)
-	last := 0
-	for _, table := range tables {
-		index, filter, seq := buildIndex(table.path)
-		sstable := &SSTable{path: table.path, index: index, filter: filter}
-		db.sstables = append(db.sstables, sstable)
-		db.seq = max(db.seq, seq)
-		last = table.id
+
+	if err := db.loadTables(m); err != nil {
+		return nil, err
 	}
-	db.nextFileId = last + 1
 
 	walPath := filepath.Join(walsPath, fmt.Sprintf("wal-%06d.log", nextWalId))
-	db.wal = OpenWAL(walPath)
-	db.memtable.walPath = walPath
+	db.wal = openWAL(db.fs, db.keys, walPath)
+	db.wals = append(db.wals, tableMeta{id: nextWalId, path: walPath})
+	if err := db.createRequestedFamilies(opts); err != nil {
+		return nil, err
+	}
 	
 	db.flushWg.Add(1)
 	go db.flusher()
 
+	db.refreshWriteStall()
 	db.compactWg.Add(1)
     go db.compactor()
+	db.maybeScheduleCompaction()
 
-	return db
+	return db, nil
 }
 
-func (db *DB) Close() {
-	var toFlush *Memtable
+// loadTables opens the SSTables listed in the manifest, in order, and removes
+// files a crash left behind: tables never recorded, temporary outputs and
+// unreferenced blob files.
+func (db *DB) loadTables(m *manifest) error {
+	last := 0
+	for _, cf := range db.families {
+		for _, table := range discoverSSTables(db.fs, cf.dir) {
+			last = max(last, table.id)
+		}
+		for _, blob := range discoverBlobFiles(db.fs, cf.dir) {
+			last = max(last, blob.id)
+		}
+	}
+	db.nextFileId = last + 1
 
-	db.mu.Lock()
-	if db.memtable != nil && db.memtable.Size() > 0 {
-		toFlush = db.memtable
-		db.memtable = NewMemtable()
+	if len(m.tables) == 0 {
+		for _, cf := range db.families {
+			for _, table := range discoverSSTables(db.fs, cf.dir) {
+				sstable, seq, err := buildIndex(db.fs, db.keys, table.path, cf.newFilter())
+				if err != nil {
+					return err
+				}
+				if sstable.checksum, err = tableChecksum(db.fs, table.path); err != nil {
+					return err
+				}
+				cf.sstables = append(cf.sstables, sstable)
+				db.seq = max(db.seq, seq)
+			}
+		}
+		if err := db.saveManifestLocked(); err != nil {
+			return err
+		}
+		return db.loadBlobFiles()
+	}
+
+	live := make(map[string]struct{})
+	for _, t := range m.tables {
+		cf, ok := db.familiesById[t.family]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(cf.dir, t.name)
+		sstable, seq, err := buildIndex(db.fs, db.keys, path, cf.newFilter())
+		if err != nil {
+			return err
+		}
+		sstable.level = t.level
+		if t.hasChecksum {
+			// Keep the recorded checksum, so damage stays detectable
+			// rather than being recorded as the new normal.
+			sstable.checksum = t.checksum
+		} else if sstable.checksum, err = tableChecksum(db.fs, path); err != nil {
+			return err
+		}
+		cf.sstables = append(cf.sstables, sstable)
+		db.seq = max(db.seq, seq)
+		live[path] = struct{}{}
+	}
+	for _, cf := range db.families {
+		for _, table := range discoverSSTables(db.fs, cf.dir) {
+			if _, ok := live[table.path]; !ok {
+				db.fs.Remove(table.path)
+			}
+		}
+		names, _ := db.fs.List(cf.dir)
+		for _, name := range names {
+			if filepath.Ext(name) == ".tmp" {
+				db.fs.Remove(filepath.Join(cf.dir, name))
+			}
+		}
+	}
+	return db.loadBlobFiles()
+}
+
+// loadBlobFiles opens the blob files the loaded tables reference and removes
+// the rest, which a crash left behind or which were due for deletion.
+func (db *DB) loadBlobFiles() error {
+	for _, cf := range db.families {
+		live := cf.blobLiveBytes()
+		for _, meta := range discoverBlobFiles(db.fs, cf.dir) {
+			if live[meta.id] == 0 {
+				db.fs.Remove(meta.path)
+				continue
+			}
+			blob, err := openBlobFile(db.fs, db.keys, meta.id, meta.path)
+			if err != nil {
+				return err
+			}
+			cf.blobs[meta.id] = blob
+		}
+	}
+	return nil
+}
+
+func (db *DB) openFamilies(opts Options) (*manifest, error) {
+	m, err := readManifest(db.fs, db.dir)
+	if err != nil {
+		return nil, err
+	}
+	recorded := m.comparator
+	if recorded == "" && (len(m.families) > 0 || len(discoverSSTables(db.fs, filepath.Join(db.dir, "ssts"))) > 0 || len(discoverWALs(db.fs, filepath.Join(db.dir, "wals"))) > 0) {
+		// Written before the comparator was recorded, when only bytewise
+		// order existed.
+		recorded = BytewiseComparator.Name()
+	}
+	if recorded != "" && recorded != db.cmp.Name() {
+		return nil, fmt.Errorf("lsm: db was created with comparator %s, cannot open with %s", recorded, db.cmp.Name())
 	}
+	if len(m.families) == 0 {
+		m.families = []familyMeta{{id: 0, name: DefaultColumnFamilyName, ttl: opts.TTL}}
+	}
+
+	for _, meta := range m.families {
+		cfOpts, requested := opts.ColumnFamilies[meta.name]
+		if meta.id == 0 {
+			cfOpts, requested = opts.ColumnFamilyOptions, true
+		}
+		if !requested {
+			cfOpts = DefaultColumnFamilyOptions()
+			cfOpts.TTL = meta.ttl
+		}
+		if (cfOpts.TTL > 0) != (meta.ttl > 0) {
+			return nil, fmt.Errorf("lsm: column family %q was created with ttl %s, cannot open with ttl %s", meta.name, meta.ttl, cfOpts.TTL)
+		}
+		cf := newColumnFamily(db.dir, meta.id, meta.name, cfOpts, db.cmp)
+		if err := db.fs.MkdirAll(cf.dir, 0o755); err != nil {
+			return nil, err
+		}
+		db.addFamily(cf)
+	}
+	return m, nil
+}
+
+// createRequestedFamilies creates the families opts configure that the DB
+// does not have yet. It runs once the WALs are replayed, so the manifest it
+// writes records the log numbers of the others.
+func (db *DB) createRequestedFamilies(opts Options) error {
+	var names []string
+	for name := range opts.ColumnFamilies {
+		if _, exists := db.familiesByName[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := db.createColumnFamilyLocked(name, opts.ColumnFamilies[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dir returns the directory the DB was opened in.
+func (db *DB) Dir() string { return db.dir }
+
+// FS returns the filesystem the DB keeps its files on.
+func (db *DB) FS() vfs.FS { return db.fs }
+
+func (db *DB) Close() {
+	db.locks.close()
+	db.mu.Lock()
+	db.closed = true
+	job, _ := db.freezeLocked(db.families)
 	db.mu.Unlock()
 
-	if toFlush != nil {
-		db.flushCh <- toFlush
+	// Writes stopped by a stall see the DB closed once they wake.
+	db.stallMu.Lock()
+	db.stallCond.Broadcast()
+	db.stallMu.Unlock()
+
+	if job != nil {
+		db.scheduleFlush(job)
 	}
 
 	close(db.flushCh)
@@ -131,7 +395,7 @@ func (db *DB) Close() {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.wal.Sync()
+	db.wal.Close()
 }
 
 func (db *DB) Sync() {
@@ -142,124 +406,241 @@ func (db *DB) Sync() {
 	db.flushMu.Unlock()
 }
 
-func (db *DB) Get(key string) (string, bool) {
+func (db *DB) Get(key string) (string, bool, error) {
+	return db.GetCF(db.DefaultColumnFamily(), key)
+}
+
+// GetCF returns the value of key in cf, and whether it has one. It fails if
+// the key's newest version cannot be read, rather than return an older one.
+func (db *DB) GetCF(cf *ColumnFamily, key string) (string, bool, error) {
+	db.stats.gets.Add(1)
+	value, found, err := db.get(cf, key)
+	if found {
+		db.stats.getHits.Add(1)
+	}
+	return value, found, err
+}
+
+func (db *DB) get(cf *ColumnFamily, key string) (string, bool, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	value, exists := db.memtable.Get(key)
+	now := time.Now()
+
+	value, exists := cf.memtable.Get(key)
+	for i := len(cf.imm) - 1; i >= 0 && !exists; i-- {
+		value, exists = cf.imm[i].Get(key)
+	}
 	if exists {
+		db.stats.memtableHits.Add(1)
 		if len(value) == 0 {
-			return "", false
+			return "", false, nil
 		}
-		return value, true
+		value, live := cf.unwrapValue(value, now)
+		return value, live, nil
 	}
-	for i := len(db.sstables) - 1; i >= 0; i-- {
-		sstable := db.sstables[i]
 
-		if !sstable.filter.mightContain(key) {
+	for i := len(cf.sstables) - 1; i >= 0; i-- {
+		if !db.overlaps(cf.sstables[i], key, key) {
 			continue
 		}
-
-		last := IndexEntry{}
-		found := false
-		l := 0
-		r := len(sstable.index) - 1
-		for l <= r {
-			c := (l + r) / 2
-			entry := sstable.index[c]
-			if entry.key <= key {
-				last = entry
-				found = true
-				l = c + 1
-			} else {
-				r = c - 1
-			}
+		value, kind, found, err := db.tableGet(cf.sstables[i], key)
+		if err != nil {
+			return "", false, err
 		}
 		if !found {
 			continue
 		}
-		file, _ := os.Open(sstable.path)
-		file.Seek(last.offset, io.SeekStart)
-		sc := bufio.NewScanner(file)
-		for sc.Scan() {
-			line := sc.Text()
-			parts := strings.SplitN(line, " ", 4)
-			k := parts[2]
-			if k > key {
-				break
+		if kind == KindDelete {
+			return "", false, nil
+		}
+		if kind == KindBlobIndex {
+			ref, err := parseBlobRef(value)
+			if err != nil {
+				return "", false, fmt.Errorf("lsm: %s: key %q: %v", cf.sstables[i].path, key, err)
 			}
-			switch parts[0] {
-			case "PUT":
-				if key == k {
-					return parts[3], true
-				}
-			case "DEL":
-				if key == k {
-					return "", false
-				}
+			if value, err = db.readBlob(cf.blobs[ref.file], ref, key); err != nil {
+				return "", false, err
 			}
 		}
+		value, live := cf.unwrapValue(value, now)
+		return value, live, nil
 	}
-	return "", false
+	return "", false, nil
 }
 
-func (db *DB) Put(key string, value string) {
-	db.mu.Lock()
-	seq := db.nextSeq()
-	var oldMemtable *Memtable
+func (db *DB) Put(key string, value string) error {
+	return db.apply([]batchOp{{kind: KindPut, key: key, value: value}})
+}
 
-	db.wal.WritePut(seq, key, value)
-	db.wal.Sync()
-	db.memtable.Put(seq, key, value)
+func (db *DB) PutCF(cf *ColumnFamily, key string, value string) error {
+	return db.apply([]batchOp{{cf: cf, kind: KindPut, key: key, value: value}})
+}
 
-	if db.memtable.Size() >= db.flushThreshold {
-		oldMemtable = db.memtable
-		oldMemtable.walPath = db.wal.path
+func (db *DB) Delete(key string) error {
+	return db.apply([]batchOp{{kind: KindDelete, key: key}})
+}
 
-		db.wal.Close()
-		db.nextWalId++
-		newWalPath := filepath.Join(db.dir, "wals", fmt.Sprintf("wal-%06d.log", db.nextWalId))
-		db.wal = OpenWAL(newWalPath)
+func (db *DB) DeleteCF(cf *ColumnFamily, key string) error {
+	return db.apply([]batchOp{{cf: cf, kind: KindDelete, key: key}})
+}
 
-		db.memtable = NewMemtable()
-		db.memtable.walPath = newWalPath
+// Write applies batch atomically. It fails with ErrClosed once Close has
+// begun, and with the WAL's error if the batch could not be made durable.
+func (db *DB) Write(batch *WriteBatch) error {
+	if batch.Len() == 0 {
+		return nil
 	}
-
-	db.mu.Unlock()
-	if oldMemtable != nil {
-		db.flushMu.Lock()
-		db.pendingFlushes++
-		db.flushMu.Unlock()
-		db.flushCh <- oldMemtable
+	if err := db.apply(batch.ops); err != nil {
+		return err
 	}
+	db.stats.writes.Add(1)
+	return nil
 }
 
-func (db *DB) Delete(key string) {
+func (db *DB) apply(ops []batchOp) error {
+	db.throttleWrite()
+	now := time.Now()
+	for _, cf := range batchFamilies(db, ops) {
+		cf.writeMu.RLock()
+		defer cf.writeMu.RUnlock()
+	}
 	db.mu.Lock()
-	seq := db.nextSeq()
-	var oldMemtable *Memtable
-
-	db.wal.WriteDel(seq, key)
-	db.wal.Sync()
-	db.memtable.Delete(seq, key)
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	var job *flushJob
 
-	if db.memtable.Size() >= db.flushThreshold {
-		oldMemtable = db.memtable
-		oldMemtable.walPath = db.wal.path
+	walSize := db.wal.size
+	if len(ops) > 1 {
+		db.wal.WriteBatchHeader(db.seq+1, len(ops))
+	}
+	seqs := make([]int, len(ops))
+	values := make([]string, len(ops))
+	for i, op := range ops {
+		cf := op.family(db)
+		seqs[i] = db.nextSeq()
+		if op.kind == KindPut {
+			values[i] = cf.wrapValue(op.value, now)
+			db.wal.WritePut(cf.id, seqs[i], op.key, values[i])
+		} else {
+			db.wal.WriteDel(cf.id, seqs[i], op.key)
+		}
+	}
+	err := db.wal.Sync()
+	db.stats.walBytesWritten.Add(db.wal.size - walSize)
+	if err != nil {
+		// The writes may not be durable, so they are neither applied nor
+		// acknowledged. Their sequence numbers stay used, as records of them
+		// may still be replayed.
+		db.mu.Unlock()
+		return err
+	}
 
-		db.wal.Close()
-		db.nextWalId++
-		newWalPath := filepath.Join(db.dir, "wals", fmt.Sprintf("wal-%06d.log", db.nextWalId))
-		db.wal = OpenWAL(newWalPath)
+	var full []*ColumnFamily
+	for i, op := range ops {
+		cf := op.family(db)
+		if cf.memtable.Size() == 0 {
+			cf.memtable.walId = db.nextWalId
+		}
+		if op.kind == KindPut {
+			cf.memtable.Put(seqs[i], op.key, values[i])
+			db.stats.puts.Add(1)
+		} else {
+			cf.memtable.Delete(seqs[i], op.key)
+			db.stats.deletes.Add(1)
+		}
+		db.stats.userBytesWritten.Add(int64(len(op.key) + len(op.value)))
+		if cf.memtable.Size() >= cf.opts.FlushThreshold && !slices.Contains(full, cf) {
+			full = append(full, cf)
+		}
+	}
 
-		db.memtable = NewMemtable()
-		db.memtable.walPath = newWalPath
+	var rotated WALInfo
+	if len(full) > 0 {
+		job, rotated = db.freezeLocked(full)
 	}
 
 	db.mu.Unlock()
-	if oldMemtable != nil {
-		db.flushMu.Lock()
-		db.pendingFlushes++
-		db.flushMu.Unlock()
-		db.flushCh <- oldMemtable
+	if job != nil {
+		db.notify(func(l EventListener) { l.OnWALRotated(rotated) })
+		db.scheduleFlush(job)
+		db.refreshWriteStall()
+	}
+	return nil
+}
+
+// freezeLocked moves the non-empty memtables of families to their immutable
+// lists and returns them as one flush job. The WAL is rotated, so the ones
+// before it can go once every family with writes in them has flushed.
+func (db *DB) freezeLocked(families []*ColumnFamily) (*flushJob, WALInfo) {
+	job := &flushJob{}
+	for _, cf := range families {
+		if cf.memtable.Size() == 0 {
+			continue
+		}
+		job.families = append(job.families, cf)
+		job.memtables = append(job.memtables, cf.memtable)
+		cf.imm = append(cf.imm, cf.memtable)
+		cf.memtable = NewMemtable(db.cmp)
+	}
+	if len(job.families) == 0 {
+		return nil, WALInfo{}
+	}
+	return job, db.rotateWALLocked()
+}
+
+// logNumberLocked returns the oldest WAL that may hold writes of cf that are
+// not in its tables yet.
+func (db *DB) logNumberLocked(cf *ColumnFamily) int {
+	if len(cf.imm) > 0 {
+		return cf.imm[0].walId
+	}
+	if cf.memtable.Size() > 0 {
+		return cf.memtable.walId
+	}
+	return db.nextWalId
+}
+
+// obsoleteWALsLocked drops the WALs whose writes every family has flushed
+// from the live ones and returns their paths.
+func (db *DB) obsoleteWALsLocked() []string {
+	oldest := db.nextWalId
+	for _, cf := range db.families {
+		oldest = min(oldest, db.logNumberLocked(cf))
+	}
+	var paths []string
+	for len(db.wals) > 0 && db.wals[0].id < oldest {
+		paths = append(paths, db.wals[0].path)
+		db.wals = db.wals[1:]
+	}
+	return paths
+}
+
+func (db *DB) rotateWALLocked() WALInfo {
+	db.wal.Close()
+	db.nextWalId++
+	newWalPath := filepath.Join(db.dir, "wals", fmt.Sprintf("wal-%06d.log", db.nextWalId))
+	info := WALInfo{OldPath: db.wal.path, NewPath: newWalPath}
+	db.wal = openWAL(db.fs, db.keys, newWalPath)
+	db.wals = append(db.wals, tableMeta{id: db.nextWalId, path: newWalPath})
+	return info
+}
+
+// scheduleFlush hands a job to the flusher. Writers block here while the
+// flusher is too far behind to accept another job.
+func (db *DB) scheduleFlush(job *flushJob) {
+	db.flushMu.Lock()
+	db.pendingFlushes++
+	db.flushMu.Unlock()
+
+	select {
+	case db.flushCh <- job:
+		return
+	default:
 	}
+	stall := WriteStallInfo{Cause: "too many pending flushes", Condition: WriteStallStopped}
+	db.notify(func(l EventListener) { l.OnWriteStallBegin(stall) })
+	db.flushCh <- job
+	db.notify(func(l EventListener) { l.OnWriteStallEnd(stall) })
 }
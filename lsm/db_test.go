@@ -0,0 +1,61 @@
+package lsm
+
+import (
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+// openTestDB opens a DB on a fresh in-memory filesystem, with options
+// adjusted by configure if it is not nil.
+func openTestDB(t *testing.T, configure func(*Options)) *DB {
+	t.Helper()
+	return openTestDBOn(t, vfs.NewMemFS(), configure)
+}
+
+// openTestDBOn opens the DB in /db on fs.
+func openTestDBOn(t *testing.T, fs vfs.FS, configure func(*Options)) *DB {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.FS = fs
+	if configure != nil {
+		configure(&opts)
+	}
+	db, err := Open("/db", opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db
+}
+
+func mustGet(t *testing.T, db *DB, key string, want string) {
+	t.Helper()
+	if v, ok, err := db.Get(key); err != nil || !ok || v != want {
+		t.Fatalf("Get(%q) = %q, %v, %v; want %q", key, v, ok, err, want)
+	}
+}
+
+func mustMiss(t *testing.T, db *DB, key string) {
+	t.Helper()
+	if v, ok, err := db.Get(key); err != nil || ok {
+		t.Fatalf("Get(%q) = %q, %v, %v; want no value", key, v, ok, err)
+	}
+}
+
+// prefixKeys returns the keys of cf starting with prefix, in iteration order.
+func prefixKeys(db *DB, cf *ColumnFamily, prefix string) []string {
+	it := db.NewPrefixIteratorCF(cf, prefix)
+	defer it.Close()
+	var keys []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// familyTables returns the tables of cf, newest last.
+func familyTables(db *DB, cf *ColumnFamily) []*SSTable {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]*SSTable(nil), cf.sstables...)
+}
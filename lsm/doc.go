@@ -0,0 +1,33 @@
+// Package lsm is the storage engine of a node: a log-structured merge tree
+// of a skip list memtable, a write-ahead log and immutable SSTables.
+//
+// An SSTable is a file of data blocks cut at BlockSize. Keys are prefix
+// compressed against the key before them, with the whole key at a restart
+// point every BlockRestartInterval entries, which lookups binary search
+// within a block. The blocks are listed by an index; one larger than
+// IndexPartitionSize is cut into partitions read through the block cache on
+// demand, so only the top-level index of them stays in memory. Bloom
+// filters and table properties are kept in a meta block behind a footer, so
+// opening a table reads only its footer, index and meta block. Tables in the
+// older uncompressed format stay readable.
+//
+// A column family's PrefixExtractor gives each table a bloom filter of key
+// prefixes next to the whole-key one. NewPrefixIterator merges the
+// memtables and tables lazily and skips tables that filter rules out; with
+// comparators other than the bytewise ones it scans and filters instead of
+// seeking.
+//
+// Compactions run on a pool of workers, split large merges into parallel
+// key-range subcompactions and cut outputs at TargetFileSize; a
+// CompactionFilter may drop or rewrite keys as they go. Writes slow down,
+// then stop, as immutable memtables, level-0 tables or pending compaction
+// bytes pile up, and a RateLimiter throttles the writes of flushes and
+// compactions.
+//
+// Values of at least MinBlobSize bytes are moved into blob files on flush,
+// leaving a reference in the SSTable, and compaction rewrites the live values
+// of a blob file once less than BlobGCLiveRatio of it is referenced. With a
+// KeyProvider, WAL records, SSTable blocks and blob values are sealed with
+// AES-GCM, and every file names the key it was written with, so older files
+// stay readable once the key is rotated.
+package lsm
@@ -0,0 +1,174 @@
+package lsm
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"distributedstore/vfs"
+)
+
+// TableProperties summarizes the contents of an SSTable.
+type TableProperties struct {
+	Path string
+	Size int64
+	Checksum uint32
+	Entries int
+	Puts int
+	// BlobRefs counts entries whose value is in a blob file; ValueBytes
+	// counts their references rather than the values.
+	BlobRefs int
+	Deletes int
+	KeyBytes int64
+	ValueBytes int64
+	Smallest string
+	Largest string
+	MinSeq int
+	MaxSeq int
+}
+
+type TableIndexEntry struct {
+	Key string
+	Offset int64
+	Size int64
+}
+
+// TableFilterStats describes a bloom filter Open builds for a table.
+// FalsePositiveRate is estimated from the fraction of bits set.
+type TableFilterStats struct {
+	Bits uint
+	Hashes uint
+	BitsSet int
+	FalsePositiveRate float64
+}
+
+type TableInfo struct {
+	Properties TableProperties
+	// Index lists the table's data blocks. IndexPartitions counts the
+	// partitions the table keeps them in, if its index is partitioned.
+	Index []TableIndexEntry
+	IndexPartitions int
+	Filter TableFilterStats
+	// PrefixFilter describes the filter of key prefixes, if the options
+	// have a prefix extractor.
+	PrefixFilter *TableFilterStats
+}
+
+// InspectTable reads the SSTable at path and returns its properties, with
+// the index and bloom filters Open would build for it in a family with the
+// given options. Its entries can be read with NewSSTableIter once it has
+// been inspected without error.
+func InspectTable(path string, opts ColumnFamilyOptions) (*TableInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := TableProperties{Path: path, MinSeq: math.MaxInt}
+	sc, err := newLineScanner(file, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	prev := ""
+	for sc.Scan() {
+		if isStructureLine(sc.Text()) {
+			continue
+		}
+		e, err := parseTableEntry(sc.Text(), prev)
+		prev = e.key
+		if err != nil {
+			return nil, fmt.Errorf("lsm: %s:%d: %v", path, sc.Line(), err)
+		}
+		if p.Entries == 0 {
+			p.Smallest = e.key
+		}
+		p.Largest = e.key
+		p.Entries++
+		switch e.kind {
+		case KindPut:
+			p.Puts++
+		case KindBlobIndex:
+			p.BlobRefs++
+		default:
+			p.Deletes++
+		}
+		p.KeyBytes += int64(len(e.key))
+		p.ValueBytes += int64(len(e.value))
+		p.MinSeq = min(p.MinSeq, e.seq)
+		p.MaxSeq = max(p.MaxSeq, e.seq)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if p.Entries == 0 {
+		p.MinSeq = 0
+	}
+
+	opts = opts.sanitize()
+	filter := (&ColumnFamily{opts: opts}).newFilter()
+	sstable, _, err := buildIndex(vfs.Default, nil, path, filter)
+	if err != nil {
+		return nil, err
+	}
+	p.Size = sstable.size
+	if p.Checksum, err = tableChecksum(vfs.Default, path); err != nil {
+		return nil, err
+	}
+
+	info := &TableInfo{Properties: p}
+	blocks, err := sstable.blockIndex(vfs.Default)
+	if err != nil {
+		return nil, err
+	}
+	if sstable.partitioned {
+		info.IndexPartitions = len(sstable.index)
+	}
+	for _, e := range blocks {
+		info.Index = append(info.Index, TableIndexEntry{Key: e.key, Offset: e.offset, Size: e.size})
+	}
+	info.Filter = filter.keys.stats()
+	if filter.prefixes != nil {
+		stats := filter.prefixes.stats()
+		info.PrefixFilter = &stats
+	}
+	return info, nil
+}
+
+func (bf *BloomFilter) stats() TableFilterStats {
+	set := bf.bitsSet()
+	return TableFilterStats{
+		Bits: bf.m,
+		Hashes: bf.k,
+		BitsSet: set,
+		FalsePositiveRate: math.Pow(float64(set)/float64(bf.m), float64(bf.k)),
+	}
+}
+
+// WALRecord is a write read back from a WAL. Batch is the sequence number
+// of the batch the record was written in, and zero for a single write.
+type WALRecord struct {
+	Line int
+	ColumnFamily int
+	Seq int
+	Kind Kind
+	Key string
+	Value string
+	Batch int
+	BatchSize int
+}
+
+// ReadWAL calls fn with each record of the WAL at path that replay would
+// apply, in order, and bad with each line replay would skip. It reports
+// whether the WAL ends in a batch torn by a crash, which replay drops.
+func ReadWAL(path string, fn func(WALRecord), bad func(line int, err error)) (torn bool, err error) {
+	return scanWAL(vfs.Default, nil, path, func(header *walRecord, records []walRecord) {
+		for _, r := range records {
+			rec := WALRecord{Line: r.line, ColumnFamily: r.cf, Seq: r.seq, Kind: r.kind, Key: r.key, Value: r.value}
+			if header != nil {
+				rec.Batch, rec.BatchSize = header.seq, header.count
+			}
+			fn(rec)
+		}
+	}, bad)
+}
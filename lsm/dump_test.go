@@ -0,0 +1,86 @@
+package lsm
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInspectTable(t *testing.T) {
+	opts := DefaultColumnFamilyOptions()
+	opts.PrefixExtractor = FixedPrefix(1)
+	path := filepath.Join(t.TempDir(), "t.sst")
+	w, err := NewSSTWriter(path, SSTWriterOptions{ColumnFamilyOptions: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Put("aa", "1")
+	w.Delete("ab")
+	w.Put("ba", "22")
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := InspectTable(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := info.Properties
+	if p.Entries != 3 || p.Puts != 2 || p.Deletes != 1 || p.Smallest != "aa" || p.Largest != "ba" || p.ValueBytes != 3 || p.KeyBytes != 6 {
+		t.Fatalf("properties = %+v", p)
+	}
+	if p.Checksum == 0 || p.Size == 0 || len(info.Index) == 0 {
+		t.Fatalf("checksum %x, size %d, %d index entries", p.Checksum, p.Size, len(info.Index))
+	}
+	if info.Filter.Bits == 0 || info.Filter.BitsSet == 0 || info.PrefixFilter == nil || info.PrefixFilter.BitsSet == 0 {
+		t.Fatalf("filters = %+v, %+v", info.Filter, info.PrefixFilter)
+	}
+
+	if _, err := InspectTable(filepath.Join(t.TempDir(), "missing.sst"), opts); err == nil {
+		t.Fatal("inspected a missing table")
+	}
+	bad := filepath.Join(t.TempDir(), "bad.sst")
+	if err := os.WriteFile(bad, []byte("not a table\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InspectTable(bad, opts); err == nil {
+		t.Fatal("inspected a malformed table")
+	}
+}
+
+func TestReadWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal-000001.log")
+	wal := OpenWAL(path)
+	wal.WritePut(0, 1, "a", "1")
+	wal.WriteDel(1, 2, "b")
+	wal.WriteBatchHeader(3, 2)
+	wal.WritePut(0, 3, "c", "3")
+	wal.WritePut(0, 4, "d", "4")
+	wal.writeRecord("garbage\n")
+	// A batch cut short by a crash.
+	wal.WriteBatchHeader(5, 2)
+	wal.WritePut(0, 5, "e", "5")
+	wal.Close()
+
+	var keys []string
+	var batches []int
+	var badLines []int
+	torn, err := ReadWAL(path, func(r WALRecord) {
+		keys = append(keys, r.Key)
+		batches = append(batches, r.Batch)
+	}, func(line int, err error) { badLines = append(badLines, line) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"a", "b", "c", "d"}) || !slices.Equal(batches, []int{0, 0, 3, 3}) {
+		t.Fatalf("records %v in batches %v", keys, batches)
+	}
+	if !torn || len(badLines) != 1 || badLines[0] != 6 {
+		t.Fatalf("torn %v, bad lines %v; want a torn tail and line 6", torn, badLines)
+	}
+
+	if _, err := ReadWAL(filepath.Join(t.TempDir(), "missing.log"), func(WALRecord) {}, nil); err == nil {
+		t.Fatal("read a missing WAL")
+	}
+}
@@ -0,0 +1,284 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// encryptionHeader starts the first line of an encrypted WAL, SSTable or
+// blob file, followed by the id of the key its lines are sealed with and a
+// random id of the file. Every line after it is the base64 of a nonce and an
+// AES-GCM sealed WAL record, SSTable block or blob value, authenticated with
+// the file id and the line's offset, so a line moved to another offset or
+// file does not open. Files from before file ids have none, and their lines
+// are sealed without either.
+const encryptionHeader = "ENCRYPTED"
+
+// A block is cut once it reaches its size, so it holds at most a block's
+// worth of lines and the one that overflowed it. Sealed, that takes a GCM
+// nonce and tag more, in base64.
+var maxSealedLineSize = base64.StdEncoding.EncodedLen(maxBlockSize + maxLineSize + 12 + 16)
+
+// KeyProvider supplies the AES keys WALs and SSTables are encrypted with.
+// Rotating the current key only affects files written afterwards; older
+// files name the key they were written with, which must stay available
+// through Key.
+type KeyProvider interface {
+	// CurrentKey returns the key new files are encrypted with and its id,
+	// which must not be empty or contain whitespace.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id.
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider holding its keys in memory. The key added last
+// is the current one.
+type KeyRing struct {
+	mu sync.RWMutex
+	keys map[string][]byte
+	current string
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte)}
+}
+
+// Add adds a 16, 24 or 32 byte AES key and makes it the current one.
+func (r *KeyRing) Add(id string, key []byte) error {
+	if id == "" || strings.ContainsAny(id, " \t\r\n") {
+		return fmt.Errorf("lsm: invalid key id %q", id)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("lsm: key %s: %v", id, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = append([]byte(nil), key...)
+	r.current = id
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == "" {
+		return "", nil, fmt.Errorf("lsm: key ring is empty")
+	}
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(id string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("lsm: unknown key %s", id)
+	}
+	return key, nil
+}
+
+// fileCipher seals and opens the lines of one encrypted file.
+type fileCipher struct {
+	id string
+	aead cipher.AEAD
+	// file is the id of the file, nil for one from before file ids.
+	file []byte
+}
+
+func newFileCipher(id string, key []byte, file []byte) (*fileCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("lsm: key %s: %v", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCipher{id: id, aead: aead, file: file}, nil
+}
+
+// currentCipher returns the cipher a new file is written with, or nil if
+// keys is nil and files are written in plaintext.
+func currentCipher(keys KeyProvider) (*fileCipher, error) {
+	if keys == nil {
+		return nil, nil
+	}
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || strings.ContainsAny(id, " \t\r\n") {
+		return nil, fmt.Errorf("lsm: invalid key id %q", id)
+	}
+	file := make([]byte, 16)
+	if _, err := rand.Read(file); err != nil {
+		return nil, err
+	}
+	return newFileCipher(id, key, file)
+}
+
+func (c *fileCipher) header() string {
+	if c.file == nil {
+		return encryptionHeader + " " + c.id + "\n"
+	}
+	return encryptionHeader + " " + c.id + " " + hex.EncodeToString(c.file) + "\n"
+}
+
+// additionalData binds a line to its file and its offset in it.
+func (c *fileCipher) additionalData(offset int64) []byte {
+	if c.file == nil {
+		return nil
+	}
+	return binary.BigEndian.AppendUint64(slices.Clone(c.file), uint64(offset))
+}
+
+// seal encrypts plain into one line, newline included, to be written at
+// offset.
+func (c *fileCipher) seal(plain []byte, offset int64) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := c.aead.Seal(nonce, nonce, plain, c.additionalData(offset))
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed))+1)
+	base64.StdEncoding.Encode(line, sealed)
+	line[len(line)-1] = '\n'
+	return line
+}
+
+// open decrypts the line read at offset.
+func (c *fileCipher) open(line []byte, offset int64) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil || n < c.aead.NonceSize() {
+		return nil, fmt.Errorf("cannot decrypt: malformed line")
+	}
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():n]
+	plain, err := c.aead.Open(nil, nonce, sealed, c.additionalData(offset))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt: %v", err)
+	}
+	return plain, nil
+}
+
+// lineScanner reads the lines of a WAL or SSTable from its start, decrypting
+// them if the file is encrypted, so callers see the plaintext lines either
+// way. An encrypted SSTable block yields all of its lines in turn.
+type lineScanner struct {
+	sc *bufio.Scanner
+	cipher *fileCipher
+	lines []string
+	line string
+	damaged error
+	n int
+	offset int64
+	next int64
+}
+
+// newLineScanner starts reading a file, taking the key it names from keys
+// if it is encrypted.
+func newLineScanner(r io.Reader, keys KeyProvider) (*lineScanner, error) {
+	br := bufio.NewReader(r)
+	s := &lineScanner{}
+	if head, _ := br.Peek(len(encryptionHeader) + 1); string(head) == encryptionHeader+" " {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("lsm: truncated encryption header")
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, encryptionHeader))
+		if len(fields) != 1 && len(fields) != 2 {
+			return nil, fmt.Errorf("lsm: malformed encryption header")
+		}
+		id := fields[0]
+		var file []byte
+		if len(fields) == 2 {
+			if file, err = hex.DecodeString(fields[1]); err != nil || len(file) == 0 {
+				return nil, fmt.Errorf("lsm: malformed encryption header")
+			}
+		}
+		if keys == nil {
+			return nil, fmt.Errorf("lsm: file is encrypted with key %s and no KeyProvider is set", id)
+		}
+		key, err := keys.Key(id)
+		if err != nil {
+			return nil, err
+		}
+		if s.cipher, err = newFileCipher(id, key, file); err != nil {
+			return nil, err
+		}
+		s.n = 1
+		s.next = int64(len(line))
+	}
+	s.sc = newScanner(br, s.cipher)
+	return s, nil
+}
+
+// scanLinesAt reads a file already known to use cipher from offset, which
+// must start a line.
+func scanLinesAt(r io.Reader, cipher *fileCipher, offset int64) *lineScanner {
+	return &lineScanner{sc: newScanner(r, cipher), cipher: cipher, offset: offset, next: offset}
+}
+
+// newScanner returns a scanner that reads lines as long as the file's
+// records and blocks can be.
+func newScanner(r io.Reader, cipher *fileCipher) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	if cipher != nil {
+		sc.Buffer(nil, maxSealedLineSize)
+	} else {
+		sc.Buffer(nil, maxLineSize)
+	}
+	return sc
+}
+
+// Scan advances to the next line. A line that does not decrypt yields no
+// lines and is reported by Damaged.
+func (s *lineScanner) Scan() bool {
+	s.damaged = nil
+	if len(s.lines) == 0 {
+		if !s.sc.Scan() {
+			return false
+		}
+		raw := s.sc.Bytes()
+		s.n++
+		s.offset, s.next = s.next, s.next+int64(len(raw))+1
+		if s.cipher == nil {
+			s.line = string(raw)
+			return true
+		}
+		plain, err := s.cipher.open(bytes.TrimSpace(raw), s.offset)
+		if err != nil {
+			s.line, s.damaged = "", err
+			return true
+		}
+		s.lines = strings.Split(strings.TrimSuffix(string(plain), "\n"), "\n")
+	}
+	s.line, s.lines = s.lines[0], s.lines[1:]
+	return true
+}
+
+func (s *lineScanner) Text() string { return s.line }
+
+// Damaged returns why the current line could not be decrypted, if it could
+// not.
+func (s *lineScanner) Damaged() error { return s.damaged }
+
+// Line returns the number of the line in the file the current line was read
+// from, counting from one.
+func (s *lineScanner) Line() int { return s.n }
+
+// Offset returns where the line in the file the current line was read from
+// starts. End returns where it ends, newline included.
+func (s *lineScanner) Offset() int64 { return s.offset }
+func (s *lineScanner) End() int64 { return s.next }
+
+func (s *lineScanner) Err() error { return s.sc.Err() }
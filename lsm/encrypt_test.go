@@ -0,0 +1,135 @@
+package lsm
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// filesContaining returns the files under dir whose contents include s.
+func filesContaining(t *testing.T, fs vfs.FS, dir string, s string) []string {
+	t.Helper()
+	names, err := fs.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if info, err := fs.Stat(path); err == nil && info.IsDir() {
+			found = append(found, filesContaining(t, fs, path, s)...)
+			continue
+		}
+		f, err := fs.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if bytes.Contains(data, []byte(s)) {
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+func TestEncryptionHidesDataAndSurvivesKeyRotation(t *testing.T) {
+	fs := vfs.NewMemFS()
+	ring := NewKeyRing()
+	if err := ring.Add("k1", testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	withKeys := func(opts *Options) { opts.KeyProvider = ring }
+	db := openTestDBOn(t, fs, withKeys)
+	large := "secret" + strings.Repeat("x", 100<<10)
+	db.Put("a", "secret-table")
+	db.Put("big", large)
+	db.Put("secret-key", "1")
+	db.Flush()
+	db.Put("b", "secret-wal")
+	if found := filesContaining(t, fs, "/db", "secret"); len(found) > 0 {
+		t.Fatalf("plaintext in %v", found)
+	}
+
+	if err := ring.Add("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	db.Put("c", "secret-rotated")
+	db.Flush()
+	db.Close()
+
+	db = openTestDBOn(t, fs, withKeys)
+	defer db.Close()
+	for key, want := range map[string]string{"a": "secret-table", "big": large, "b": "secret-wal", "c": "secret-rotated"} {
+		mustGet(t, db, key, want)
+	}
+}
+
+func TestEncryptedDBNeedsItsKeys(t *testing.T) {
+	fs := vfs.NewMemFS()
+	ring := NewKeyRing()
+	ring.Add("k1", testKey(1))
+	db := openTestDBOn(t, fs, func(opts *Options) { opts.KeyProvider = ring })
+	db.Put("a", "1")
+	db.Flush()
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.FS = fs
+	if _, err := Open("/db", opts); err == nil || !strings.Contains(err.Error(), "k1") {
+		t.Fatalf("open without a key provider: %v", err)
+	}
+	other := NewKeyRing()
+	other.Add("k2", testKey(2))
+	opts.KeyProvider = other
+	if _, err := Open("/db", opts); err == nil {
+		t.Fatal("opened without the key the tables were written with")
+	}
+
+	// Same id, different key.
+	wrong := NewKeyRing()
+	wrong.Add("k1", testKey(3))
+	opts.KeyProvider = wrong
+	if db, err := Open("/db", opts); err == nil {
+		db.Close()
+		t.Fatal("opened with the wrong key for k1")
+	}
+
+	if err := ring.Add("bad id", testKey(1)); err == nil {
+		t.Fatal("added a key id with a space")
+	}
+	if err := ring.Add("short", []byte("short")); err == nil {
+		t.Fatal("added a key that is not an AES key")
+	}
+}
+
+func TestSealedLinesOpenOnlyWhereTheyWereWritten(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", testKey(1))
+	c, err := currentCipher(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := bytes.TrimSpace(c.seal([]byte("P 1 0 a 1\n"), 100))
+	if plain, err := c.open(line, 100); err != nil || string(plain) != "P 1 0 a 1\n" {
+		t.Fatalf("open where sealed: %q, %v", plain, err)
+	}
+	if _, err := c.open(line, 200); err == nil {
+		t.Fatal("opened a line moved to another offset")
+	}
+	other, err := currentCipher(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.open(line, 100); err == nil {
+		t.Fatal("opened a line moved to another file")
+	}
+}
@@ -0,0 +1,121 @@
+package lsm
+
+import (
+	"time"
+)
+
+// EventListener is told about the DB's background work. Callbacks run
+// synchronously on the goroutine doing the work, never while the DB lock is
+// held, so they may call back into the DB but should return quickly. Embed
+// NoopEventListener to implement only the callbacks of interest.
+type EventListener interface {
+	OnFlushBegin(FlushInfo)
+	OnFlushCompleted(FlushInfo)
+	OnCompactionBegin(CompactionInfo)
+	OnCompactionCompleted(CompactionInfo)
+	OnTableFileCreated(TableFileInfo)
+	OnTableFileDeleted(TableFileInfo)
+	OnWALRotated(WALInfo)
+	OnWriteStallBegin(WriteStallInfo)
+	OnWriteStallEnd(WriteStallInfo)
+	OnBackgroundError(BackgroundErrorInfo)
+}
+
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(FlushInfo) {}
+func (NoopEventListener) OnFlushCompleted(FlushInfo) {}
+func (NoopEventListener) OnCompactionBegin(CompactionInfo) {}
+func (NoopEventListener) OnCompactionCompleted(CompactionInfo) {}
+func (NoopEventListener) OnTableFileCreated(TableFileInfo) {}
+func (NoopEventListener) OnTableFileDeleted(TableFileInfo) {}
+func (NoopEventListener) OnWALRotated(WALInfo) {}
+func (NoopEventListener) OnWriteStallBegin(WriteStallInfo) {}
+func (NoopEventListener) OnWriteStallEnd(WriteStallInfo) {}
+func (NoopEventListener) OnBackgroundError(BackgroundErrorInfo) {}
+
+// FlushInfo describes the flush of one column family's memtable. Bytes and
+// Duration are only known once the flush has completed.
+type FlushInfo struct {
+	ColumnFamily string
+	Path string
+	Entries int
+	Bytes int64
+	Duration time.Duration
+}
+
+// CompactionInfo describes one compaction. Outputs, OutputBytes and Duration
+// are only known once it has completed; a compaction that drops every entry
+// has no outputs. Manual is set for compactions run by CompactRange.
+type CompactionInfo struct {
+	ColumnFamily string
+	Manual bool
+	Inputs []string
+	Outputs []string
+	InputBytes int64
+	OutputBytes int64
+	Duration time.Duration
+}
+
+type TableFileReason string
+
+const (
+	TableFileFlush TableFileReason = "flush"
+	TableFileCompaction TableFileReason = "compaction"
+	TableFileIngestion TableFileReason = "ingestion"
+)
+
+// TableFileInfo describes an SSTable that was created or deleted. For a
+// deletion, Reason says what made the table obsolete.
+type TableFileInfo struct {
+	ColumnFamily string
+	Path string
+	Level int
+	Bytes int64
+	Smallest string
+	Largest string
+	Reason TableFileReason
+}
+
+type WALInfo struct {
+	OldPath string
+	NewPath string
+}
+
+type WriteStallInfo struct {
+	Cause string
+	Condition WriteStallCondition
+}
+
+// BackgroundErrorInfo reports a flush or compaction that failed. A failed
+// flush keeps its memtable readable and its WAL on disk, and is retried with
+// the next flush or on reopen; a failed compaction leaves its inputs in
+// place.
+type BackgroundErrorInfo struct {
+	Operation string
+	ColumnFamily string
+	Err error
+}
+
+func (db *DB) notify(fn func(EventListener)) {
+	for _, l := range db.listeners {
+		fn(l)
+	}
+}
+
+func (db *DB) tableFileInfo(cf *ColumnFamily, t *SSTable, reason TableFileReason) TableFileInfo {
+	return TableFileInfo{
+		ColumnFamily: cf.name,
+		Path: t.path,
+		Level: t.level,
+		Bytes: t.size,
+		Smallest: t.smallest,
+		Largest: t.largest,
+		Reason: reason,
+	}
+}
+
+func (db *DB) backgroundError(op string, cf *ColumnFamily, err error) {
+	info := BackgroundErrorInfo{Operation: op, ColumnFamily: cf.name, Err: err}
+	db.notify(func(l EventListener) { l.OnBackgroundError(info) })
+}
@@ -0,0 +1,121 @@
+package lsm
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+type recordingListener struct {
+	NoopEventListener
+	db *DB
+	mu sync.Mutex
+	events []string
+	flushes []FlushInfo
+	compactions []CompactionInfo
+	deleted []TableFileInfo
+	errors []BackgroundErrorInfo
+}
+
+func (l *recordingListener) record(event string) {
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	l.mu.Unlock()
+}
+
+func (l *recordingListener) seen() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.events)
+}
+
+func (l *recordingListener) OnFlushBegin(FlushInfo) { l.record("flush-begin") }
+
+func (l *recordingListener) OnFlushCompleted(info FlushInfo) {
+	// Callbacks run without the DB lock, so they may read from the DB.
+	l.db.Get("a")
+	l.mu.Lock()
+	l.flushes = append(l.flushes, info)
+	l.mu.Unlock()
+	l.record("flush-completed")
+}
+
+func (l *recordingListener) OnCompactionCompleted(info CompactionInfo) {
+	l.mu.Lock()
+	l.compactions = append(l.compactions, info)
+	l.mu.Unlock()
+	l.record("compaction-completed")
+}
+
+func (l *recordingListener) OnTableFileCreated(info TableFileInfo) {
+	l.record("created-" + string(info.Reason))
+}
+
+func (l *recordingListener) OnTableFileDeleted(info TableFileInfo) {
+	l.mu.Lock()
+	l.deleted = append(l.deleted, info)
+	l.mu.Unlock()
+	l.record("deleted-" + string(info.Reason))
+}
+
+func (l *recordingListener) OnWALRotated(WALInfo) { l.record("wal-rotated") }
+
+func (l *recordingListener) OnBackgroundError(info BackgroundErrorInfo) {
+	l.mu.Lock()
+	l.errors = append(l.errors, info)
+	l.mu.Unlock()
+	l.record("error-" + info.Operation)
+}
+
+func TestEventListenerSeesFlushAndCompaction(t *testing.T) {
+	l := &recordingListener{}
+	db := openTestDB(t, func(opts *Options) { opts.EventListeners = []EventListener{l} })
+	l.db = db
+	defer db.Close()
+
+	db.Put("a", "1")
+	db.Flush()
+	db.Put("b", "2")
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"wal-rotated", "flush-begin", "flush-completed", "created-flush",
+		"wal-rotated", "flush-begin", "flush-completed", "created-flush",
+		"created-compaction", "deleted-compaction", "deleted-compaction", "compaction-completed",
+	}
+	if got := l.seen(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v\nwant %v", got, want)
+	}
+	if f := l.flushes[0]; f.ColumnFamily != DefaultColumnFamilyName || f.Entries != 1 || f.Bytes == 0 || f.Path == "" {
+		t.Fatalf("flush info = %+v", f)
+	}
+	if c := l.compactions[0]; !c.Manual || len(c.Inputs) != 2 || len(c.Outputs) != 1 {
+		t.Fatalf("compaction info = %+v", c)
+	}
+}
+
+func TestEventListenerSeesFailedFlush(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	l := &recordingListener{}
+	db := openTestDBOn(t, fault, func(opts *Options) { opts.EventListeners = []EventListener{l} })
+	l.db = db
+	defer db.Close()
+
+	db.Put("a", "1")
+	fault.FailOn(vfs.OpCreate, "sst-*.tmp")
+	db.Flush()
+	fault.SetInjector(nil)
+
+	if slices.Contains(l.seen(), "flush-completed") {
+		t.Fatalf("failed flush reported as completed: %v", l.seen())
+	}
+	if len(l.errors) != 1 || l.errors[0].Operation != "flush" || l.errors[0].ColumnFamily != DefaultColumnFamilyName || l.errors[0].Err == nil {
+		t.Fatalf("background errors = %+v", l.errors)
+	}
+	mustGet(t, db, "a", "1")
+}
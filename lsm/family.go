@@ -0,0 +1,189 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultColumnFamilyName = "default"
+
+var (
+	ErrUnknownColumnFamily = errors.New("lsm: unknown column family")
+	familyNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// ColumnFamily is a keyspace with its own memtable, SSTables and options. All
+// families of a DB share its WAL and sequence numbers.
+type ColumnFamily struct {
+	id int
+	name string
+	dir string
+	opts ColumnFamilyOptions
+	memtable *Memtable
+	// imm holds memtables that are queued for flushing, oldest first. They
+	// stay readable until their SSTable is installed, and are retried on the
+	// next flush if writing it failed.
+	imm []*Memtable
+	sstables []*SSTable
+	// blobs are the blob files the family's tables reference values in, by
+	// id.
+	blobs map[int]*blobFile
+	// compacting is set while a compaction of the family runs.
+	compacting bool
+	// writeMu is held shared by writes to the family and exclusively by an
+	// ingest into it, so no write can take a later sequence number than the
+	// ingested files and reach a table before they are installed.
+	writeMu sync.RWMutex
+}
+
+func newColumnFamily(dbDir string, id int, name string, opts ColumnFamilyOptions, cmp Comparator) *ColumnFamily {
+	dir := filepath.Join(dbDir, "ssts")
+	if id != 0 {
+		dir = filepath.Join(dbDir, "cf", name)
+	}
+	return &ColumnFamily{
+		id: id,
+		name: name,
+		dir: dir,
+		opts: opts.sanitize(),
+		memtable: NewMemtable(cmp),
+		sstables: []*SSTable{},
+		blobs: make(map[int]*blobFile),
+	}
+}
+
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+func (cf *ColumnFamily) ID() int {
+	return cf.id
+}
+
+// removeImm drops a memtable from the immutable list once its SSTable is
+// installed.
+func (cf *ColumnFamily) removeImm(m *Memtable) {
+	for i, imm := range cf.imm {
+		if imm == m {
+			cf.imm = append(cf.imm[:i:i], cf.imm[i+1:]...)
+			return
+		}
+	}
+}
+
+// Values in families with a TTL are stored with their write time prepended,
+// as "<unix seconds>:<value>".
+func (cf *ColumnFamily) wrapValue(value string, now time.Time) string {
+	if cf.opts.TTL <= 0 {
+		return value
+	}
+	return strconv.FormatInt(now.Unix(), 10) + ":" + value
+}
+
+func (cf *ColumnFamily) unwrapValue(stored string, now time.Time) (string, bool) {
+	if cf.opts.TTL <= 0 {
+		return stored, true
+	}
+	ts, value, ok := strings.Cut(stored, ":")
+	if !ok {
+		return stored, true
+	}
+	written, _ := strconv.ParseInt(ts, 10, 64)
+	if now.Sub(time.Unix(written, 0)) >= cf.opts.TTL {
+		return "", false
+	}
+	return value, true
+}
+
+func (db *DB) ColumnFamily(name string) (*ColumnFamily, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	cf, ok := db.familiesByName[name]
+	return cf, ok
+}
+
+func (db *DB) DefaultColumnFamily() *ColumnFamily {
+	return db.defaultFamily
+}
+
+func (db *DB) ColumnFamilies() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var names []string
+	for _, cf := range db.families {
+		names = append(names, cf.name)
+	}
+	return names
+}
+
+func (db *DB) CreateColumnFamily(name string, opts ColumnFamilyOptions) (*ColumnFamily, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.createColumnFamilyLocked(name, opts)
+}
+
+func (db *DB) createColumnFamilyLocked(name string, opts ColumnFamilyOptions) (*ColumnFamily, error) {
+	if !familyNameRe.MatchString(name) {
+		return nil, fmt.Errorf("lsm: invalid column family name %q", name)
+	}
+	if _, exists := db.familiesByName[name]; exists {
+		return nil, fmt.Errorf("lsm: column family %q already exists", name)
+	}
+	id := 0
+	for _, cf := range db.families {
+		id = max(id, cf.id+1)
+	}
+	cf := newColumnFamily(db.dir, id, name, opts, db.cmp)
+	if err := db.fs.MkdirAll(cf.dir, 0o755); err != nil {
+		return nil, err
+	}
+	db.addFamily(cf)
+	if err := writeManifest(db.fs, db.dir, db.manifestLocked()); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func (db *DB) addFamily(cf *ColumnFamily) {
+	if cf.id == 0 {
+		db.defaultFamily = cf
+	}
+	db.families = append(db.families, cf)
+	db.familiesByName[cf.name] = cf
+	db.familiesById[cf.id] = cf
+}
+
+func (db *DB) manifestLocked() *manifest {
+	m := &manifest{comparator: db.cmp.Name()}
+	for _, cf := range db.families {
+		m.families = append(m.families, familyMeta{id: cf.id, name: cf.name, ttl: cf.opts.TTL, log: db.logNumberLocked(cf)})
+		for _, sstable := range cf.sstables {
+			t := manifestTable{
+				family: cf.id,
+				level: sstable.level,
+				name: filepath.Base(sstable.path),
+				checksum: sstable.checksum,
+				hasChecksum: true,
+			}
+			if db.keys == nil {
+				t.smallest, t.largest = sstable.smallest, sstable.largest
+			}
+			m.tables = append(m.tables, t)
+		}
+	}
+	return m
+}
+
+// saveManifestLocked records a change to the table set. It runs under db.mu
+// so manifests are written in the same order as the changes they record.
+// Until one succeeds, the previous manifest stays in place, so the files it
+// lists, and the WALs behind them, must be kept.
+func (db *DB) saveManifestLocked() error {
+	return writeManifest(db.fs, db.dir, db.manifestLocked())
+}
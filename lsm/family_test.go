@@ -0,0 +1,91 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestColumnFamiliesAreSeparateKeyspaces(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, nil)
+	meta, err := db.CreateColumnFamily("meta", DefaultColumnFamilyOptions())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Put("k", "default")
+	db.PutCF(meta, "k", "meta")
+	db.Put("only-default", "x")
+	db.DeleteCF(meta, "only-default")
+	if v, ok, err := db.GetCF(meta, "k"); err != nil || !ok || v != "meta" {
+		t.Fatalf("GetCF(meta, k) = %q, %v", v, ok)
+	}
+	mustGet(t, db, "k", "default")
+	db.Close()
+
+	// The family is recorded in the manifest, so it opens without being
+	// asked for.
+	db = openTestDBOn(t, fs, nil)
+	defer db.Close()
+	meta, ok := db.ColumnFamily("meta")
+	if !ok {
+		t.Fatalf("families after reopen: %v", db.ColumnFamilies())
+	}
+	if v, ok, err := db.GetCF(meta, "k"); err != nil || !ok || v != "meta" {
+		t.Fatalf("GetCF(meta, k) after reopen = %q, %v", v, ok)
+	}
+	mustGet(t, db, "k", "default")
+	mustGet(t, db, "only-default", "x")
+}
+
+func TestCreateColumnFamilyRejectsBadNames(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	for _, name := range []string{"", "a/b", "with space", DefaultColumnFamilyName} {
+		if _, err := db.CreateColumnFamily(name, DefaultColumnFamilyOptions()); err == nil {
+			t.Errorf("CreateColumnFamily(%q) succeeded", name)
+		}
+	}
+	if _, err := db.CreateColumnFamily("x", DefaultColumnFamilyOptions()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.CreateColumnFamily("x", DefaultColumnFamilyOptions()); err == nil {
+		t.Fatal("creating a family twice succeeded")
+	}
+}
+
+func TestFullFamilyFlushesAlone(t *testing.T) {
+	mem := vfs.NewMemFS()
+	small := DefaultColumnFamilyOptions()
+	small.FlushThreshold = 4
+	db := openTestDBOn(t, mem, func(opts *Options) {
+		opts.ColumnFamilies = map[string]ColumnFamilyOptions{"small": small}
+	})
+	cf, _ := db.ColumnFamily("small")
+	db.Put("unflushed", "v")
+	for i := 0; i < 8; i++ {
+		db.PutCF(cf, fmt.Sprintf("k%d", i), "v")
+	}
+	db.Sync()
+
+	db.mu.RLock()
+	flushed, other := len(cf.sstables), len(db.defaultFamily.sstables)
+	db.mu.RUnlock()
+	if flushed == 0 || other != 0 {
+		t.Fatalf("small family has %d tables, default %d; want only small flushed", flushed, other)
+	}
+
+	// The default family's write is only in the first WAL, which its
+	// flush must not have dropped.
+	recovered := openTestDBOn(t, mem.CrashClone(), nil)
+	defer recovered.Close()
+	mustGet(t, recovered, "unflushed", "v")
+	cf, _ = recovered.ColumnFamily("small")
+	for i := 0; i < 8; i++ {
+		if _, ok, err := recovered.GetCF(cf, fmt.Sprintf("k%d", i)); err != nil || !ok {
+			t.Fatalf("k%d lost", i)
+		}
+	}
+	db.Close()
+}
@@ -2,65 +2,183 @@ package lsm
 
 import (
 	"fmt"
-	"os"
-	"bufio"
 	"path/filepath"
+	"time"
 )
 
+// flushJob is a set of memtables frozen together, one per family at most
+// unless it carries failed ones along.
+type flushJob struct {
+	families []*ColumnFamily
+	memtables []*Memtable
+}
+
+// Flush writes every non-empty memtable to an SSTable, retrying those whose
+// flush failed, and waits for all pending flushes to land.
+func (db *DB) Flush() {
+	db.mu.Lock()
+	job, rotated := db.freezeLocked(db.families)
+	retry := false
+	for _, cf := range db.families {
+		retry = retry || len(cf.imm) > 0
+	}
+	db.mu.Unlock()
+
+	if job != nil {
+		db.notify(func(l EventListener) { l.OnWALRotated(rotated) })
+		db.scheduleFlush(job)
+		db.refreshWriteStall()
+	} else if retry {
+		// Memtables whose flush failed are retried ahead of the next job;
+		// with nothing new to flush, an empty one still retries them, so
+		// writes stopped behind them can resume.
+		db.scheduleFlush(&flushJob{})
+	}
+	db.Sync()
+}
+
 func (db *DB) flusher() {
 	defer db.flushWg.Done()
-	for memtable := range db.flushCh { 
-		id := db.allocFileId()
-		tmp := filepath.Join(db.dir, "ssts", fmt.Sprintf("sst-%06d.tmp", id))
-		target := filepath.Join(db.dir, "ssts", fmt.Sprintf("sst-%06d.sst", id))
-		filter := NewBloomFilter(bloomM, bloomK)
-		sstable := &SSTable{path: target, index: []IndexEntry{}, filter: filter}
-		file, _ := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-		var offset int64
-
-		writer := bufio.NewWriterSize(file, 64<<10)
-		x := memtable.skipList.header.forward[0]
-		i := 0
-		for x != nil {
-			key := x.key
-			var line string
-			if x.kind == KindPut {
-				line = fmt.Sprintf("PUT %d %s %s\n", x.seq, x.key, x.value)
-			} else {
-				line = fmt.Sprintf("DEL %d %s\n", x.seq, x.key)
-			}
-			if i % stride == 0 {
-				sstable.index = append(sstable.index, IndexEntry{key: key, offset: offset})
+	// failed holds memtables whose flush failed. They are retried ahead of
+	// the next job; a family's newer memtables wait behind them so its tables
+	// stay in order. Their WALs stay until they are flushed.
+	var failed *flushJob
+	for job := range db.flushCh { 
+		if failed != nil {
+			job = &flushJob{
+				families: append(failed.families, job.families...),
+				memtables: append(failed.memtables, job.memtables...),
 			}
+			failed = nil
+		}
 
-			n, _ := writer.WriteString(line)
-			filter.add(key)
-			offset += int64(n)
-
-			for x != nil && x.key == key {
-				x = x.forward[0]
+		tables := make([]*SSTable, len(job.families))
+		blobs := make([]*blobFile, len(job.families))
+		blocked := make(map[*ColumnFamily]bool)
+		for i, cf := range job.families {
+			if blocked[cf] {
+				continue
 			}
-			i += 1
+			sstable, blob, err := db.writeMemtable(cf, job.memtables[i])
+			if err != nil {
+				db.backgroundError("flush", cf, err)
+				blocked[cf] = true
+				continue
+			}
+			tables[i], blobs[i] = sstable, blob
 		}
 
-		writer.Flush()
-		file.Sync()
-		file.Close()
-		os.Rename(tmp, target)
-		os.Remove(memtable.walPath)
-
+		compact := false
 		db.mu.Lock()
-		db.sstables = append(db.sstables, sstable)
-		compact := len(db.sstables) >= minCompact
+		for i, cf := range job.families {
+			if tables[i] == nil {
+				continue
+			}
+			cf.sstables = append(cf.sstables, tables[i])
+			if blobs[i] != nil {
+				cf.blobs[blobs[i].id] = blobs[i]
+				db.stats.blobBytesWritten.Add(blobs[i].size)
+			}
+			cf.removeImm(job.memtables[i])
+			compact = compact || cf.compactionDue()
+			db.stats.flushes.Add(1)
+			db.stats.flushBytesWritten.Add(tables[i].size)
+		}
+		obsolete := db.obsoleteWALsLocked()
+		if err := db.saveManifestLocked(); err != nil {
+			// The recorded tables predate this flush; its WALs replay it.
+			db.backgroundError("flush", job.families[0], err)
+			obsolete = nil
+		}
 		db.mu.Unlock()
 
+		for i, cf := range job.families {
+			if tables[i] != nil {
+				info := db.tableFileInfo(cf, tables[i], TableFileFlush)
+				db.notify(func(l EventListener) { l.OnTableFileCreated(info) })
+			}
+		}
+		for _, walPath := range obsolete {
+			db.deleteFile(walPath)
+		}
+
+		if len(blocked) > 0 {
+			failed = &flushJob{}
+			for i, cf := range job.families {
+				if tables[i] == nil {
+					failed.families = append(failed.families, cf)
+					failed.memtables = append(failed.memtables, job.memtables[i])
+				}
+			}
+		}
+
 		db.flushMu.Lock()
 		db.pendingFlushes--
 		db.flushCond.Broadcast()
 		db.flushMu.Unlock()
+		db.refreshWriteStall()
 
 		if compact {
-			db.compactCh <- struct{}{}
+			db.maybeScheduleCompaction()
+		}
+	}
+}
+
+// writeMemtable writes a memtable to an SSTable, separating large values
+// into a blob file, which is returned alongside it if there were any.
+func (db *DB) writeMemtable(cf *ColumnFamily, memtable *Memtable) (*SSTable, *blobFile, error) {
+	id := db.allocFileId()
+	tmp := filepath.Join(cf.dir, fmt.Sprintf("sst-%06d.tmp", id))
+	target := filepath.Join(cf.dir, fmt.Sprintf("sst-%06d.sst", id))
+
+	start := time.Now()
+	info := FlushInfo{ColumnFamily: cf.name, Path: target, Entries: memtable.Size()}
+	db.notify(func(l EventListener) { l.OnFlushBegin(info) })
+
+	w, err := newTableWriter(db.fs, db.keys, tmp, target, cf.newFilter(), cf.blockFormat())
+	if err != nil {
+		return nil, nil, err
+	}
+	w.rateLimit(db.limiter, IOPriorityHigh)
+	var bw *blobWriter
+
+	x := memtable.skipList.header.forward[0]
+	for x != nil {
+		key := x.key
+		if x.kind == KindPut && cf.separates(x.value) {
+			if bw == nil {
+				if bw, err = newBlobWriter(db.fs, db.keys, db.allocFileId(), cf.dir); err != nil {
+					w.abandon()
+					return nil, nil, err
+				}
+				bw.rateLimit(db.limiter, IOPriorityHigh)
+			}
+			w.add(x.seq, KindBlobIndex, x.key, bw.add(x.key, x.value).String())
+		} else {
+			w.add(x.seq, x.kind, x.key, x.value)
+		}
+
+		for x != nil && x.key == key {
+			x = x.forward[0]
+		}
+	}
+
+	var blob *blobFile
+	if bw != nil {
+		if blob, err = bw.finish(); err != nil {
+			w.abandon()
+			return nil, nil, err
+		}
+	}
+	sstable, err := w.finish()
+	if err != nil {
+		if blob != nil {
+			db.fs.Remove(blob.path)
 		}
+		return nil, nil, err
 	}
+	info.Bytes = sstable.size
+	info.Duration = time.Since(start)
+	db.notify(func(l EventListener) { l.OnFlushCompleted(info) })
+	return sstable, blob, nil
 }
@@ -0,0 +1,253 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"distributedstore/vfs"
+)
+
+// After its index, a table holds a meta block of lines
+//
+//	M props <entries> <max seq> [<smallest> <largest>]
+//	M blob <file> <bytes>
+//	M filter <bits> <hashes> <hex words>
+//	M prefixes <bits> <hashes> <hex words> <extractor>
+//
+// with its properties, the bytes it references in each blob file and its
+// bloom filters, and ends with a line "M footer <index offset> <index size>
+// <meta offset> <meta size>". Each is sealed on its own line if the table is
+// encrypted. Opening a table reads the footer, index and meta block instead
+// of the whole file; tables without a footer, or whose filters were not
+// written, are scanned.
+
+func isMetaLine(line string) bool {
+	return strings.HasPrefix(line, "M ")
+}
+
+// isStructureLine reports whether line is one of a table's restart, index or
+// meta lines rather than an entry.
+func isStructureLine(line string) bool {
+	return isRestartLine(line) || isIndexLine(line) || isMetaLine(line)
+}
+
+// extractorName identifies the extractor a prefix filter was built with, so
+// one built with another is not trusted.
+func extractorName(e PrefixExtractor) string {
+	return fmt.Sprintf("%T(%v)", e, e)
+}
+
+func appendBloomLine(b []byte, name string, bf *BloomFilter) []byte {
+	b = fmt.Appendf(b, "M %s %d %d ", name, bf.m, bf.k)
+	for _, w := range bf.bits {
+		b = fmt.Appendf(b, "%016x", w)
+	}
+	return b
+}
+
+func parseBloomFields(fields []string) (*BloomFilter, error) {
+	m, err1 := strconv.ParseUint(fields[0], 10, 64)
+	k, err2 := strconv.ParseUint(fields[1], 10, 64)
+	if err1 != nil || err2 != nil || m == 0 || m&(m-1) != 0 {
+		return nil, fmt.Errorf("malformed bloom filter")
+	}
+	bf := NewBloomFilter(uint(m), uint(k))
+	if len(fields[2]) != len(bf.bits)*16 {
+		return nil, fmt.Errorf("bloom filter holds %d hex digits, expected %d", len(fields[2]), len(bf.bits)*16)
+	}
+	for i := range bf.bits {
+		w, err := strconv.ParseUint(fields[2][i*16:(i+1)*16], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bloom filter")
+		}
+		bf.bits[i] = w
+	}
+	return bf, nil
+}
+
+// writeMeta writes the meta block and the footer, once the block that
+// becomes the table's index is written from indexOffset on.
+func (w *tableWriter) writeMeta(indexOffset int64) {
+	indexSize := w.offset - indexOffset
+	meta := fmt.Appendf(nil, "M props %d %d", w.count, w.maxSeq)
+	if w.count > 0 {
+		meta = fmt.Appendf(meta, " %s %s", w.table.smallest, w.table.largest)
+	}
+	meta = append(meta, '\n')
+	for _, file := range slices.Sorted(maps.Keys(w.table.blobs)) {
+		meta = fmt.Appendf(meta, "M blob %d %d\n", file, w.table.blobs[file])
+	}
+	if f := w.table.filter; f != nil {
+		meta = append(appendBloomLine(meta, "filter", f.keys), '\n')
+		if f.prefixes != nil {
+			meta = appendBloomLine(meta, "prefixes", f.prefixes)
+			meta = append(meta, ' ')
+			meta = append(meta, extractorName(f.extractor)...)
+			meta = append(meta, '\n')
+		}
+	}
+	metaOffset := w.offset
+	w.writeBlock(meta)
+	w.writeBlock(fmt.Appendf(nil, "M footer %d %d %d %d\n", indexOffset, indexSize, metaOffset, w.offset-metaOffset))
+}
+
+// readTableBlock reads the size bytes of path at offset, opening them with
+// cipher if the table is encrypted.
+func readTableBlock(file vfs.File, path string, cipher *fileCipher, offset int64, size int64) (string, error) {
+	buf := make([]byte, size)
+	if n, err := file.ReadAt(buf, offset); n < len(buf) {
+		return "", fmt.Errorf("lsm: %s: short read at %d: %v", path, offset, err)
+	}
+	if cipher == nil {
+		return string(buf), nil
+	}
+	plain, err := cipher.open(bytes.TrimSpace(buf), offset)
+	if err != nil {
+		return "", fmt.Errorf("lsm: %s at %d: %v", path, offset, err)
+	}
+	return string(plain), nil
+}
+
+// openTable opens the table at path from its footer, taking filter's bloom
+// filters from the meta block, and returns it with the largest sequence
+// number it holds. It reports false for a table that has to be scanned: one
+// without a footer, or without the filters filter asks for. The checksum is
+// left unset.
+func openTable(fs vfs.FS, keys KeyProvider, path string, filter *tableFilter) (*SSTable, int, bool) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, 0, false
+	}
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, 0, false
+	}
+	defer file.Close()
+	sc, err := newLineScanner(file, keys)
+	if err != nil {
+		return nil, 0, false
+	}
+	sstable := &SSTable{path: path, size: info.Size(), index: []IndexEntry{}, filter: filter, cipher: sc.cipher}
+
+	// The footer is the last line; sealed, it still fits in the tail.
+	tail := min(sstable.size, 512)
+	buf := make([]byte, tail)
+	if n, _ := file.ReadAt(buf, sstable.size-tail); n < len(buf) || len(buf) == 0 || buf[len(buf)-1] != '\n' {
+		return nil, 0, false
+	}
+	start := bytes.LastIndexByte(buf[:len(buf)-1], '\n') + 1
+	if start == 0 && tail < sstable.size {
+		return nil, 0, false
+	}
+	footer, err := readTableBlock(file, path, sstable.cipher, sstable.size-tail+int64(start), tail-int64(start))
+	if err != nil || !strings.HasPrefix(footer, "M footer ") {
+		return nil, 0, false
+	}
+	var indexOffset, indexSize, metaOffset, metaSize int64
+	if _, err := fmt.Sscanf(footer, "M footer %d %d %d %d\n", &indexOffset, &indexSize, &metaOffset, &metaSize); err != nil {
+		return nil, 0, false
+	}
+
+	meta, err := readTableBlock(file, path, sstable.cipher, metaOffset, metaSize)
+	if err != nil {
+		return nil, 0, false
+	}
+	seq := 0
+	var keysFilter, prefixFilter *BloomFilter
+	prefixExtractor := ""
+	for _, line := range strings.Split(strings.TrimSuffix(meta, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "M" {
+			return nil, 0, false
+		}
+		switch fields[1] {
+		case "props":
+			if len(fields) != 4 && len(fields) != 6 {
+				return nil, 0, false
+			}
+			entries, err1 := strconv.Atoi(fields[2])
+			maxSeq, err2 := strconv.Atoi(fields[3])
+			if err1 != nil || err2 != nil {
+				return nil, 0, false
+			}
+			sstable.entries, seq = entries, maxSeq
+			if len(fields) == 6 {
+				sstable.smallest, sstable.largest = fields[4], fields[5]
+			}
+		case "blob":
+			if len(fields) != 4 {
+				return nil, 0, false
+			}
+			file, err1 := strconv.Atoi(fields[2])
+			size, err2 := strconv.ParseInt(fields[3], 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, 0, false
+			}
+			sstable.addBlobRef(blobRef{file: file, size: size})
+		case "filter", "prefixes":
+			parts := strings.SplitN(line, " ", 6)
+			if len(parts) < 5 {
+				return nil, 0, false
+			}
+			bf, err := parseBloomFields(parts[2:5])
+			if err != nil {
+				return nil, 0, false
+			}
+			if fields[1] == "filter" {
+				keysFilter = bf
+			} else if len(parts) == 6 {
+				prefixFilter, prefixExtractor = bf, parts[5]
+			}
+		}
+	}
+	if filter != nil && keysFilter == nil {
+		return nil, 0, false
+	}
+
+	if indexSize > 0 {
+		index, err := readTableBlock(file, path, sstable.cipher, indexOffset, indexSize)
+		if err != nil {
+			return nil, 0, false
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(index, "\n"), "\n") {
+			e, err := parseIndexLine(line)
+			if err != nil {
+				return nil, 0, false
+			}
+			sstable.index = append(sstable.index, e)
+			sstable.partitioned = line[0] == 'T'
+		}
+	}
+
+	if filter != nil {
+		filter.keys = keysFilter
+		if filter.extractor != nil && prefixFilter != nil && prefixExtractor == extractorName(filter.extractor) {
+			filter.prefixes = prefixFilter
+		} else if filter.extractor != nil {
+			// Without a prefix filter built by the same extractor, no table
+			// can be ruled out by prefix.
+			filter.prefixes, filter.extractor = nil, nil
+		}
+	}
+	return sstable, seq, true
+}
+
+// tableChecksum returns the CRC-32C of the table at path.
+func tableChecksum(fs vfs.FS, path string) (uint32, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	crc := crc32.New(castagnoli)
+	if _, err := io.Copy(crc, file); err != nil {
+		return 0, err
+	}
+	return crc.Sum32(), nil
+}
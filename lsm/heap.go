@@ -6,6 +6,8 @@ type Iterator interface {
 	Kind() Kind
 	Value() string
 	Valid() bool
+	// Err reports why an iterator stopped early, if it did.
+	Err() error
 	Next()
 	Close()
 }
@@ -18,27 +20,36 @@ type HeapItem struct {
 	value string
 }
 
-type IterHeap []*HeapItem
+type IterHeap struct {
+	items []*HeapItem
+	cmp Comparator
+}
+
+func NewIterHeap(cmp Comparator) *IterHeap {
+	return &IterHeap{cmp: cmp}
+}
 
-func (h IterHeap) Len() int { return len(h) }
+func (h *IterHeap) Len() int { return len(h.items) }
 
-func (h IterHeap) Less(i, j int) bool {
-	if h[i].key != h[j].key {
-		return h[i].key < h[j].key
+func (h *IterHeap) Less(i, j int) bool {
+	if c := h.cmp.Compare(h.items[i].key, h.items[j].key); c != 0 {
+		return c < 0
 	}
-	return h[i].seq > h[j].seq
+	return h.items[i].seq > h.items[j].seq
 }
 
-func (h IterHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *IterHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
 
 func (h *IterHeap) Push(x any) {
-	*h = append(*h, x.(*HeapItem))
+	h.items = append(h.items, x.(*HeapItem))
 }
 
 func (h *IterHeap) Pop() any {
-	old := *h
+	old := h.items
 	n := len(old)
 	item := old[n-1]
-	*h = old[:n-1]
+	h.items = old[:n-1]
 	return item
 }
+
+func (h *IterHeap) Top() *HeapItem { return h.items[0] }
@@ -0,0 +1,177 @@
+package lsm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"distributedstore/vfs"
+)
+
+// After its data blocks, a table holds its index in two levels: index
+// partitions of lines "I <key> <offset> <size>", one per data block, cut at
+// IndexPartitionSize, followed by a top-level index of lines
+// "T <key> <offset> <size>", one per partition. An index that fits in one
+// partition is written as that partition alone. Each is sealed on one line
+// if the table is encrypted. Only the top level is kept in memory; the
+// partitions are read through the block cache. Tables from before
+// partitioning have no index lines, and their index is built in memory
+// when they are opened.
+
+type IndexEntry struct {
+	key string
+	offset int64
+	size int64
+}
+
+func isIndexLine(line string) bool {
+	return strings.HasPrefix(line, "I ") || strings.HasPrefix(line, "T ")
+}
+
+func parseIndexLine(line string) (IndexEntry, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) != 4 || parts[1] == "" {
+		return IndexEntry{}, fmt.Errorf("malformed index entry")
+	}
+	offset, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || offset < 0 {
+		return IndexEntry{}, fmt.Errorf("bad index offset %q", parts[2])
+	}
+	size, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || size <= 0 {
+		return IndexEntry{}, fmt.Errorf("bad index size %q", parts[3])
+	}
+	return IndexEntry{key: parts[1], offset: offset, size: size}, nil
+}
+
+func appendIndexLine(b []byte, tag byte, e IndexEntry) []byte {
+	b = append(b, tag, ' ')
+	b = append(b, e.key...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.offset, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.size, 10)
+	return append(b, '\n')
+}
+
+// readIndexPartition reads the partition of sstable at e.
+func readIndexPartition(fs vfs.FS, sstable *SSTable, e IndexEntry) ([]IndexEntry, error) {
+	file, err := fs.Open(sstable.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := readTableBlock(file, sstable.path, sstable.cipher, e.offset, e.size)
+	if err != nil {
+		return nil, err
+	}
+	var entries []IndexEntry
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if !strings.HasPrefix(line, "I ") {
+			return nil, fmt.Errorf("lsm: %s at %d: not an index partition", sstable.path, e.offset)
+		}
+		entry, err := parseIndexLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("lsm: %s at %d: %v", sstable.path, e.offset, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// indexPartition returns the blocks the i-th partition of sstable lists,
+// through the block cache. A partition that cannot be read is an error, not
+// an empty one, and is not cached.
+func (db *DB) indexPartition(sstable *SSTable, i int) ([]IndexEntry, error) {
+	e := sstable.index[i]
+	key := blockKey{path: sstable.path, offset: e.offset}
+	if entries, ok := db.cache.get(key); ok {
+		return entries.([]IndexEntry), nil
+	}
+	entries, err := readIndexPartition(db.fs, sstable, e)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.put(key, entries, e.size)
+	return entries, nil
+}
+
+// forEachBlock calls fn with the data blocks of sstable in order, starting
+// from the last one whose first key is before, or the first one, until fn
+// returns false. It fails if an index partition it needs cannot be read.
+func (db *DB) forEachBlock(sstable *SSTable, before func(key string) bool, fn func(block IndexEntry) bool) error {
+	start := func(entries []IndexEntry) int {
+		return max(sort.Search(len(entries), func(i int) bool { return !before(entries[i].key) })-1, 0)
+	}
+	if !sstable.partitioned {
+		for _, block := range sstable.index[start(sstable.index):] {
+			if !fn(block) {
+				return nil
+			}
+		}
+		return nil
+	}
+	first := start(sstable.index)
+	for i := first; i < len(sstable.index); i++ {
+		blocks, err := db.indexPartition(sstable, i)
+		if err != nil {
+			return err
+		}
+		if i == first {
+			blocks = blocks[start(blocks):]
+		}
+		for _, block := range blocks {
+			if !fn(block) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// blockIndex returns the entries of every data block of sstable, reading
+// all of its index partitions.
+func (sstable *SSTable) blockIndex(fs vfs.FS) ([]IndexEntry, error) {
+	if !sstable.partitioned {
+		return sstable.index, nil
+	}
+	var blocks []IndexEntry
+	for _, e := range sstable.index {
+		entries, err := readIndexPartition(fs, sstable, e)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, entries...)
+	}
+	return blocks, nil
+}
+
+// dataSize returns where the data blocks of sstable end.
+func (sstable *SSTable) dataSize() int64 {
+	if sstable.partitioned {
+		return sstable.index[0].offset
+	}
+	last := sstable.index[len(sstable.index)-1]
+	return last.offset + last.size
+}
+
+// indexBytes estimates the memory the index sstable keeps loaded takes.
+func (sstable *SSTable) indexBytes() int64 {
+	var n int64
+	for _, e := range sstable.index {
+		n += int64(len(e.key)) + 32
+	}
+	return n
+}
+
+func (f *tableFilter) bytes() int64 {
+	if f == nil {
+		return 0
+	}
+	n := int64(len(f.keys.bits)) * 8
+	if f.prefixes != nil {
+		n += int64(len(f.prefixes.bits)) * 8
+	}
+	return n
+}
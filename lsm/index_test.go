@@ -0,0 +1,159 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestPartitionedIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	configure := func(opts *Options) {
+		opts.MinCompact = 100
+		opts.BlockSize = 128
+		opts.IndexPartitionSize = 128
+	}
+	db := openTestDBOn(t, fs, configure)
+	for i := 0; i < 300; i++ {
+		db.Put(fmt.Sprintf("key%04d", i), fmt.Sprintf("value%04d", i))
+	}
+	db.Flush()
+	db.Put("small", "table")
+	db.Flush()
+
+	tables := familyTables(db, db.DefaultColumnFamily())
+	large, small := tables[0], tables[len(tables)-1]
+	if !large.partitioned || len(large.index) < 2 {
+		t.Fatalf("large table: partitioned %v with %d top-level entries", large.partitioned, len(large.index))
+	}
+	blocks, err := large.blockIndex(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) <= len(large.index) {
+		t.Fatalf("%d blocks behind %d partitions", len(blocks), len(large.index))
+	}
+	if small.partitioned || len(small.index) != 1 {
+		t.Fatalf("small table: partitioned %v with %d index entries", small.partitioned, len(small.index))
+	}
+	// A top-level entry is not a partition of block entries.
+	if _, err := readIndexPartition(fs, large, IndexEntry{offset: 0, size: blocks[0].size}); err == nil {
+		t.Fatal("read a data block as an index partition")
+	}
+	db.Close()
+
+	db = openTestDBOn(t, fs, configure)
+	defer db.Close()
+	for i := 0; i < 300; i += 13 {
+		mustGet(t, db, fmt.Sprintf("key%04d", i), fmt.Sprintf("value%04d", i))
+	}
+	mustGet(t, db, "small", "table")
+	mustMiss(t, db, "key0300")
+}
+
+func TestOpenTableFromFooter(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, func(opts *Options) { opts.PrefixExtractor = FixedPrefix(3) })
+	for i := 0; i < 50; i++ {
+		db.Put(fmt.Sprintf("key%02d", i), "v")
+	}
+	db.Flush()
+	cf := db.DefaultColumnFamily()
+	path := familyTables(db, cf)[0].path
+	db.Close()
+
+	filter := &tableFilter{extractor: FixedPrefix(3)}
+	sstable, seq, ok := openTable(fs, nil, path, filter)
+	if !ok {
+		t.Fatal("table with a footer was not opened from it")
+	}
+	if sstable.entries != 50 || seq != 50 || sstable.smallest != "key00" || sstable.largest != "key49" {
+		t.Fatalf("opened %d entries, max seq %d, range %q..%q", sstable.entries, seq, sstable.smallest, sstable.largest)
+	}
+	if filter.keys == nil || !filter.mightContain("key07") || filter.prefixes == nil || !filter.mightContainPrefix("key") {
+		t.Fatal("filters not restored from the meta block")
+	}
+
+	// A prefix filter built by another extractor is dropped.
+	other := &tableFilter{extractor: FixedPrefix(4)}
+	if _, _, ok := openTable(fs, nil, path, other); !ok || other.prefixes != nil || other.extractor != nil {
+		t.Fatalf("kept a prefix filter of another extractor: %v", ok)
+	}
+
+	// Without its footer, the table is scanned instead.
+	f, err := fs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptLine(t, fs, path, strings.Count(string(data), "\n")-1)
+	if _, _, ok := openTable(fs, nil, path, &tableFilter{}); ok {
+		t.Fatal("opened a table without a footer from it")
+	}
+	scanned, seq, err := buildIndex(fs, nil, path, &tableFilter{keys: NewBloomFilter(1024, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 50 || !scanned.filter.mightContain("key07") {
+		t.Fatalf("scanned table: max seq %d", seq)
+	}
+}
+
+func TestUnreadableIndexPartitionFailsReads(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.BlockSize = 128
+		opts.IndexPartitionSize = 128
+	})
+	defer db.Close()
+	for i := 0; i < 300; i++ {
+		db.Put(fmt.Sprintf("key%04d", i), "old")
+	}
+	db.Flush()
+	for i := 0; i < 300; i++ {
+		db.Put(fmt.Sprintf("key%04d", i), "new")
+	}
+	db.Flush()
+	tables := familyTables(db, db.DefaultColumnFamily())
+	newest := tables[len(tables)-1]
+	if !newest.partitioned {
+		t.Fatal("table index not partitioned")
+	}
+
+	// Overwrite the partitions of the newest table.
+	original, err := vfs.ReadFile(fs, newest.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := []byte(string(original))
+	first, last := newest.index[0], newest.index[len(newest.index)-1]
+	for i := first.offset; i < last.offset+last.size-1; i++ {
+		if damaged[i] != '\n' {
+			damaged[i] = 'x'
+		}
+	}
+	writeFile := func(data []byte) {
+		f, err := fs.Create(newest.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+		f.Close()
+	}
+	writeFile(damaged)
+	if v, ok, err := db.Get(newest.largest); err == nil {
+		t.Fatalf("Get = %q, %v with the index partitions unreadable", v, ok)
+	}
+
+	// The failed partition was not cached as an empty one.
+	writeFile(original)
+	mustGet(t, db, newest.largest, "new")
+}
@@ -0,0 +1,238 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"distributedstore/vfs"
+)
+
+var ErrIngestOverlap = errors.New("lsm: ingested files overlap the memtable")
+
+type IngestOptions struct {
+	// ForceFlush flushes the memtables when an ingested file overlaps them,
+	// instead of failing with ErrIngestOverlap.
+	ForceFlush bool
+}
+
+type externalFile struct {
+	path string
+	smallest string
+	largest string
+}
+
+func (db *DB) IngestExternalFiles(paths []string, opts IngestOptions) error {
+	return db.IngestExternalFilesCF(db.DefaultColumnFamily(), paths, opts)
+}
+
+// IngestExternalFilesCF adds SSTables built by SSTWriter to cf without going
+// through the WAL or memtable. The files must not overlap each other. Files
+// that overlap no existing data are linked into the bottom level and keep
+// their zero sequence numbers; otherwise all entries are rewritten with one
+// new sequence number and placed in L0 as the newest tables. Writes to cf
+// wait until the ingest is done.
+func (db *DB) IngestExternalFilesCF(cf *ColumnFamily, paths []string, opts IngestOptions) error {
+	db.ingestMu.Lock()
+	defer db.ingestMu.Unlock()
+
+	var files []*externalFile
+	for _, path := range paths {
+		f, err := scanExternalFile(db.fs, path, db.cmp)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return db.cmp.Compare(files[i].smallest, files[j].smallest) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if db.cmp.Compare(files[i-1].largest, files[i].smallest) >= 0 {
+			return fmt.Errorf("lsm: ingested files %s and %s overlap", files[i-1].path, files[i].path)
+		}
+	}
+
+	cf.writeMu.Lock()
+	defer cf.writeMu.Unlock()
+	db.mu.Lock()
+	if db.memtablesOverlapLocked(cf, files) {
+		if !opts.ForceFlush {
+			db.mu.Unlock()
+			return ErrIngestOverlap
+		}
+		db.mu.Unlock()
+		db.Flush()
+		db.mu.Lock()
+		if db.memtablesOverlapLocked(cf, files) {
+			db.mu.Unlock()
+			return ErrIngestOverlap
+		}
+	}
+	// The sequence number is taken, and so made the DB's latest, before the
+	// rewritten tables exist: writes after the ingest are numbered above
+	// them, and a reopen recovers it from the tables.
+	seq := 0
+	if db.tablesOverlapLocked(cf, files) {
+		seq = db.nextSeq()
+	}
+	ids := make([]int, len(files))
+	for i := range files {
+		ids[i] = db.nextFileId
+		db.nextFileId++
+	}
+	db.mu.Unlock()
+
+	var tables []*SSTable
+	for i, f := range files {
+		target := filepath.Join(cf.dir, fmt.Sprintf("sst-%06d.sst", ids[i]))
+		sstable, err := db.ingestFile(cf, f, target, seq)
+		if err != nil {
+			for _, t := range tables {
+				db.fs.Remove(t.path)
+			}
+			return err
+		}
+		tables = append(tables, sstable)
+	}
+
+	db.mu.Lock()
+	previous := cf.sstables
+	if seq == 0 {
+		for _, t := range tables {
+			t.level = 1
+		}
+		cf.sstables = append(tables, cf.sstables...)
+	} else {
+		cf.sstables = append(slices.Clone(cf.sstables), tables...)
+	}
+	if err := db.saveManifestLocked(); err != nil {
+		// Files the manifest does not list would be deleted on the next
+		// open, so the ingestion is undone rather than acknowledged.
+		cf.sstables = previous
+		db.mu.Unlock()
+		for _, t := range tables {
+			db.fs.Remove(t.path)
+		}
+		return err
+	}
+	db.stats.ingestedFiles.Add(int64(len(tables)))
+	compact := cf.compactionDue()
+	db.mu.Unlock()
+
+	for _, t := range tables {
+		info := db.tableFileInfo(cf, t, TableFileIngestion)
+		db.notify(func(l EventListener) { l.OnTableFileCreated(info) })
+	}
+	db.refreshWriteStall()
+	if compact {
+		db.maybeScheduleCompaction()
+	}
+	return nil
+}
+
+func (db *DB) ingestFile(cf *ColumnFamily, f *externalFile, target string, seq int) (*SSTable, error) {
+	// External files are written in plaintext, so an encrypted DB rewrites
+	// them rather than linking them in.
+	if seq == 0 && db.keys == nil {
+		if err := linkOrCopy(db.fs, f.path, target); err != nil {
+			return nil, err
+		}
+		sstable, _, err := buildIndex(db.fs, nil, target, cf.newFilter())
+		if err != nil {
+			return nil, err
+		}
+		if sstable.checksum, err = tableChecksum(db.fs, target); err != nil {
+			return nil, err
+		}
+		return sstable, nil
+	}
+
+	w, err := newTableWriter(db.fs, db.keys, target+".tmp", target, cf.newFilter(), cf.blockFormat())
+	if err != nil {
+		return nil, err
+	}
+	it := newSSTableIter(db.fs, nil, f.path)
+	defer it.Close()
+	for it.Next(); it.Valid(); it.Next() {
+		w.add(seq, it.Kind(), it.Key(), it.Value())
+	}
+	if err := it.Err(); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	return w.finish()
+}
+
+func (db *DB) memtablesOverlapLocked(cf *ColumnFamily, files []*externalFile) bool {
+	memtables := append([]*Memtable{cf.memtable}, cf.imm...)
+	for _, memtable := range memtables {
+		for _, f := range files {
+			if memtable.overlaps(f.smallest, f.largest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (db *DB) tablesOverlapLocked(cf *ColumnFamily, files []*externalFile) bool {
+	for _, sstable := range cf.sstables {
+		for _, f := range files {
+			if db.cmp.Compare(sstable.smallest, f.largest) <= 0 && db.cmp.Compare(f.smallest, sstable.largest) <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scanExternalFile checks that every line of an external SSTable parses,
+// that its keys are in strictly increasing order and that, like those
+// SSTWriter writes, its entries have no sequence numbers. One that does
+// would be linked in with them, above or below writes it was never ordered
+// against.
+func scanExternalFile(fs vfs.FS, path string, cmp Comparator) (*externalFile, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &externalFile{path: path}
+	sc := newScanner(file, nil)
+	line, entries := 0, 0
+	for sc.Scan() {
+		line++
+		if isStructureLine(sc.Text()) {
+			continue
+		}
+		e, err := parseTableEntry(sc.Text(), f.largest)
+		if err != nil || e.kind == KindBlobIndex {
+			return nil, fmt.Errorf("lsm: %s:%d: malformed entry", path, line)
+		}
+		if e.seq != 0 {
+			return nil, fmt.Errorf("lsm: %s:%d: entry has sequence number %d", path, line, e.seq)
+		}
+		if entries > 0 && cmp.Compare(f.largest, e.key) >= 0 {
+			return nil, fmt.Errorf("%w: %s:%d: %q after %q", ErrKeyOrder, path, line, e.key, f.largest)
+		}
+		if entries == 0 {
+			f.smallest = e.key
+		}
+		f.largest = e.key
+		entries++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if entries == 0 {
+		return nil, fmt.Errorf("lsm: %s: empty table", path)
+	}
+	return f, nil
+}
@@ -0,0 +1,188 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+// writeExternalFile writes kv, alternating keys and values in increasing key
+// order, to an SSTable in dir for ingestion.
+func writeExternalFile(t *testing.T, dir string, name string, kv ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	w, err := NewSSTWriter(path, SSTWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		if err := w.Put(kv[i], kv[i+1]); err != nil {
+			t.Fatalf("put %s: %v", kv[i], err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// openDiskDB opens a DB under dir on the OS filesystem, which SSTWriter
+// writes to by default.
+func openDiskDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.FS = vfs.Default
+	db, err := Open(filepath.Join(dir, "db"), opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db
+}
+
+func TestIngestExternalFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := openDiskDB(t, dir)
+	defer db.Close()
+
+	large := strings.Repeat("x", 100<<10)
+	if err := db.IngestExternalFiles([]string{writeExternalFile(t, dir, "a.sst", "a", "1", "b", large)}, IngestOptions{}); err != nil {
+		t.Fatalf("ingest into empty db: %v", err)
+	}
+	db.mu.RLock()
+	level := db.defaultFamily.sstables[0].level
+	db.mu.RUnlock()
+	if level != 1 {
+		t.Fatalf("non-overlapping file went to level %d, want the bottom", level)
+	}
+	mustGet(t, db, "a", "1")
+	mustGet(t, db, "b", large)
+
+	// A file overlapping existing tables is newer than everything in them.
+	db.Put("c", "old")
+	db.Flush()
+	if err := db.IngestExternalFiles([]string{writeExternalFile(t, dir, "b.sst", "b", "2", "c", "new")}, IngestOptions{}); err != nil {
+		t.Fatalf("ingest over tables: %v", err)
+	}
+	mustGet(t, db, "b", "2")
+	mustGet(t, db, "c", "new")
+	db.Put("c", "newest")
+	mustGet(t, db, "c", "newest")
+}
+
+func TestIngestRejectsOverlaps(t *testing.T) {
+	dir := t.TempDir()
+	db := openDiskDB(t, dir)
+	defer db.Close()
+
+	db.Put("m", "memtable")
+	overlapping := writeExternalFile(t, dir, "m.sst", "l", "1", "n", "2")
+	if err := db.IngestExternalFiles([]string{overlapping}, IngestOptions{}); !errors.Is(err, ErrIngestOverlap) {
+		t.Fatalf("ingest over the memtable: %v, want ErrIngestOverlap", err)
+	}
+	if err := db.IngestExternalFiles([]string{overlapping}, IngestOptions{ForceFlush: true}); err != nil {
+		t.Fatalf("ingest with ForceFlush: %v", err)
+	}
+	mustGet(t, db, "m", "memtable")
+	mustGet(t, db, "n", "2")
+
+	x := writeExternalFile(t, dir, "x.sst", "x", "1", "z", "1")
+	y := writeExternalFile(t, dir, "y.sst", "y", "1")
+	if err := db.IngestExternalFiles([]string{x, y}, IngestOptions{}); err == nil {
+		t.Fatal("ingested files that overlap each other")
+	}
+	mustMiss(t, db, "x")
+
+	w, err := NewSSTWriter(filepath.Join(dir, "bad.sst"), SSTWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abandon()
+	w.Put("b", "1")
+	if err := w.Put("a", "1"); !errors.Is(err, ErrKeyOrder) {
+		t.Fatalf("out of order put: %v, want ErrKeyOrder", err)
+	}
+}
+
+func TestIngestFileWrittenWithFamilyOptions(t *testing.T) {
+	fs := vfs.NewMemFS()
+	family := DefaultColumnFamilyOptions()
+	family.BlockSize = 64
+	family.PrefixExtractor = FixedPrefix(2)
+	db := openTestDBOn(t, fs, func(opts *Options) { opts.ColumnFamilyOptions = family })
+	defer db.Close()
+
+	w, err := NewSSTWriter("/ext.sst", SSTWriterOptions{FS: fs, ColumnFamilyOptions: family})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := w.Put(fmt.Sprintf("k%03d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{"/ext.sst"}, IngestOptions{}); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	db.mu.RLock()
+	sstable := db.defaultFamily.sstables[0]
+	db.mu.RUnlock()
+	if len(sstable.index) < 2 {
+		t.Fatalf("%d blocks, want the table cut at the family's block size", len(sstable.index))
+	}
+	if sstable.filter.prefixes == nil {
+		t.Fatal("ingested table has no prefix filter")
+	}
+	mustGet(t, db, "k042", "v")
+}
+
+func TestIngestRejectsSequenceNumbers(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, nil)
+	defer db.Close()
+
+	w, err := newTableWriter(fs, nil, "/seq.sst.tmp", "/seq.sst", nil, defaultBlockFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.add(7, KindPut, "a", "1")
+	if _, err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{"/seq.sst"}, IngestOptions{}); err == nil {
+		t.Fatal("ingested a file with sequence numbers")
+	}
+	mustMiss(t, db, "a")
+}
+
+func TestIngestedSequenceNumberSurvivesReopen(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, nil)
+	db.Put("a", "old")
+	db.Flush()
+	w, err := NewSSTWriter("/ext.sst", SSTWriterOptions{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Put("a", "ingested")
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{"/ext.sst"}, IngestOptions{}); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	db.Close()
+
+	db = openTestDBOn(t, fs, nil)
+	defer db.Close()
+	mustGet(t, db, "a", "ingested")
+	db.Put("a", "new")
+	db.Flush()
+	mustGet(t, db, "a", "new")
+}
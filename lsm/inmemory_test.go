@@ -0,0 +1,54 @@
+package lsm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestInMemoryDBLeavesNothingOnDisk(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	opts := DefaultOptions()
+	opts.InMemory = true
+	// InMemory takes the place of FS, so a filesystem that fails
+	// everything is never touched.
+	broken := vfs.NewFaultFS(vfs.NewMemFS())
+	broken.Crash()
+	opts.FS = broken
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("a", "1")
+	db.Flush()
+	db.Put("b", "2")
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(dir + ".snap"); err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, db, "a", "1")
+	mustGet(t, db, "b", "2")
+	if _, err := db.FS().Stat(dir + ".snap"); err != nil {
+		t.Fatalf("checkpoint not kept in memory: %v", err)
+	}
+	db.Close()
+
+	for _, path := range []string{dir, dir + ".snap"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s on disk: %v", path, err)
+		}
+	}
+
+	// Each open starts from an empty filesystem.
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mustMiss(t, db, "a")
+}
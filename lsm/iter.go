@@ -1,58 +1,91 @@
 package lsm
 
 import (
-	"os"
-	"bufio"
-	"strconv"
-	"strings"
+	"fmt"
+	"io"
+
+	"distributedstore/vfs"
 )
 
 type SSTableIter struct {
-    file *os.File
-    sc *bufio.Scanner
+    file vfs.File
+    path string
+    sc *lineScanner
     key string
     seq int
     value string
     kind Kind
 	valid bool
+	err error
 }
 
 func NewSSTableIter(path string) *SSTableIter {
-    file, _ := os.Open(path)
-    return &SSTableIter{
-        file: file,
-        sc: bufio.NewScanner(file),
-		valid: true,
-    }
+	return newSSTableIter(vfs.Default, nil, path)
+}
+
+// newSSTableIter opens an iterator over an SSTable, decrypting it with the
+// key keys holds for it if it is encrypted. A table that cannot be read
+// yields no entries, and Err reports why.
+func newSSTableIter(fs vfs.FS, keys KeyProvider, path string) *SSTableIter {
+	it := &SSTableIter{path: path, valid: true}
+	file, err := fs.Open(path)
+	if err != nil {
+		file = errFile{err}
+	}
+	it.file = file
+	if it.sc, err = newLineScanner(file, keys); err != nil {
+		it.sc = scanLinesAt(errFile{err}, nil, 0)
+		it.fail(err)
+	}
+	return it
 }
 
+// Next moves to the next entry. The iterator stops at the first line that
+// cannot be read, decrypted or parsed, rather than skip it and the entries
+// whose keys depend on it; Err then reports it.
 func (it *SSTableIter) Next() {
-    if !it.sc.Scan() {
-		it.valid = false 
+	for it.valid {
+		if !it.sc.Scan() {
+			it.fail(it.sc.Err())
+			return
+		}
+		if err := it.sc.Damaged(); err != nil {
+			it.fail(fmt.Errorf("lsm: %s at %d: %v", it.path, it.sc.Offset(), err))
+			return
+		}
+		if isStructureLine(it.sc.Text()) {
+			continue
+		}
+		e, err := parseTableEntry(it.sc.Text(), it.key)
+		if err != nil {
+			it.fail(fmt.Errorf("lsm: %s at %d: %v", it.path, it.sc.Offset(), err))
+			return
+		}
+		it.key, it.seq, it.kind, it.value = e.key, e.seq, e.kind, e.value
 		return
 	}
+}
 
-	line := it.sc.Text()
-	parts := strings.SplitN(line, " ", 4)
-
-	op := parts[0]
-	seq, _ := strconv.Atoi(parts[1])
-	key := parts[2]
-
-	it.seq = seq
-	it.key = key
-	if op == "PUT" {
-		it.kind = KindPut
-		it.value = parts[3]
-	} else {
-		it.kind = KindDelete
-		it.value = ""
+// fail stops the iterator, recording err unless an earlier error was.
+func (it *SSTableIter) fail(err error) {
+	it.valid = false
+	if it.err == nil {
+		it.err = err
 	}
 }
 
+// seek moves the iterator to a byte offset, which must start a block. The
+// entry is read by the following Next.
+func (it *SSTableIter) seek(offset int64) {
+	it.file.Seek(offset, io.SeekStart)
+	it.sc = scanLinesAt(it.file, it.sc.cipher, offset)
+	it.key = ""
+}
+
 func (it *SSTableIter) Key() string { return it.key }
 func (it *SSTableIter) Seq() int { return it.seq }
 func (it *SSTableIter) Kind() Kind { return it.kind }
 func (it *SSTableIter) Value() string { return it.value }
 func (it *SSTableIter) Valid() bool { return it.valid }
+func (it *SSTableIter) Err() error { return it.err }
 func (it *SSTableIter) Close() { it.file.Close() }
@@ -0,0 +1,232 @@
+package lsm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDeadlock = errors.New("lsm: deadlock detected, transaction aborted")
+	ErrLockTimeout = errors.New("lsm: timed out waiting for lock")
+	ErrClosed = errors.New("lsm: db closed")
+)
+
+type LockMode int
+
+const (
+	LockShared LockMode = iota
+	LockExclusive
+)
+
+type keyLock struct {
+	holders map[uint64]LockMode
+	// queue holds the requests waiting for the lock, oldest first. A request
+	// is only granted once no earlier one it conflicts with is waiting, so a
+	// stream of shared lockers cannot starve an exclusive one.
+	queue []lockRequest
+	// wake is closed and replaced every time the holders or the queue change.
+	wake chan struct{}
+}
+
+type lockRequest struct {
+	txn uint64
+	mode LockMode
+}
+
+func conflicts(a LockMode, b LockMode) bool {
+	return a == LockExclusive || b == LockExclusive
+}
+
+// blockers returns the txns a request by txn for mode waits on: the holders
+// it conflicts with and the earlier requests it conflicts with. A txn that
+// already holds the lock only waits on the other holders, so upgrading a
+// shared lock does not queue behind requests that wait on it.
+func (kl *keyLock) blockers(txn uint64, mode LockMode) []uint64 {
+	var blockers []uint64
+	for holder, held := range kl.holders {
+		if holder != txn && conflicts(mode, held) {
+			blockers = append(blockers, holder)
+		}
+	}
+	if _, ok := kl.holders[txn]; ok {
+		return blockers
+	}
+	for _, r := range kl.queue {
+		if r.txn == txn {
+			break
+		}
+		if conflicts(mode, r.mode) {
+			blockers = append(blockers, r.txn)
+		}
+	}
+	return blockers
+}
+
+func (kl *keyLock) broadcast() {
+	close(kl.wake)
+	kl.wake = make(chan struct{})
+}
+
+type lockManager struct {
+	mu sync.Mutex
+	locks map[string]*keyLock
+	// waiting maps each blocked txn to the key it waits for. The wait-for
+	// graph is derived from it and the lock table whenever it is needed, so
+	// its edges follow every change to holders and queues.
+	waiting map[uint64]string
+	closed bool
+	closedCh chan struct{}
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks: make(map[string]*keyLock),
+		waiting: make(map[uint64]string),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func (lm *lockManager) acquire(txn uint64, key string, mode LockMode, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.closed {
+		return ErrClosed
+	}
+	kl := lm.locks[key]
+	if kl == nil {
+		kl = &keyLock{holders: make(map[uint64]LockMode), wake: make(chan struct{})}
+		lm.locks[key] = kl
+	}
+	if held, ok := kl.holders[txn]; ok && held >= mode {
+		return nil
+	}
+	if len(kl.blockers(txn, mode)) == 0 {
+		kl.holders[txn] = mode
+		return nil
+	}
+
+	kl.queue = append(kl.queue, lockRequest{txn: txn, mode: mode})
+	lm.waiting[txn] = key
+	if lm.reaches(txn, txn) {
+		lm.dequeue(kl, key, txn)
+		return ErrDeadlock
+	}
+	for {
+		wake := kl.wake
+		lm.mu.Unlock()
+		select {
+		case <-wake:
+		case <-lm.closedCh:
+		case <-deadline:
+			lm.mu.Lock()
+			if lm.closed {
+				return ErrClosed
+			}
+			lm.dequeue(kl, key, txn)
+			return ErrLockTimeout
+		}
+		lm.mu.Lock()
+		if lm.closed {
+			return ErrClosed
+		}
+		if len(kl.blockers(txn, mode)) == 0 {
+			kl.holders[txn] = mode
+			lm.dequeue(kl, key, txn)
+			return nil
+		}
+	}
+}
+
+// dequeue removes txn's request from the queue of key, and the lock if
+// nothing holds or waits for it any more.
+func (lm *lockManager) dequeue(kl *keyLock, key string, txn uint64) {
+	delete(lm.waiting, txn)
+	for i, r := range kl.queue {
+		if r.txn == txn {
+			kl.queue = append(kl.queue[:i], kl.queue[i+1:]...)
+			break
+		}
+	}
+	kl.broadcast()
+	if len(kl.holders) == 0 && len(kl.queue) == 0 {
+		delete(lm.locks, key)
+	}
+}
+
+// waitsFor returns the txns that txn is blocked on.
+func (lm *lockManager) waitsFor(txn uint64) []uint64 {
+	key, ok := lm.waiting[txn]
+	if !ok {
+		return nil
+	}
+	kl := lm.locks[key]
+	for _, r := range kl.queue {
+		if r.txn == txn {
+			return kl.blockers(txn, r.mode)
+		}
+	}
+	return nil
+}
+
+// reaches reports whether target can be reached from any txn that from is
+// waiting on.
+func (lm *lockManager) reaches(from uint64, target uint64) bool {
+	seen := make(map[uint64]struct{})
+	stack := []uint64{from}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range lm.waitsFor(n) {
+			if next == target {
+				return true
+			}
+			if _, ok := seen[next]; ok {
+				continue
+			}
+			seen[next] = struct{}{}
+			stack = append(stack, next)
+		}
+	}
+	return false
+}
+
+func (lm *lockManager) releaseAll(txn uint64, keys []string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for _, key := range keys {
+		kl := lm.locks[key]
+		if kl == nil {
+			continue
+		}
+		if _, ok := kl.holders[txn]; !ok {
+			continue
+		}
+		delete(kl.holders, txn)
+		kl.broadcast()
+		if len(kl.holders) == 0 && len(kl.queue) == 0 {
+			delete(lm.locks, key)
+		}
+	}
+}
+
+// close drops every held lock and fails all current and future waiters with
+// ErrClosed.
+func (lm *lockManager) close() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.closed {
+		return
+	}
+	lm.closed = true
+	lm.locks = make(map[string]*keyLock)
+	lm.waiting = make(map[uint64]string)
+	close(lm.closedCh)
+}
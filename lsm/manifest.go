@@ -0,0 +1,180 @@
+package lsm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"distributedstore/vfs"
+)
+
+const manifestName = "MANIFEST"
+
+// A family's log number is the oldest WAL it may still need writes from;
+// those in older WALs are in its tables. Manifests that lack it replay every
+// WAL.
+type familyMeta struct {
+	id int
+	name string
+	ttl time.Duration
+	log int
+}
+
+// Table lines list each family's live SSTables in search order, oldest
+// first, with the smallest and largest key they hold and the file's CRC-32C.
+// A manifest without any (written before they were recorded) leaves the
+// table set to directory discovery; older table lines lack the key range or
+// the checksum. Encrypted stores leave the key range out, so no user key is
+// written in plaintext.
+type manifestTable struct {
+	family int
+	level int
+	name string
+	smallest string
+	largest string
+	checksum uint32
+	hasChecksum bool
+}
+
+type manifest struct {
+	comparator string
+	families []familyMeta
+	tables []manifestTable
+}
+
+func readManifest(fs vfs.FS, dir string) (*manifest, error) {
+	var first error
+	m, err := scanManifest(fs, dir, func(_ int, err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if first != nil {
+		return nil, first
+	}
+	return m, nil
+}
+
+// scanManifest reads the manifest, passing lines that do not parse to bad
+// with their line number and skipping them.
+func scanManifest(fs vfs.FS, dir string, bad func(line int, err error)) (*manifest, error) {
+	file, err := fs.Open(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return &manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &manifest{}
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, maxLineSize)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if err := m.parseLine(line); err != nil {
+			bad(n, err)
+		}
+	}
+	return m, sc.Err()
+}
+
+func (m *manifest) parseLine(line string) error {
+	parts := strings.Fields(line)
+	malformed := fmt.Errorf("lsm: malformed manifest line %q", line)
+	switch parts[0] {
+	case "comparator":
+		if len(parts) != 2 {
+			return malformed
+		}
+		m.comparator = parts[1]
+	case "family":
+		if len(parts) != 4 && len(parts) != 5 {
+			return malformed
+		}
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return malformed
+		}
+		ttl, err := time.ParseDuration(parts[3])
+		if err != nil {
+			return malformed
+		}
+		f := familyMeta{id: id, name: parts[2], ttl: ttl}
+		if len(parts) == 5 {
+			if f.log, err = strconv.Atoi(parts[4]); err != nil {
+				return malformed
+			}
+		}
+		m.families = append(m.families, f)
+	case "table":
+		if len(parts) < 4 || len(parts) > 7 {
+			return malformed
+		}
+		family, err1 := strconv.Atoi(parts[1])
+		level, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			return malformed
+		}
+		t := manifestTable{family: family, level: level, name: parts[3]}
+		if len(parts) >= 6 {
+			t.smallest, t.largest = parts[4], parts[5]
+		}
+		if len(parts) == 5 || len(parts) == 7 {
+			crc, err := strconv.ParseUint(parts[len(parts)-1], 16, 32)
+			if err != nil {
+				return malformed
+			}
+			t.checksum, t.hasChecksum = uint32(crc), true
+		}
+		m.tables = append(m.tables, t)
+	}
+	return nil
+}
+
+func writeManifest(fs vfs.FS, dir string, m *manifest) error {
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	file, err := fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "comparator %s\n", m.comparator)
+	for _, f := range m.families {
+		fmt.Fprintf(writer, "family %d %s %s %d\n", f.id, f.name, f.ttl, f.log)
+	}
+	for _, t := range m.tables {
+		if t.smallest == "" && t.largest == "" {
+			fmt.Fprintf(writer, "table %d %d %s %08x\n", t.family, t.level, t.name, t.checksum)
+		} else {
+			fmt.Fprintf(writer, "table %d %d %s %s %s %08x\n", t.family, t.level, t.name, t.smallest, t.largest, t.checksum)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return fs.Rename(tmp, filepath.Join(dir, manifestName))
+}
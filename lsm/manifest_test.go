@@ -0,0 +1,51 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestFailedManifestWriteKeepsData(t *testing.T) {
+	mem := vfs.NewMemFS()
+	fault := vfs.NewFaultFS(mem)
+	listener := &recordingListener{}
+	db := openTestDBOn(t, fault, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.EventListeners = []EventListener{listener}
+	})
+	listener.db = db
+	for i := 0; i < 20; i++ {
+		db.Put(fmt.Sprintf("key%02d", i), "flushed")
+	}
+	db.Flush()
+
+	fault.FailOn(vfs.OpSync, manifestName+".tmp")
+	for i := 0; i < 20; i++ {
+		db.Put(fmt.Sprintf("key%02d", i), "unrecorded")
+	}
+	db.Flush()
+	if err := db.CompactRange(context.Background(), "", ""); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("compact with a failing manifest: %v", err)
+	}
+	listener.mu.Lock()
+	failures := len(listener.errors)
+	listener.mu.Unlock()
+	if failures != 2 {
+		t.Fatalf("%d background errors, want one for the flush and one for the compaction", failures)
+	}
+
+	// Whatever the last good manifest lists, and the WALs behind it, are
+	// still on disk.
+	recovered := openTestDBOn(t, mem.CrashClone(), nil)
+	for i := 0; i < 20; i++ {
+		mustGet(t, recovered, fmt.Sprintf("key%02d", i), "unrecorded")
+	}
+	recovered.Close()
+
+	fault.SetInjector(nil)
+	db.Close()
+}
@@ -2,11 +2,13 @@ package lsm
 	
 type Memtable struct {
 	skipList *SkipList
-	walPath string
+	// walId is the WAL that holds the memtable's first write; the rest are
+	// in it or later ones.
+	walId int
 }
 
-func NewMemtable() *Memtable {
-	return &Memtable{skipList: NewSkipList(10, 0.25)}
+func NewMemtable(cmp Comparator) *Memtable {
+	return &Memtable{skipList: NewSkipList(10, 0.25, cmp)}
 }
 
 func (memtable *Memtable) Get(key string) (string, bool) {
@@ -21,6 +23,15 @@ func (memtable *Memtable) Delete(seq int, key string) {
 	memtable.skipList.Delete(seq, key)
 }
 
+func (memtable *Memtable) overlaps(smallest string, largest string) bool {
+	x := memtable.skipList.seek(smallest)
+	return x != nil && memtable.skipList.cmp.Compare(x.key, largest) <= 0
+}
+
 func (memtable *Memtable) Size() int {
 	return memtable.skipList.Size()
 }
+
+func (memtable *Memtable) Bytes() int64 {
+	return memtable.skipList.Bytes()
+}
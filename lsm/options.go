@@ -0,0 +1,184 @@
+package lsm
+
+import (
+	"time"
+
+	"distributedstore/vfs"
+)
+
+type ColumnFamilyOptions struct {
+	// FlushThreshold is the number of memtable entries that triggers a flush.
+	FlushThreshold int
+	// MinCompact is the number of level-0 SSTables that triggers a
+	// compaction.
+	MinCompact int
+	// TargetFileSize is the size at which compaction cuts an output table.
+	TargetFileSize int64
+	// BlockSize is the size at which a table's data block is cut, and the
+	// unit the index and block cache work in. Keys are prefix compressed
+	// against the key before them, except at restart points, which every
+	// BlockRestartInterval entries store the whole key for lookups to
+	// binary search.
+	BlockSize int
+	BlockRestartInterval int
+	// IndexPartitionSize is the size at which a table's index is cut into
+	// partitions, which are read through the block cache when needed; only
+	// the top-level index of them stays in memory. Both sizes are capped at
+	// 64MiB.
+	IndexPartitionSize int
+	// BloomBits is the size of each table's bloom filter in bits, rounded up
+	// to a power of two. BloomHashes is the number of probes per key.
+	BloomBits uint
+	BloomHashes uint
+	// TTL expires values this long after they were written. It is fixed when
+	// the family is created; zero disables expiry.
+	TTL time.Duration
+	// CompactionFilter, if set, may drop or rewrite keys as they are
+	// compacted.
+	CompactionFilter CompactionFilter
+	// PrefixExtractor, if set, gives each table a bloom filter of key
+	// prefixes as well, which prefix iterators consult to skip tables.
+	PrefixExtractor PrefixExtractor
+	// MinBlobSize separates values of at least this many bytes into blob
+	// files when they are flushed, so compactions move a small reference
+	// instead of the value. Zero keeps every value in the SSTables.
+	MinBlobSize int
+	// BlobGCLiveRatio is the fraction of a blob file that must still be
+	// referenced; below it, compaction rewrites the live values elsewhere so
+	// the file can be deleted.
+	BlobGCLiveRatio float64
+	// Writes to the DB are delayed once any family reaches a slowdown
+	// threshold, increasingly so towards the stop threshold, where they
+	// block until flushes and compactions catch up.
+	ImmutableMemtableSlowdown int
+	ImmutableMemtableStop int
+	L0SlowdownFiles int
+	L0StopFiles int
+	SoftPendingCompactionBytes int64
+	HardPendingCompactionBytes int64
+}
+
+type Options struct {
+	// Comparator orders keys in every column family. It defaults to
+	// BytewiseComparator and cannot change once the DB has been created.
+	Comparator Comparator
+	// ColumnFamilyOptions apply to the default column family.
+	ColumnFamilyOptions
+	// ColumnFamilies are opened alongside the default family, and created if
+	// they do not exist yet.
+	ColumnFamilies map[string]ColumnFamilyOptions
+	// BlockCacheSize bounds the SSTable blocks kept in memory, in bytes.
+	BlockCacheSize int64
+	// EventListeners are told about flushes, compactions, table files and
+	// other background events, in order.
+	EventListeners []EventListener
+	// RateLimiter, if set, throttles the SSTable writes of flushes and
+	// compactions. It may be shared with other DBs.
+	RateLimiter *RateLimiter
+	// MaxBackgroundCompactions bounds the compactions running at once, each
+	// on a different column family. MaxSubcompactions is how many key ranges
+	// a large compaction is split into, which are merged in parallel into
+	// separate output tables.
+	MaxBackgroundCompactions int
+	MaxSubcompactions int
+	// MaxWriteDelay is how long a write is delayed just short of a stop.
+	MaxWriteDelay time.Duration
+	// FS is the filesystem the DB keeps its files on. It defaults to
+	// vfs.Default, the operating system's.
+	FS vfs.FS
+	// InMemory runs the DB on a fresh vfs.MemFS in place of FS, so nothing
+	// reaches disk and everything, checkpoints included, is gone once the
+	// DB is closed.
+	InMemory bool
+	// KeyProvider, if set, encrypts new WALs, SSTables and blob files with
+	// AES-GCM under its current key, and supplies the keys older files name.
+	// The manifest then leaves out the key range of each table.
+	KeyProvider KeyProvider
+}
+
+func DefaultColumnFamilyOptions() ColumnFamilyOptions {
+	return ColumnFamilyOptions{
+		FlushThreshold: dbFlushThreshold,
+		MinCompact: minCompact,
+		TargetFileSize: defaultTargetFileSize,
+		BlockSize: defaultBlockSize,
+		BlockRestartInterval: defaultBlockRestartInterval,
+		IndexPartitionSize: defaultIndexPartitionSize,
+		BloomBits: bloomM,
+		BloomHashes: bloomK,
+		BlobGCLiveRatio: defaultBlobGCLiveRatio,
+		ImmutableMemtableSlowdown: 4,
+		ImmutableMemtableStop: 8,
+		L0SlowdownFiles: 20,
+		L0StopFiles: 36,
+		SoftPendingCompactionBytes: 64 << 30,
+		HardPendingCompactionBytes: 256 << 30,
+	}
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Comparator: BytewiseComparator,
+		ColumnFamilyOptions: DefaultColumnFamilyOptions(),
+		BlockCacheSize: defaultBlockCacheSize,
+		MaxWriteDelay: defaultMaxWriteDelay,
+		MaxBackgroundCompactions: defaultMaxBackgroundCompactions,
+		MaxSubcompactions: 1,
+	}
+}
+
+func (opts ColumnFamilyOptions) sanitize() ColumnFamilyOptions {
+	def := DefaultColumnFamilyOptions()
+	if opts.FlushThreshold <= 0 {
+		opts.FlushThreshold = def.FlushThreshold
+	}
+	if opts.MinCompact <= 1 {
+		opts.MinCompact = def.MinCompact
+	}
+	if opts.TargetFileSize <= 0 {
+		opts.TargetFileSize = def.TargetFileSize
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = def.BlockSize
+	}
+	opts.BlockSize = min(opts.BlockSize, maxBlockSize)
+	if opts.BlockRestartInterval <= 0 {
+		opts.BlockRestartInterval = def.BlockRestartInterval
+	}
+	if opts.IndexPartitionSize <= 0 {
+		opts.IndexPartitionSize = def.IndexPartitionSize
+	}
+	opts.IndexPartitionSize = min(opts.IndexPartitionSize, maxBlockSize)
+	if opts.BloomBits == 0 {
+		opts.BloomBits = def.BloomBits
+	}
+	bits := uint(64)
+	for bits < opts.BloomBits {
+		bits <<= 1
+	}
+	opts.BloomBits = bits
+	if opts.BloomHashes == 0 {
+		opts.BloomHashes = def.BloomHashes
+	}
+	if opts.MinBlobSize < 0 {
+		opts.MinBlobSize = 0
+	}
+	if opts.BlobGCLiveRatio <= 0 {
+		opts.BlobGCLiveRatio = def.BlobGCLiveRatio
+	}
+	if opts.ImmutableMemtableSlowdown <= 0 {
+		opts.ImmutableMemtableSlowdown = def.ImmutableMemtableSlowdown
+	}
+	opts.ImmutableMemtableStop = max(opts.ImmutableMemtableStop, opts.ImmutableMemtableSlowdown)
+	if opts.L0SlowdownFiles <= 0 {
+		opts.L0SlowdownFiles = def.L0SlowdownFiles
+	}
+	// Compaction only starts at MinCompact tables; stopping short of that
+	// would block writes for good.
+	opts.L0StopFiles = max(opts.L0StopFiles, opts.L0SlowdownFiles, opts.MinCompact)
+	if opts.SoftPendingCompactionBytes <= 0 {
+		opts.SoftPendingCompactionBytes = def.SoftPendingCompactionBytes
+	}
+	opts.HardPendingCompactionBytes = max(opts.HardPendingCompactionBytes, opts.SoftPendingCompactionBytes)
+	return opts
+}
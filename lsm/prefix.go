@@ -0,0 +1,306 @@
+package lsm
+
+import (
+	"container/heap"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// PrefixExtractor maps keys to the prefix their family's tables keep a
+// bloom filter of, next to the one of whole keys. Keys outside its domain
+// are left out of the prefix filter. If InDomain(p) holds, every key that
+// starts with p must be in the domain too and transform to Transform(p), so
+// that iterating p can rule out tables by the prefix filter.
+type PrefixExtractor interface {
+	InDomain(key string) bool
+	Transform(key string) string
+}
+
+// FixedPrefix extracts the first n bytes of keys at least that long.
+func FixedPrefix(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
+
+type fixedPrefix int
+
+func (p fixedPrefix) InDomain(key string) bool { return len(key) >= int(p) }
+func (p fixedPrefix) Transform(key string) string { return key[:p] }
+
+// DelimitedPrefix extracts the part of keys up to and including the first
+// occurrence of delim, as "user-123/" of "user-123/profile".
+func DelimitedPrefix(delim string) PrefixExtractor {
+	return delimitedPrefix(delim)
+}
+
+type delimitedPrefix string
+
+func (p delimitedPrefix) InDomain(key string) bool { return strings.Contains(key, string(p)) }
+
+func (p delimitedPrefix) Transform(key string) string {
+	i := strings.Index(key, string(p))
+	return key[:i+len(p)]
+}
+
+// tableFilter holds the bloom filters of one table: one of whole keys and,
+// if the family has a prefix extractor, one of their prefixes.
+type tableFilter struct {
+	keys *BloomFilter
+	prefixes *BloomFilter
+	extractor PrefixExtractor
+}
+
+func (cf *ColumnFamily) newFilter() *tableFilter {
+	f := &tableFilter{keys: NewBloomFilter(cf.opts.BloomBits, cf.opts.BloomHashes)}
+	if cf.opts.PrefixExtractor != nil {
+		f.prefixes = NewBloomFilter(cf.opts.BloomBits, cf.opts.BloomHashes)
+		f.extractor = cf.opts.PrefixExtractor
+	}
+	return f
+}
+
+func (f *tableFilter) add(key string) {
+	if f == nil {
+		return
+	}
+	f.keys.add(key)
+	if f.extractor != nil && f.extractor.InDomain(key) {
+		f.prefixes.add(f.extractor.Transform(key))
+	}
+}
+
+func (f *tableFilter) mightContain(key string) bool {
+	return f == nil || f.keys.mightContain(key)
+}
+
+// mightContainPrefix reports whether the table may hold keys starting with
+// prefix. Only prefixes in the extractor's domain can be ruled out.
+func (f *tableFilter) mightContainPrefix(prefix string) bool {
+	if f == nil || f.extractor == nil || !f.extractor.InDomain(prefix) {
+		return true
+	}
+	return f.prefixes.mightContain(f.extractor.Transform(prefix))
+}
+
+// PrefixIterator walks the live keys of a column family that start with a
+// prefix, in key order, as they were when it was opened. It merges the
+// family's memtables and tables as it goes, reading table blocks only when
+// it reaches them, and keeps the files it reads from being deleted until it
+// is closed.
+type PrefixIterator struct {
+	db *DB
+	cf *ColumnFamily
+	blobs map[int]*blobFile
+	now time.Time
+	h *IterHeap
+	key string
+	value string
+	valid bool
+	err error
+	closed bool
+}
+
+// NewPrefixIterator opens an iterator over the keys of the default column
+// family that start with prefix. Tables whose prefix filter rules the prefix
+// out are not read. Where the comparator keeps keys sharing a prefix
+// adjacent, as bytewise and reverse bytewise order do, the iterator seeks
+// to them and stops after the last; with any other comparator it scans
+// every entry and skips those without the prefix.
+func (db *DB) NewPrefixIterator(prefix string) *PrefixIterator {
+	return db.NewPrefixIteratorCF(db.DefaultColumnFamily(), prefix)
+}
+
+func (db *DB) NewPrefixIteratorCF(cf *ColumnFamily, prefix string) *PrefixIterator {
+	ordered := prefixOrdered(db.cmp)
+	before := func(key string) bool { return ordered && db.beforePrefix(key, prefix) }
+	it := &PrefixIterator{db: db, cf: cf, blobs: make(map[int]*blobFile), now: time.Now(), h: NewIterHeap(db.cmp)}
+
+	// Deletions are held off before the table set is read, so none of its
+	// files can go while the iterator reads them.
+	db.disableFileDeletions()
+	db.mu.RLock()
+	var sources []Iterator
+	for _, m := range append([]*Memtable{cf.memtable}, cf.imm...) {
+		sources = append(sources, memtablePrefix(m, prefix, before))
+	}
+	var tables []*SSTable
+	for _, t := range cf.sstables {
+		if ordered && (db.beforePrefix(t.largest, prefix) || (!strings.HasPrefix(t.smallest, prefix) && db.cmp.Compare(t.smallest, prefix) > 0)) {
+			continue
+		}
+		if !t.filter.mightContainPrefix(prefix) {
+			db.stats.prefixFilterUseful.Add(1)
+			continue
+		}
+		tables = append(tables, t)
+	}
+	maps.Copy(it.blobs, cf.blobs)
+	db.mu.RUnlock()
+
+	for _, t := range tables {
+		sources = append(sources, db.newTablePrefixIter(t, prefix, before))
+	}
+	for _, src := range sources {
+		it.push(src)
+	}
+	it.Next()
+	return it
+}
+
+// prefixOrdered reports whether cmp keeps the keys sharing any prefix
+// adjacent, so iterating one can seek to it and stop past it.
+func prefixOrdered(cmp Comparator) bool {
+	return cmp == BytewiseComparator || cmp == ReverseBytewiseComparator
+}
+
+// beforePrefix reports whether key orders before every key starting with
+// prefix.
+func (db *DB) beforePrefix(key string, prefix string) bool {
+	return !strings.HasPrefix(key, prefix) && db.cmp.Compare(key, prefix) < 0
+}
+
+// memtablePrefix copies the entries of m that start with prefix, seeking
+// past those before says precede them. A memtable is small enough to copy,
+// and its skip list may not be read without db.mu.
+func memtablePrefix(m *Memtable, prefix string, before func(key string) bool) *entryIter {
+	it := &entryIter{}
+	ordered := prefixOrdered(m.skipList.cmp)
+	for x := m.skipList.seekFunc(before); x != nil; x = x.forward[0] {
+		if strings.HasPrefix(x.key, prefix) {
+			it.entries = append(it.entries, blockEntry{key: x.key, seq: x.seq, kind: x.kind, value: x.value})
+		} else if ordered {
+			break
+		}
+	}
+	return it
+}
+
+// entryIter iterates decoded entries.
+type entryIter struct {
+	entries []blockEntry
+	pos int
+}
+
+func (it *entryIter) Key() string { return it.entries[it.pos].key }
+func (it *entryIter) Seq() int { return it.entries[it.pos].seq }
+func (it *entryIter) Kind() Kind { return it.entries[it.pos].kind }
+func (it *entryIter) Value() string { return it.entries[it.pos].value }
+func (it *entryIter) Valid() bool { return it.pos < len(it.entries) }
+func (it *entryIter) Err() error { return nil }
+func (it *entryIter) Next() { it.pos++ }
+func (it *entryIter) Close() {}
+
+// tablePrefixIter reads the entries of a table that start with a prefix,
+// from the block that may hold the first of them on, until one orders past
+// them.
+type tablePrefixIter struct {
+	*SSTableIter
+	prefix string
+	before func(key string) bool
+	ordered bool
+	done bool
+}
+
+func (db *DB) newTablePrefixIter(sstable *SSTable, prefix string, before func(key string) bool) *tablePrefixIter {
+	it := &tablePrefixIter{SSTableIter: newSSTableIter(db.fs, db.keys, sstable.path), prefix: prefix, before: before, ordered: prefixOrdered(db.cmp)}
+	if it.ordered {
+		err := db.forEachBlock(sstable, before, func(block IndexEntry) bool {
+			it.seek(block.offset)
+			return false
+		})
+		if err != nil {
+			it.fail(err)
+		}
+	}
+	it.Next()
+	return it
+}
+
+func (it *tablePrefixIter) Next() {
+	for it.SSTableIter.Next(); it.SSTableIter.Valid() && !strings.HasPrefix(it.Key(), it.prefix); it.SSTableIter.Next() {
+		if it.ordered && !it.before(it.Key()) {
+			it.done = true
+			return
+		}
+	}
+}
+
+func (it *tablePrefixIter) Valid() bool { return !it.done && it.SSTableIter.Valid() }
+
+// push adds src to the merge, or closes it if it is exhausted. A source
+// that stopped early fails the whole iteration.
+func (it *PrefixIterator) push(src Iterator) {
+	if !src.Valid() {
+		if err := src.Err(); err != nil && it.err == nil {
+			it.err = err
+		}
+		src.Close()
+		return
+	}
+	heap.Push(it.h, &HeapItem{it: src, key: src.Key(), seq: src.Seq(), kind: src.Kind(), value: src.Value()})
+}
+
+// Next moves to the next live key. The newest version of each key decides
+// it; deletes and expired values are passed over. The iteration stops, with
+// Err reporting why, at a table entry or blob value that cannot be read.
+func (it *PrefixIterator) Next() {
+	it.valid = false
+	for it.err == nil && it.h.Len() > 0 {
+		top := heap.Pop(it.h).(*HeapItem)
+		key, kind, value := top.key, top.kind, top.value
+		top.it.Next()
+		it.push(top.it)
+		for it.h.Len() > 0 && it.h.Top().key == key {
+			older := heap.Pop(it.h).(*HeapItem)
+			older.it.Next()
+			it.push(older.it)
+		}
+		if it.err != nil {
+			// An older version may be missing, or a newer one may follow
+			// the entry that could not be read.
+			return
+		}
+
+		switch kind {
+		case KindDelete:
+			continue
+		case KindBlobIndex:
+			ref, err := parseBlobRef(value)
+			if err != nil {
+				it.err = fmt.Errorf("lsm: key %q: %v", key, err)
+				return
+			}
+			if value, err = it.db.readBlob(it.blobs[ref.file], ref, key); err != nil {
+				it.err = err
+				return
+			}
+		}
+		value, ok := it.cf.unwrapValue(value, it.now)
+		if !ok {
+			continue
+		}
+		it.key, it.value, it.valid = key, value, true
+		return
+	}
+}
+
+func (it *PrefixIterator) Valid() bool { return it.valid }
+
+// Err reports why the iteration stopped before the last key, if it did.
+func (it *PrefixIterator) Err() error { return it.err }
+func (it *PrefixIterator) Key() string { return it.key }
+func (it *PrefixIterator) Value() string { return it.value }
+
+// Close releases the files the iterator reads, letting them be deleted.
+func (it *PrefixIterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed, it.valid = true, false
+	for _, item := range it.h.items {
+		item.it.Close()
+	}
+	it.h.items = nil
+	it.db.enableFileDeletions()
+}
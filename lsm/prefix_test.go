@@ -0,0 +1,84 @@
+package lsm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrefixIteratorMergesNewestVersions(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.PrefixExtractor = FixedPrefix(5)
+	})
+	defer db.Close()
+	db.Put("user:1", "old")
+	db.Put("user:2", "gone")
+	db.Put("users", "x")
+	db.Flush()
+	db.Put("aaaaa", "p")
+	db.Put("zzzzz", "p")
+	db.Flush()
+	db.Put("user:1", "new")
+	db.Delete("user:2")
+	db.Put("user:3", "mem")
+
+	it := db.NewPrefixIterator("user:")
+	db.Put("user:4", "after")
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, it.Key()+"="+it.Value())
+	}
+	it.Close()
+	it.Close()
+	if want := []string{"user:1=new", "user:3=mem"}; !slices.Equal(got, want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+	// The table spanning a..z was ruled out by its prefix filter.
+	if s := db.Stats(); s.PrefixFilterUseful != 1 {
+		t.Fatalf("prefix filter useful %d, want 1", s.PrefixFilterUseful)
+	}
+	if keys := prefixKeys(db, db.DefaultColumnFamily(), "none"); len(keys) != 0 {
+		t.Fatalf("keys with an absent prefix: %v", keys)
+	}
+}
+
+func TestPrefixIteratorKeepsFilesThroughCompaction(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.MinCompact = 100 })
+	defer db.Close()
+	value := strings.Repeat("v", 100)
+	for _, key := range []string{"a1", "a2", "a3"} {
+		db.Put(key, value)
+		db.Flush()
+	}
+
+	it := db.NewPrefixIterator("a")
+	defer it.Close()
+	if !it.Valid() || it.Key() != "a1" {
+		t.Fatal("iterator did not start at a1")
+	}
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	var rest []string
+	for it.Next(); it.Valid(); it.Next() {
+		rest = append(rest, it.Key())
+	}
+	if !slices.Equal(rest, []string{"a2", "a3"}) {
+		t.Fatalf("after compaction iterated %v", rest)
+	}
+}
+
+func TestPrefixIteratorReverseOrder(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.Comparator = ReverseBytewiseComparator })
+	defer db.Close()
+	for _, key := range []string{"ab", "b", "aa", "a", "ac"} {
+		db.Put(key, "1")
+	}
+	db.Flush()
+	db.Put("ad", "1")
+	if keys := prefixKeys(db, db.DefaultColumnFamily(), "a"); !slices.Equal(keys, []string{"ad", "ac", "ab", "aa", "a"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+}
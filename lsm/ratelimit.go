@@ -0,0 +1,187 @@
+package lsm
+
+import (
+	"sync"
+	"time"
+)
+
+type IOPriority int
+
+const (
+	// IOPriorityLow is used by compactions.
+	IOPriorityLow IOPriority = iota
+	// IOPriorityHigh is used by flushes, which hold up writers when they
+	// fall behind.
+	IOPriorityHigh
+	numIOPriorities
+)
+
+const (
+	// rateLimitRefill is how often the bucket fills, and bounds a burst to
+	// that much worth of the rate.
+	rateLimitRefill = 100 * time.Millisecond
+	// rateLimitTuneInterval is how often an auto-tuned limiter reconsiders
+	// its rate, which moves by rateLimitTuneStep between its maximum and a
+	// twentieth of it.
+	rateLimitTuneInterval = time.Second
+	rateLimitTuneStep = 1.05
+	rateLimitTuneMinDivisor = 20
+	// rateLimitChunk is how many bytes a table writer accumulates before it
+	// asks the limiter for them.
+	rateLimitChunk = 32 << 10
+)
+
+// RateLimiter is a token bucket that throttles the SSTable writes of flushes
+// and compactions. One limiter may be shared by several DBs to bound their
+// combined disk bandwidth. Waiting flushes are served before compactions.
+//
+// An auto-tuned limiter starts at its maximum and lowers its rate while
+// background writes rarely have to wait for it, raising it again as they
+// queue up, so that compaction gets bandwidth only when it needs it.
+type RateLimiter struct {
+	mu sync.Mutex
+	rate int64
+	maxRate int64
+	autoTune bool
+	available int64
+	lastRefill time.Time
+	waiting [numIOPriorities]int
+
+	lastTune time.Time
+	requests int64
+	throttled int64
+
+	totalBytes [numIOPriorities]int64
+	totalWait time.Duration
+}
+
+// NewRateLimiter returns a limiter allowing bytesPerSec bytes a second.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	now := time.Now()
+	return &RateLimiter{
+		rate: bytesPerSec,
+		maxRate: bytesPerSec,
+		lastRefill: now,
+		lastTune: now,
+	}
+}
+
+// NewAutoTunedRateLimiter returns a limiter that tunes its own rate, never
+// above maxBytesPerSec.
+func NewAutoTunedRateLimiter(maxBytesPerSec int64) *RateLimiter {
+	rl := NewRateLimiter(maxBytesPerSec)
+	rl.autoTune = true
+	return rl
+}
+
+// SetRate changes the rate at once; zero or less disables limiting. For an
+// auto-tuned limiter it sets the maximum instead.
+func (rl *RateLimiter) SetRate(bytesPerSec int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.refillLocked(time.Now())
+	rl.maxRate = bytesPerSec
+	if !rl.autoTune || rl.rate > bytesPerSec {
+		rl.rate = bytesPerSec
+	}
+}
+
+func (rl *RateLimiter) Rate() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// TotalBytes returns the bytes granted at a priority so far.
+func (rl *RateLimiter) TotalBytes(pri IOPriority) int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.totalBytes[pri]
+}
+
+// TotalWait returns the time requests have spent waiting for the limiter.
+func (rl *RateLimiter) TotalWait() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.totalWait
+}
+
+// Request blocks until n bytes may be written at the given priority. A
+// request larger than what is available is granted as soon as the bucket is
+// non-empty, and the debt is paid off before the next one. A nil limiter
+// grants everything.
+func (rl *RateLimiter) Request(n int64, pri IOPriority) {
+	if rl == nil || n <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	start := time.Now()
+	waited := false
+	rl.waiting[pri]++
+	for {
+		now := time.Now()
+		rl.refillLocked(now)
+		if (rl.rate <= 0 || rl.available > 0) && !rl.outrankedLocked(pri) {
+			break
+		}
+		waited = true
+		wait := rateLimitRefill
+		if rl.rate > 0 && rl.available <= 0 {
+			wait = min(wait, time.Duration(float64(1-rl.available)/float64(rl.rate)*float64(time.Second)))
+		}
+		rl.mu.Unlock()
+		time.Sleep(max(wait, time.Millisecond))
+		rl.mu.Lock()
+	}
+	rl.waiting[pri]--
+	rl.available -= n
+	rl.totalBytes[pri] += n
+
+	rl.requests++
+	if waited {
+		rl.throttled++
+		rl.totalWait += time.Since(start)
+	}
+}
+
+// outrankedLocked reports whether a request at pri must let a waiting request
+// of higher priority go first.
+func (rl *RateLimiter) outrankedLocked(pri IOPriority) bool {
+	for p := pri + 1; p < numIOPriorities; p++ {
+		if rl.waiting[p] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *RateLimiter) refillLocked(now time.Time) {
+	if elapsed := now.Sub(rl.lastRefill); elapsed > 0 {
+		burst := max(rl.rate*int64(rateLimitRefill)/int64(time.Second), 1)
+		rl.available = min(rl.available+int64(float64(rl.rate)*elapsed.Seconds()), burst)
+		rl.lastRefill = now
+	}
+	if rl.autoTune && now.Sub(rl.lastTune) >= rateLimitTuneInterval {
+		rl.tuneLocked()
+		rl.lastTune = now
+	}
+}
+
+// tuneLocked raises the rate when most requests of the last interval had to
+// wait and lowers it when few did.
+func (rl *RateLimiter) tuneLocked() {
+	if rl.requests > 0 && rl.maxRate > 0 {
+		ratio := float64(rl.throttled) / float64(rl.requests)
+		minRate := max(rl.maxRate/rateLimitTuneMinDivisor, 1)
+		switch {
+		case ratio > 0.9:
+			rl.rate = min(int64(float64(rl.rate)*rateLimitTuneStep)+1, rl.maxRate)
+		case ratio < 0.5:
+			rl.rate = max(int64(float64(rl.rate)/rateLimitTuneStep), minRate)
+		}
+	}
+	rl.requests = 0
+	rl.throttled = 0
+}
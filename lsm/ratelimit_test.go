@@ -0,0 +1,93 @@
+package lsm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterThrottlesToRate(t *testing.T) {
+	rl := NewRateLimiter(100 << 10)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		rl.Request(10<<10, IOPriorityLow)
+	}
+	// The bucket starts empty and refills at 100KB/s, so the last 10KB
+	// are paid for at 200ms at the earliest.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("30KB at 100KB/s took %s", elapsed)
+	}
+	if got := rl.TotalBytes(IOPriorityLow); got != 30<<10 {
+		t.Fatalf("granted %d bytes, want %d", got, 30<<10)
+	}
+	if rl.TotalWait() <= 0 {
+		t.Fatal("no wait recorded")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	var nilLimiter *RateLimiter
+	nilLimiter.Request(1<<30, IOPriorityHigh)
+
+	rl := NewRateLimiter(1)
+	rl.SetRate(0)
+	start := time.Now()
+	rl.Request(1<<30, IOPriorityHigh)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("disabled limiter waited %s", elapsed)
+	}
+	if rl.TotalWait() != 0 || rl.Rate() != 0 {
+		t.Fatalf("disabled limiter: wait %s, rate %d", rl.TotalWait(), rl.Rate())
+	}
+}
+
+func TestRateLimiterServesFlushesFirst(t *testing.T) {
+	rl := NewRateLimiter(1 << 20)
+	rl.waiting[IOPriorityHigh]++
+	if !rl.outrankedLocked(IOPriorityLow) {
+		t.Fatal("compaction not held behind a waiting flush")
+	}
+	if rl.outrankedLocked(IOPriorityHigh) {
+		t.Fatal("flush held behind itself")
+	}
+}
+
+func TestAutoTunedRateLimiterFollowsDemand(t *testing.T) {
+	rl := NewAutoTunedRateLimiter(1000)
+	rl.requests, rl.throttled = 10, 0
+	rl.tuneLocked()
+	if rl.rate >= 1000 {
+		t.Fatalf("rate %d not lowered while idle", rl.rate)
+	}
+	for i := 0; i < 200; i++ {
+		rl.requests, rl.throttled = 10, 0
+		rl.tuneLocked()
+	}
+	if rl.rate != 1000/rateLimitTuneMinDivisor {
+		t.Fatalf("rate %d, want the floor %d", rl.rate, 1000/rateLimitTuneMinDivisor)
+	}
+	for i := 0; i < 200; i++ {
+		rl.requests, rl.throttled = 10, 10
+		rl.tuneLocked()
+	}
+	if rl.rate != 1000 {
+		t.Fatalf("rate %d, want the maximum 1000", rl.rate)
+	}
+	rl.SetRate(500)
+	if rl.Rate() != 500 {
+		t.Fatalf("rate %d after lowering the maximum to 500", rl.Rate())
+	}
+}
+
+func TestFlushGoesThroughRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(1 << 30)
+	db := openTestDB(t, func(opts *Options) { opts.RateLimiter = rl })
+	defer db.Close()
+	db.Put("a", "1")
+	db.Flush()
+	if rl.TotalBytes(IOPriorityHigh) == 0 {
+		t.Fatal("flush wrote without asking the limiter")
+	}
+	if rl.TotalBytes(IOPriorityLow) != 0 {
+		t.Fatal("flush asked at compaction priority")
+	}
+}
@@ -0,0 +1,533 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"distributedstore/vfs"
+)
+
+// lostDir is where Repair moves the originals of the files it rewrites or
+// drops, relative to the DB directory.
+const lostDir = "lost"
+
+// Problem is one inconsistency found by Verify, at a line of a file or, when
+// Line is zero, in the file as a whole.
+type Problem struct {
+	Path string
+	Line int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+type VerifyReport struct {
+	Tables int
+	Entries int
+	WALs int
+	WALRecords int
+	Problems []Problem
+}
+
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+type RepairReport struct {
+	// Lost lists the files, relative to the DB directory, whose originals
+	// were moved under lost/.
+	Lost []string
+	RewrittenTables int
+	DroppedTables int
+	SalvagedEntries int
+	DroppedEntries int
+	RewrittenWALs int
+	DroppedRecords int
+}
+
+// layout is what Verify and Repair know of a DB directory without opening
+// it: the manifest as far as it parses, and the tables it lists, or the ones
+// Open would discover if it lists none.
+type layout struct {
+	fs vfs.FS
+	keys KeyProvider
+	dir string
+	cmp Comparator
+	m *manifest
+	problems []Problem
+	tables []layoutTable
+	orphans []layoutTable
+}
+
+type layoutTable struct {
+	meta manifestTable
+	path string
+}
+
+func inspect(dir string, opts Options) (*layout, error) {
+	fs := opts.FS
+	if fs == nil {
+		fs = vfs.Default
+	}
+	if _, err := fs.Stat(dir); err != nil {
+		return nil, err
+	}
+	l := &layout{fs: fs, keys: opts.KeyProvider, dir: dir}
+	manifestPath := filepath.Join(dir, manifestName)
+	m, err := scanManifest(fs, dir, func(line int, err error) {
+		l.problems = append(l.problems, Problem{Path: manifestPath, Line: line, Message: err.Error()})
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.m = m
+
+	l.cmp = opts.Comparator
+	if l.cmp == nil {
+		l.cmp = BytewiseComparator
+		if m.comparator != "" {
+			l.cmp = builtinComparator(m.comparator)
+		}
+		if l.cmp == nil {
+			return nil, fmt.Errorf("lsm: db uses comparator %s, pass it in Options", m.comparator)
+		}
+	}
+	if m.comparator != "" && m.comparator != l.cmp.Name() {
+		return nil, fmt.Errorf("lsm: db was created with comparator %s, not %s", m.comparator, l.cmp.Name())
+	}
+
+	if len(m.families) == 0 {
+		m.families = []familyMeta{{id: 0, name: DefaultColumnFamilyName}}
+	}
+	dirs := make(map[int]string)
+	for _, f := range m.families {
+		dirs[f.id] = newColumnFamily(dir, f.id, f.name, ColumnFamilyOptions{}, l.cmp).dir
+	}
+
+	if len(m.tables) == 0 {
+		for _, f := range m.families {
+			for _, t := range discoverSSTables(fs, dirs[f.id]) {
+				name := filepath.Base(t.path)
+				l.tables = append(l.tables, layoutTable{meta: manifestTable{family: f.id, name: name}, path: t.path})
+			}
+		}
+		return l, nil
+	}
+
+	listed := make(map[string]bool)
+	for _, t := range m.tables {
+		fdir, ok := dirs[t.family]
+		if !ok {
+			l.problems = append(l.problems, Problem{Path: manifestPath, Message: fmt.Sprintf("table %s of unknown column family %d", t.name, t.family)})
+			continue
+		}
+		path := filepath.Join(fdir, t.name)
+		if listed[path] {
+			l.problems = append(l.problems, Problem{Path: manifestPath, Message: fmt.Sprintf("table %s listed twice", t.name)})
+			continue
+		}
+		listed[path] = true
+		if t.level != 0 && t.level != 1 {
+			l.problems = append(l.problems, Problem{Path: manifestPath, Message: fmt.Sprintf("table %s at invalid level %d", t.name, t.level)})
+		}
+		l.tables = append(l.tables, layoutTable{meta: t, path: path})
+	}
+	for _, f := range m.families {
+		for _, t := range discoverSSTables(fs, dirs[f.id]) {
+			if !listed[t.path] {
+				l.orphans = append(l.orphans, layoutTable{meta: manifestTable{family: f.id, name: filepath.Base(t.path)}, path: t.path})
+			}
+		}
+	}
+	return l, nil
+}
+
+func builtinComparator(name string) Comparator {
+	for _, cmp := range []Comparator{BytewiseComparator, ReverseBytewiseComparator, NumericComparator} {
+		if cmp.Name() == name {
+			return cmp
+		}
+	}
+	return nil
+}
+
+// tableCheck is the outcome of reading one SSTable: the entries that parse
+// and are in order, and what is wrong with the rest of it.
+type tableCheck struct {
+	entries []blockEntry
+	checksum uint32
+	dropped int
+	problems []Problem
+}
+
+// checkTable reads an SSTable line by line, checking that each entry parses
+// and sorts after the one before it, that the checksum and key range match
+// the manifest, and that the index and bloom filter Open builds for it find
+// every key. An encrypted block that does not decrypt counts as one dropped
+// entry.
+func checkTable(fs vfs.FS, keys KeyProvider, path string, cmp Comparator, meta manifestTable) (*tableCheck, error) {
+	data, err := vfs.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	c := &tableCheck{checksum: crc32.Checksum(data, castagnoli)}
+	problem := func(line int, format string, args ...any) {
+		c.problems = append(c.problems, Problem{Path: path, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	sc, err := newLineScanner(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	prev := ""
+	for sc.Scan() {
+		if sc.End() > int64(len(data)) {
+			c.dropped++
+			problem(sc.Line(), "truncated entry")
+			continue
+		}
+		if err := sc.Damaged(); err != nil {
+			c.dropped++
+			problem(sc.Line(), "%v", err)
+			continue
+		}
+		if isStructureLine(sc.Text()) {
+			continue
+		}
+		e, err := parseTableEntry(sc.Text(), prev)
+		prev = e.key
+		if err != nil {
+			c.dropped++
+			problem(sc.Line(), "%v", err)
+			continue
+		}
+		if n := len(c.entries); n > 0 && cmp.Compare(c.entries[n-1].key, e.key) >= 0 {
+			c.dropped++
+			problem(sc.Line(), "key %q out of order after %q", e.key, c.entries[n-1].key)
+			continue
+		}
+		c.entries = append(c.entries, e)
+		offsets = append(offsets, sc.Offset())
+	}
+	if len(data) == 0 {
+		problem(0, "empty table")
+	}
+	if meta.hasChecksum && c.checksum != meta.checksum {
+		problem(0, "checksum %08x does not match manifest %08x", c.checksum, meta.checksum)
+	}
+	if n := len(c.entries); n > 0 && meta.smallest != "" && (meta.smallest != c.entries[0].key || meta.largest != c.entries[n-1].key) {
+		problem(0, "key range [%s, %s] does not match manifest [%s, %s]", c.entries[0].key, c.entries[n-1].key, meta.smallest, meta.largest)
+	}
+	if len(c.problems) > 0 {
+		// buildIndex assumes every line parses.
+		return c, nil
+	}
+
+	sstable, _, err := buildIndex(fs, keys, path, &tableFilter{keys: NewBloomFilter(bloomM, bloomK)})
+	if err != nil {
+		return nil, err
+	}
+	if sstable.size != int64(len(data)) {
+		problem(0, "index covers %d of %d bytes", sstable.size, len(data))
+	}
+	blocks, err := sstable.blockIndex(fs)
+	if err != nil {
+		problem(0, "%v", err)
+		return c, nil
+	}
+	for i, e := range c.entries {
+		if !sstable.filter.mightContain(e.key) {
+			problem(0, "bloom filter misses key %q", e.key)
+		}
+		block := -1
+		for l, r := 0, len(blocks)-1; l <= r; {
+			m := (l + r) / 2
+			if cmp.Compare(blocks[m].key, e.key) <= 0 {
+				block = m
+				l = m + 1
+			} else {
+				r = m - 1
+			}
+		}
+		if block < 0 || offsets[i] < blocks[block].offset || offsets[i] >= blocks[block].offset+blocks[block].size {
+			problem(0, "index does not lead to key %q", e.key)
+		}
+	}
+	return c, nil
+}
+
+// Verify checks the DB in dir without opening it: that the manifest parses,
+// that every SSTable it lists is intact, that the blob file values its
+// tables reference exist, and that every WAL record parses.
+// A batch torn by a crash at the end of a WAL is not a problem. The
+// comparator is taken from opts, or from the manifest if it is built in.
+func Verify(dir string, opts Options) (*VerifyReport, error) {
+	l, err := inspect(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	r := &VerifyReport{Problems: l.problems}
+	for _, t := range l.tables {
+		r.Tables++
+		c, err := checkTable(l.fs, l.keys, t.path, l.cmp, t.meta)
+		if err != nil {
+			r.Problems = append(r.Problems, Problem{Path: t.path, Message: err.Error()})
+			continue
+		}
+		r.Entries += len(c.entries)
+		r.Problems = append(r.Problems, c.problems...)
+		r.Problems = append(r.Problems, l.checkBlobRefs(t.path, c.entries)...)
+	}
+	for _, t := range l.orphans {
+		r.Problems = append(r.Problems, Problem{Path: t.path, Message: "table not in the manifest"})
+	}
+	for _, w := range discoverWALs(l.fs, filepath.Join(dir, "wals")) {
+		r.WALs++
+		_, err := scanWAL(l.fs, l.keys, w.path, func(_ *walRecord, records []walRecord) {
+			r.WALRecords += len(records)
+		}, func(line int, err error) {
+			r.Problems = append(r.Problems, Problem{Path: w.path, Line: line, Message: err.Error()})
+		})
+		if err != nil {
+			r.Problems = append(r.Problems, Problem{Path: w.path, Message: err.Error()})
+		}
+	}
+	return r, nil
+}
+
+// checkBlobRefs checks that the values the entries of a table reference lie
+// within blob files of its family.
+func (l *layout) checkBlobRefs(path string, entries []blockEntry) []Problem {
+	var problems []Problem
+	sizes := make(map[int]int64)
+	for _, e := range entries {
+		if e.kind != KindBlobIndex {
+			continue
+		}
+		ref, _ := parseBlobRef(e.value)
+		size, ok := sizes[ref.file]
+		if !ok {
+			size = -1
+			if info, err := l.fs.Stat(filepath.Join(filepath.Dir(path), blobFileName(ref.file))); err == nil {
+				size = info.Size()
+			}
+			sizes[ref.file] = size
+		}
+		if size < 0 {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("key %q references missing blob file %s", e.key, blobFileName(ref.file))})
+		} else if ref.offset+ref.size > size {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("key %q references %s past its end", e.key, blobFileName(ref.file))})
+		}
+	}
+	return problems
+}
+
+// Repair makes the DB in dir open again. Damaged SSTables and WALs are
+// rewritten with the entries and records that are still readable, tables
+// not in the manifest are set aside, and the manifest is rebuilt from the
+// tables that remain. The original of every file changed or dropped is
+// moved under dir/lost/ first.
+func Repair(dir string, opts Options) (*RepairReport, error) {
+	l, err := inspect(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	r := &RepairReport{}
+	if len(l.problems) > 0 {
+		if err := l.moveToLost(filepath.Join(dir, manifestName), r); err != nil {
+			return r, err
+		}
+	}
+
+	// With the manifest intact, tables it does not list are leftovers of a
+	// crash that Open would delete. Otherwise they may have lost their line,
+	// so they are kept, and the table set is put in the order of the newest
+	// entry of each table, which keeps newer versions of a key after older
+	// ones.
+	tables := l.tables
+	if len(l.problems) > 0 {
+		tables = append(tables, l.orphans...)
+	} else {
+		for _, t := range l.orphans {
+			if err := l.moveToLost(t.path, r); err != nil {
+				return r, err
+			}
+		}
+	}
+	type repaired struct {
+		meta manifestTable
+		maxSeq int
+	}
+	var kept []repaired
+	for _, t := range tables {
+		c, err := checkTable(l.fs, l.keys, t.path, l.cmp, t.meta)
+		if os.IsNotExist(err) {
+			r.DroppedTables++
+			continue
+		}
+		if err != nil {
+			return r, err
+		}
+		if len(c.problems) > 0 {
+			if err := l.moveToLost(t.path, r); err != nil {
+				return r, err
+			}
+			r.DroppedEntries += c.dropped
+			if len(c.entries) == 0 {
+				r.DroppedTables++
+				continue
+			}
+			sstable, err := rewriteTable(l.fs, l.keys, t.path, c.entries)
+			if err != nil {
+				return r, err
+			}
+			r.RewrittenTables++
+			r.SalvagedEntries += len(c.entries)
+			c.checksum = sstable.checksum
+		}
+		if t.meta.level != 1 {
+			t.meta.level = 0
+		}
+		t.meta.smallest, t.meta.largest = c.entries[0].key, c.entries[len(c.entries)-1].key
+		if l.keys != nil {
+			t.meta.smallest, t.meta.largest = "", ""
+		}
+		t.meta.checksum, t.meta.hasChecksum = c.checksum, true
+		maxSeq := 0
+		for _, e := range c.entries {
+			maxSeq = max(maxSeq, e.seq)
+		}
+		kept = append(kept, repaired{meta: t.meta, maxSeq: maxSeq})
+	}
+	if len(l.problems) > 0 {
+		sort.SliceStable(kept, func(i, j int) bool {
+			return kept[i].maxSeq < kept[j].maxSeq
+		})
+	}
+	m := &manifest{comparator: l.cmp.Name(), families: l.m.families}
+	for _, t := range kept {
+		m.tables = append(m.tables, t.meta)
+	}
+
+	for _, w := range discoverWALs(l.fs, filepath.Join(dir, "wals")) {
+		if err := l.repairWAL(w.path, r); err != nil {
+			return r, err
+		}
+	}
+	return r, writeManifest(l.fs, dir, m)
+}
+
+func rewriteTable(fs vfs.FS, keys KeyProvider, path string, entries []blockEntry) (*SSTable, error) {
+	w, err := newTableWriter(fs, keys, path+".tmp", path, nil, defaultBlockFormat)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		w.add(e.seq, e.kind, e.key, e.value)
+	}
+	return w.finish()
+}
+
+// repairWAL rewrites a WAL with the records replay would apply, if any of
+// its lines are damaged.
+func (l *layout) repairWAL(path string, r *RepairReport) error {
+	var kept [][]walRecord
+	var headers []*walRecord
+	damaged := false
+	_, err := scanWAL(l.fs, l.keys, path, func(header *walRecord, records []walRecord) {
+		headers = append(headers, header)
+		kept = append(kept, records)
+	}, func(int, error) {
+		damaged = true
+	})
+	if err != nil || !damaged {
+		return err
+	}
+
+	data, err := vfs.ReadFile(l.fs, path)
+	if err != nil {
+		return err
+	}
+	sc, err := newLineScanner(bytes.NewReader(data), l.keys)
+	if err != nil {
+		return err
+	}
+	lines := 0
+	for sc.Scan() {
+		if sc.Damaged() != nil || strings.TrimSpace(sc.Text()) != "" {
+			lines++
+		}
+	}
+
+	tmp := path + ".tmp"
+	l.fs.Remove(tmp)
+	wal := openWAL(l.fs, l.keys, tmp)
+	if f, ok := wal.file.(errFile); ok {
+		return f.err
+	}
+	written := 0
+	for i, records := range kept {
+		if h := headers[i]; h != nil {
+			wal.WriteBatchHeader(h.seq, h.count)
+			written++
+		}
+		for _, rec := range records {
+			if rec.kind == KindPut {
+				wal.WritePut(rec.cf, rec.seq, rec.key, rec.value)
+			} else {
+				wal.WriteDel(rec.cf, rec.seq, rec.key)
+			}
+			written++
+		}
+	}
+	err = wal.Sync()
+	wal.Close()
+	if err != nil {
+		l.fs.Remove(tmp)
+		return err
+	}
+	if err := l.moveToLost(path, r); err != nil {
+		return err
+	}
+	if err := l.fs.Rename(tmp, path); err != nil {
+		return err
+	}
+	r.RewrittenWALs++
+	r.DroppedRecords += lines - written
+	return nil
+}
+
+// moveToLost moves a file under lost/, keeping its path relative to the DB
+// directory and adding a suffix if an earlier repair left one there.
+func (l *layout) moveToLost(path string, r *RepairReport) error {
+	rel, err := filepath.Rel(l.dir, path)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(l.dir, lostDir, rel)
+	if err := l.fs.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	for i := 1; ; i++ {
+		if _, err := l.fs.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s.%d", filepath.Join(l.dir, lostDir, rel), i)
+	}
+	if err := l.fs.Rename(path, target); err != nil {
+		return err
+	}
+	r.Lost = append(r.Lost, rel)
+	return nil
+}
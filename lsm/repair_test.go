@@ -0,0 +1,128 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+// corruptLine replaces the nth line of path with garbage.
+func corruptLine(t *testing.T, fs vfs.FS, path string, n int) {
+	t.Helper()
+	f, err := fs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	lines[n] = "garbage"
+	out, err := fs.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte(strings.Join(lines, "\n")))
+	out.Close()
+}
+
+func TestVerifyAndRepairDamagedTable(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, nil)
+	for i := 0; i < 100; i++ {
+		db.Put(fmt.Sprintf("k%03d", i), "v")
+	}
+	db.Flush()
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.FS = fs
+	report, err := Verify("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Tables != 1 || report.Entries != 100 {
+		t.Fatalf("intact db: %+v", report)
+	}
+
+	tables := discoverSSTables(fs, "/db/ssts")
+	if len(tables) != 1 {
+		t.Fatalf("%d tables", len(tables))
+	}
+	corruptLine(t, fs, tables[0].path, 10)
+	if report, err = Verify("/db", opts); err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Fatal("verify passed a damaged table")
+	}
+	if !strings.Contains(report.Problems[0].String(), filepath.Base(tables[0].path)) {
+		t.Fatalf("problem %s does not name the table", report.Problems[0])
+	}
+
+	repaired, err := Repair("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.RewrittenTables != 1 || repaired.DroppedEntries == 0 || repaired.SalvagedEntries+repaired.DroppedEntries < 100 || len(repaired.Lost) == 0 {
+		t.Fatalf("repair: %+v", repaired)
+	}
+	if _, err := fs.Stat(filepath.Join("/db", lostDir, repaired.Lost[0])); err != nil {
+		t.Fatalf("original not kept: %v", err)
+	}
+	if report, err = Verify("/db", opts); err != nil || !report.OK() {
+		t.Fatalf("verify after repair: %v %+v", err, report)
+	}
+	db = openTestDBOn(t, fs, nil)
+	defer db.Close()
+	present := 0
+	for i := 0; i < 100; i++ {
+		if _, ok, err := db.Get(fmt.Sprintf("k%03d", i)); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			present++
+		}
+	}
+	if present != repaired.SalvagedEntries {
+		t.Fatalf("%d keys readable, %d salvaged", present, repaired.SalvagedEntries)
+	}
+}
+
+func TestVerifyReportsTornWALRecord(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db := openTestDBOn(t, fs, nil)
+	db.Put("a", "1")
+	db.Put("b", "2")
+	db.Put("c", "3")
+	// Abandon the DB without closing it, so the records stay in the WAL.
+	wals := discoverWALs(fs, "/db/wals")
+	crashed := fs.CrashClone()
+	db.Close()
+
+	corruptLine(t, crashed, wals[len(wals)-1].path, 1)
+	opts := DefaultOptions()
+	opts.FS = crashed
+	report, err := Verify("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.WALs == 0 {
+		t.Fatalf("damaged wal: %+v", report)
+	}
+	repaired, err := Repair("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.RewrittenWALs != 1 || repaired.DroppedRecords == 0 {
+		t.Fatalf("repair: %+v", repaired)
+	}
+	db = openTestDBOn(t, crashed, nil)
+	defer db.Close()
+	mustGet(t, db, "c", "3")
+}
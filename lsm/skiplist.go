@@ -11,8 +11,22 @@ type Kind int
 const (
 	KindPut Kind = iota
 	KindDelete
+	// KindBlobIndex marks an SSTable entry whose value was separated into a
+	// blob file; its value is a reference to it. It never appears in a
+	// memtable or WAL.
+	KindBlobIndex
 )
 
+func (k Kind) String() string {
+	switch k {
+	case KindDelete:
+		return "DEL"
+	case KindBlobIndex:
+		return "BLOB"
+	}
+	return "PUT"
+}
+
 type Node struct {
 	key string
 	value string
@@ -25,14 +39,17 @@ type SkipList struct {
 	header *Node
 	p float64
 	size int
+	bytes int64
 	level int
 	maxLevel int
+	cmp Comparator
 }
 
-func NewSkipList(maxLevel int, p float64) *SkipList {
+func NewSkipList(maxLevel int, p float64, cmp Comparator) *SkipList {
 	header := &Node{forward: make([]*Node, maxLevel+1)}
 	return &SkipList{
 		header: header,
+		cmp: cmp,
 		level: 0,
 		maxLevel: maxLevel,
 		p: p,
@@ -43,6 +60,11 @@ func (skipList *SkipList) Size() int {
 	return skipList.size
 }
 
+// Bytes approximates the memory held by the list's keys and values.
+func (skipList *SkipList) Bytes() int64 {
+	return skipList.bytes
+}
+
 func (skipList *SkipList) randomFloat() float64 {
 	max := big.NewInt(1 << 53)
 	n, _ := rand.Int(rand.Reader, max)
@@ -58,8 +80,8 @@ func (skipList *SkipList) randomLevel() int {
 }
 
 func (skipList *SkipList) less(a, b *Node) bool {
-	if a.key != b.key {
-		return a.key < b.key
+	if c := skipList.cmp.Compare(a.key, b.key); c != 0 {
+		return c < 0
 	}
 	if a.seq != b.seq {
 		return a.seq > b.seq
@@ -85,6 +107,30 @@ func (skipList *SkipList) Get(key string) (string, bool) {
 	return x.value, true
 }
 
+// seek returns the first node whose key is at or after key.
+func (skipList *SkipList) seek(key string) *Node {
+	probe := &Node{key: key, seq: math.MaxInt, kind: KindPut}
+	x := skipList.header
+	for i := skipList.level; i >= 0; i-- {
+		for x.forward[i] != nil && skipList.less(x.forward[i], probe) {
+			x = x.forward[i]
+		}
+	}
+	return x.forward[0]
+}
+
+// seekFunc returns the first node for whose key before is false. before must
+// hold for a prefix of the list's keys and no others.
+func (skipList *SkipList) seekFunc(before func(key string) bool) *Node {
+	x := skipList.header
+	for i := skipList.level; i >= 0; i-- {
+		for x.forward[i] != nil && before(x.forward[i].key) {
+			x = x.forward[i]
+		}
+	}
+	return x.forward[0]
+}
+
 func (skipList *SkipList) Put(seq int, key string, value string) {
 	skipList.insertInternal(key, seq, KindPut, value)
 }
@@ -124,4 +170,5 @@ func (skipList *SkipList) insertInternal(key string, seq int, kind Kind, value s
 		update[i].forward[i] = newNode
 	}
 	skipList.size++
+	skipList.bytes += int64(len(key) + len(value))
 }
@@ -0,0 +1,130 @@
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactionCutsOutputsAtTargetSize(t *testing.T) {
+	const target = 8 << 10
+	db := openTestDB(t, func(opts *Options) {
+		opts.MinCompact = 100
+		opts.TargetFileSize = target
+	})
+	defer db.Close()
+	value := strings.Repeat("v", 500)
+	for i := 0; i < 200; i++ {
+		db.Put(fmt.Sprintf("k%04d", i), value)
+	}
+	if err := db.CompactRange(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := familyTables(db, db.DefaultColumnFamily())
+	if len(tables) < 10 {
+		t.Fatalf("100KB compacted into %d tables of target %d", len(tables), target)
+	}
+	entries := 0
+	for i, table := range tables {
+		entries += table.entries
+		// A table is cut after the entry that takes it past the target.
+		if i < len(tables)-1 && (table.size < target || table.size > target+2<<10) {
+			t.Errorf("table %d is %d bytes, target %d", i, table.size, target)
+		}
+		if i > 0 && db.cmp.Compare(tables[i-1].largest, table.smallest) >= 0 {
+			t.Errorf("tables %d and %d share keys", i-1, i)
+		}
+	}
+	if entries != 200 {
+		t.Fatalf("outputs hold %d entries, want 200", entries)
+	}
+	mustGet(t, db, "k0150", value)
+}
+
+func TestCompactionInputsClosedUnderOverlap(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.MinCompact = 100 })
+	defer db.Close()
+	table := func(smallest, largest string) *SSTable {
+		return &SSTable{smallest: smallest, largest: largest}
+	}
+	cf := db.DefaultColumnFamily()
+	ac, ce, eg, xz := table("a", "c"), table("c", "e"), table("e", "g"), table("x", "z")
+	db.mu.Lock()
+	saved := cf.sstables
+	cf.sstables = []*SSTable{ac, xz, ce, eg}
+	inputs := db.compactionInputsLocked(cf, "a", "b")
+	none := db.compactionInputsLocked(cf, "h", "w")
+	cf.sstables = saved
+	db.mu.Unlock()
+
+	if len(inputs) != 3 || inputs[0] != ac {
+		t.Fatalf("inputs for a..b: %d tables, want a..c and those chained to it", len(inputs))
+	}
+	for _, in := range inputs {
+		if in == xz {
+			t.Fatal("x..z compacted with a..b")
+		}
+	}
+	if len(none) != 0 {
+		t.Fatalf("%d inputs for a range no table overlaps", len(none))
+	}
+}
+
+func TestBottommostOnlyWithoutOverlappingTablesLeftOut(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.MinCompact = 100 })
+	defer db.Close()
+	table := func(smallest, largest string) *SSTable {
+		return &SSTable{smallest: smallest, largest: largest}
+	}
+	cf := db.DefaultColumnFamily()
+	ac, ce, xz := table("a", "c"), table("c", "e"), table("x", "z")
+	db.mu.Lock()
+	saved := cf.sstables
+	cf.sstables = []*SSTable{ac, xz, ce}
+	closed := db.bottommostLocked(cf, []*SSTable{ac, ce})
+	partial := db.bottommostLocked(cf, []*SSTable{ce})
+	cf.sstables = saved
+	db.mu.Unlock()
+
+	if !closed {
+		t.Fatal("a..e is not bottommost with only x..z left out")
+	}
+	if partial {
+		t.Fatal("c..e is bottommost with a..c left out")
+	}
+}
+
+func TestCompactionAboveOlderTablesKeepsTombstones(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.MinCompact = 100 })
+	defer db.Close()
+	db.Put("a", "1")
+	db.Flush()
+	db.Delete("a")
+	db.Flush()
+	cf := db.DefaultColumnFamily()
+	tables := familyTables(db, cf)
+	newest := tables[len(tables)-1]
+
+	for _, bottommost := range []bool{false, true} {
+		ctx := CompactionFilterContext{ColumnFamily: cf.name, Bottommost: bottommost}
+		outputs, _, err := db.subcompact(context.Background(), cf, []*SSTable{newest}, keyRange{}, ctx, time.Now(), blobCompaction{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := 0
+		for _, output := range outputs {
+			entries += output.entries
+			db.fs.Remove(output.path)
+		}
+		if bottommost && entries != 0 {
+			t.Fatalf("bottommost compaction kept %d entries of a deleted key", entries)
+		}
+		if !bottommost && entries != 1 {
+			t.Fatalf("compaction above an older table kept %d entries, want its tombstone", entries)
+		}
+	}
+	mustMiss(t, db, "a")
+}
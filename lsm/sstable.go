@@ -2,42 +2,55 @@ package lsm
 
 import (
 	"path/filepath"
-	"os"
-	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"strconv"
 	"strings"
 	"regexp"
 	"sort"
+
+	"distributedstore/vfs"
 )
 
 type SSTable struct {
 	path string
+	level int
+	size int64
+	// index locates the table's data blocks by their first key or, if it
+	// is partitioned, its index partitions.
 	index []IndexEntry
-	filter *BloomFilter
+	partitioned bool
+	filter *tableFilter
+	smallest string
+	largest string
+	// entries is the number of entries in the table.
+	entries int
+	// checksum is the CRC-32C of the whole file, recorded in the manifest
+	// so lsmctl verify can tell a damaged table.
+	checksum uint32
+	// cipher opens the table's blocks, each sealed on one line, if the
+	// table is encrypted.
+	cipher *fileCipher
+	// blobs counts the bytes the table references in each blob file, by
+	// id.
+	blobs map[int]int64
 }
 
-type IndexEntry struct {
-    key string
-    offset int64
-}
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
 
 type tableMeta struct {
 	id int
 	path string
 }
 
-func discoverSSTables(dir string) []tableMeta {
-	entries, _ := os.ReadDir(dir)
+func discoverSSTables(fs vfs.FS, dir string) []tableMeta {
+	names, _ := fs.List(dir)
 
 	re := regexp.MustCompile(`^sst-(\d+)\.sst$`)
 
 	var tables []tableMeta
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-
-		name := e.Name()
+	for _, name := range names {
 		m := re.FindStringSubmatch(name)
 		if m == nil {
 			continue
@@ -58,32 +71,187 @@ func discoverSSTables(dir string) []tableMeta {
 	return tables
 }
 
-func buildIndex(path string) ([]IndexEntry, *BloomFilter, int) {
-	file, _ := os.Open(path)
-	sc := bufio.NewScanner(file)
-	index := []IndexEntry{}
-	filter := NewBloomFilter(bloomM, bloomK)
-	var offset int64
+// tableGet looks key up in sstable. It fails if the block that would hold
+// the key cannot be read or decoded, rather than report the key absent and
+// let an older version show through.
+func (db *DB) tableGet(sstable *SSTable, key string) (string, Kind, bool, error) {
+	if !sstable.filter.mightContain(key) {
+		db.stats.bloomUseful.Add(1)
+		return "", KindPut, false, nil
+	}
+
+	var value string
+	var kind Kind
+	found := false
+	var readErr error
+	err := db.forEachBlock(sstable, func(k string) bool { return db.cmp.Compare(k, key) <= 0 }, func(block IndexEntry) bool {
+		b, err := db.readBlock(sstable, block)
+		if err != nil {
+			readErr = err
+			return false
+		}
+		prev := ""
+		for offset := b.seek(db.cmp, key); offset < len(b.data); {
+			e, next, err := b.entryAt(offset, prev)
+			if err != nil {
+				readErr = fmt.Errorf("lsm: %s: block at %d: %v", sstable.path, block.offset, err)
+				break
+			}
+			c := db.cmp.Compare(e.key, key)
+			if c > 0 {
+				break
+			}
+			if c == 0 {
+				value, kind, found = e.value, e.kind, true
+				break
+			}
+			offset, prev = next, e.key
+		}
+		return false
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		return "", KindPut, false, err
+	}
+	if found {
+		return value, kind, true, nil
+	}
+	db.stats.bloomFalsePositive.Add(1)
+	return "", KindPut, false, nil
+}
+
+// readBlock returns a data block of sstable, through the block cache. Only
+// blocks that were read whole are cached.
+func (db *DB) readBlock(sstable *SSTable, block IndexEntry) (*dataBlock, error) {
+	start, end := block.offset, block.offset+block.size
+	key := blockKey{path: sstable.path, offset: start}
+	if b, ok := db.cache.get(key); ok {
+		return b.(*dataBlock), nil
+	}
+
+	file, err := db.fs.Open(sstable.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := readTableBlock(file, sstable.path, sstable.cipher, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	b := parseBlock(data)
+	db.cache.put(key, b, end-start)
+	return b, nil
+}
+
+// buildIndex opens an SSTable from its footer or, if it has to, reads it to
+// build its bloom filters, and its index if the table does not hold one, and
+// returns it with the highest sequence number it holds. Only a table that is
+// read has its checksum computed. It fails only if the table is encrypted
+// with a key that is unavailable, or that none of its lines open with.
+func buildIndex(fs vfs.FS, keys KeyProvider, path string, filter *tableFilter) (*SSTable, int, error) {
+	if sstable, seq, ok := openTable(fs, keys, path, filter); ok {
+		return sstable, seq, nil
+	}
+	file, err := fs.Open(path)
+	if err != nil {
+		file = errFile{err}
+	}
+	defer file.Close()
+	crc := crc32.New(castagnoli)
+	sstable := &SSTable{path: path, index: []IndexEntry{}, filter: filter}
+	sc, err := newLineScanner(io.TeeReader(file, crc), keys)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	sstable.cipher = sc.cipher
 	seq := 0
 	i := 0
+	prev := ""
+	// A block ends at its restart line, or with the line it is sealed on
+	// if the table is encrypted. Tables from before prefix compression
+	// have no restart lines, so theirs are cut at defaultBlockSize.
+	newBlock := true
+	var blockOffset int64
+	var blocks, top []IndexEntry
+	dataEnd := int64(-1)
+	damaged, sealed := 0, 0
 
 	for sc.Scan() {
+		sealed++
+		if sc.Damaged() != nil {
+			damaged++
+			continue
+		}
 		line := sc.Text()
-		bytes := int64(len(sc.Bytes())) + 1
-		parts := strings.SplitN(line, " ", 4)
-		key := parts[2]
-		entrySeq, _ := strconv.Atoi(parts[1])
-		seq = entrySeq
+		if isRestartLine(line) {
+			newBlock = true
+			continue
+		}
+		if isIndexLine(line) || isMetaLine(line) {
+			if dataEnd < 0 {
+				dataEnd = sc.Offset()
+			}
+			if e, err := parseIndexLine(line); err == nil && line[0] == 'T' {
+				top = append(top, e)
+			}
+			continue
+		}
+		e, err := parseTableEntry(line, prev)
+		prev = e.key
+		if err != nil {
+			continue
+		}
+		seq = max(seq, e.seq)
 
-		filter.add(key)
+		filter.add(e.key)
+		if e.kind == KindBlobIndex {
+			if ref, err := parseBlobRef(e.value); err == nil {
+				sstable.addBlobRef(ref)
+			}
+		}
 
-		if i % stride == 0 {
-			entry := IndexEntry{key: key, offset: offset}
-			index = append(index, entry)
+		legacy := !strings.HasPrefix(line, "P ") && !strings.HasPrefix(line, "B ") && !strings.HasPrefix(line, "D ")
+		if newBlock || sstable.cipher != nil && sc.Offset() != blockOffset || sstable.cipher == nil && legacy && sc.Offset()-blockOffset >= defaultBlockSize {
+			blocks = append(blocks, IndexEntry{key: e.key, offset: sc.Offset()})
+			blockOffset = sc.Offset()
+			newBlock = false
+		}
+		if i == 0 {
+			sstable.smallest = e.key
 		}
-		offset += bytes
+		sstable.largest = e.key
 		i++
 	}
+	if sstable.cipher != nil && sealed > 0 && damaged == sealed {
+		return nil, 0, fmt.Errorf("lsm: %s: no line opens with key %s", path, sstable.cipher.id)
+	}
+	sstable.size = sc.End()
+	sstable.entries = i
+	if len(top) > 0 {
+		sstable.index, sstable.partitioned = top, true
+	} else {
+		if dataEnd < 0 {
+			dataEnd = sstable.size
+		}
+		for j := range blocks {
+			end := dataEnd
+			if j+1 < len(blocks) {
+				end = blocks[j+1].offset
+			}
+			blocks[j].size = end - blocks[j].offset
+		}
+		sstable.index = append(sstable.index, blocks...)
+	}
+	sstable.checksum = crc.Sum32()
+
+	return sstable, seq, nil
+}
 
-	return index, filter, seq
-}
\ No newline at end of file
+func (sstable *SSTable) addBlobRef(ref blobRef) {
+	if sstable.blobs == nil {
+		sstable.blobs = make(map[int]int64)
+	}
+	sstable.blobs[ref.file] += ref.size
+}
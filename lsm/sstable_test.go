@@ -0,0 +1,46 @@
+package lsm
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func TestUnreadableTableFailsReads(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, func(opts *Options) { opts.MinCompact = 100 })
+	defer db.Close()
+	db.Put("k", "old")
+	db.Put("k2", "old")
+	db.Flush()
+	db.Put("k", "new")
+	db.Put("k2", "new")
+	db.Flush()
+	tables := familyTables(db, db.DefaultColumnFamily())
+	newest := filepath.Base(tables[len(tables)-1].path)
+
+	// The newest version of k cannot be read; the older one must not show
+	// through in its place.
+	fault.FailOn(vfs.OpRead, newest)
+	if v, ok, err := db.Get("k"); err == nil {
+		t.Fatalf("Get(k) = %q, %v with its newest table unreadable", v, ok)
+	}
+	it := db.NewPrefixIterator("k")
+	for ; it.Valid(); it.Next() {
+		if it.Value() != "new" {
+			t.Fatalf("iterated %s=%s with its newest table unreadable", it.Key(), it.Value())
+		}
+	}
+	if it.Err() == nil {
+		t.Fatal("prefix iteration over an unreadable table ended without an error")
+	}
+	it.Close()
+
+	fault.SetInjector(nil)
+	mustGet(t, db, "k", "new")
+	if keys := prefixKeys(db, db.DefaultColumnFamily(), "k"); fmt.Sprint(keys) != "[k k2]" {
+		t.Fatalf("iterated %v once the table reads again", keys)
+	}
+}
@@ -0,0 +1,330 @@
+package lsm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"distributedstore/vfs"
+)
+
+var ErrKeyOrder = errors.New("lsm: keys must be added in strictly increasing order")
+
+// blockFormat is how a table's blocks and index partitions are cut, and its
+// keys prefix compressed.
+type blockFormat struct {
+	size int
+	restartInterval int
+	indexPartitionSize int
+}
+
+var defaultBlockFormat = blockFormat{size: defaultBlockSize, restartInterval: defaultBlockRestartInterval, indexPartitionSize: defaultIndexPartitionSize}
+
+func (cf *ColumnFamily) blockFormat() blockFormat {
+	return blockFormat{size: cf.opts.BlockSize, restartInterval: cf.opts.BlockRestartInterval, indexPartitionSize: cf.opts.IndexPartitionSize}
+}
+
+// indexPartition is an index partition held back until the data blocks are
+// all written.
+type indexPartition struct {
+	key string
+	data []byte
+	entries []IndexEntry
+}
+
+// tableWriter writes one SSTable to a temporary file, building its index
+// and bloom filters as it goes, and renames it into place on finish. Each
+// block is held back until it is complete, then written with its restart
+// line, sealed on one line if the table is encrypted. The index and meta
+// block follow the data blocks.
+type tableWriter struct {
+	fs vfs.FS
+	tmp string
+	table *SSTable
+	file vfs.File
+	writer *bufio.Writer
+	offset int64
+	count int
+	maxSeq int
+	limiter *RateLimiter
+	pri IOPriority
+	unpaid int64
+	format blockFormat
+	block []byte
+	restarts []int
+	blockCount int
+	blockKey string
+	blockOffset int64
+	prev string
+	partition indexPartition
+	partitions []indexPartition
+}
+
+// newTableWriter creates a table writer, encrypting with the current key of
+// keys if it is set.
+func newTableWriter(fs vfs.FS, keys KeyProvider, tmp string, target string, filter *tableFilter, format blockFormat) (*tableWriter, error) {
+	c, err := currentCipher(keys)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	w := &tableWriter{
+		fs: fs,
+		tmp: tmp,
+		table: &SSTable{path: target, index: []IndexEntry{}, filter: filter, cipher: c},
+		file: file,
+		writer: bufio.NewWriterSize(file, 64<<10),
+		format: format,
+	}
+	if c != nil {
+		w.write([]byte(c.header()))
+	}
+	return w, nil
+}
+
+// rateLimit makes the writer ask rl for its bytes, in chunks, before it
+// writes them.
+func (w *tableWriter) rateLimit(rl *RateLimiter, pri IOPriority) {
+	w.limiter = rl
+	w.pri = pri
+}
+
+func (w *tableWriter) add(seq int, kind Kind, key string, value string) {
+	if len(w.block) == 0 {
+		w.blockKey, w.blockOffset = key, w.offset
+	}
+	shared := 0
+	if w.blockCount % w.format.restartInterval == 0 {
+		w.restarts = append(w.restarts, len(w.block))
+	} else {
+		shared = sharedPrefix(w.prev, key)
+	}
+	var line string
+	switch kind {
+	case KindPut:
+		line = fmt.Sprintf("P %d %d %s %s\n", seq, shared, key[shared:], value)
+	case KindBlobIndex:
+		line = fmt.Sprintf("B %d %d %s %s\n", seq, shared, key[shared:], value)
+		if ref, err := parseBlobRef(value); err == nil {
+			w.table.addBlobRef(ref)
+		}
+	default:
+		line = fmt.Sprintf("D %d %d %s\n", seq, shared, key[shared:])
+	}
+	if w.limiter != nil {
+		w.unpaid += int64(len(line))
+		if w.unpaid >= rateLimitChunk {
+			w.limiter.Request(w.unpaid, w.pri)
+			w.unpaid = 0
+		}
+	}
+	if w.count == 0 {
+		w.table.smallest = key
+	}
+	w.table.largest = key
+
+	w.block = append(w.block, line...)
+	w.table.filter.add(key)
+	w.prev = key
+	w.blockCount++
+	w.count++
+	w.maxSeq = max(w.maxSeq, seq)
+	if len(w.block) >= w.format.size {
+		w.finishBlock()
+	}
+}
+
+// finishBlock writes out the block held back so far, if any, ending it with
+// its restart line.
+func (w *tableWriter) finishBlock() {
+	if len(w.block) == 0 {
+		return
+	}
+	w.block = append(w.block, 'R')
+	for _, offset := range w.restarts {
+		w.block = append(w.block, ' ')
+		w.block = strconv.AppendInt(w.block, int64(offset), 10)
+	}
+	w.block = append(w.block, '\n')
+	w.writeBlock(w.block)
+	w.block = w.block[:0]
+	w.restarts = w.restarts[:0]
+	w.blockCount = 0
+
+	if len(w.partition.data) == 0 {
+		w.partition.key = w.blockKey
+	}
+	e := IndexEntry{key: w.blockKey, offset: w.blockOffset, size: w.offset - w.blockOffset}
+	w.partition.data = appendIndexLine(w.partition.data, 'I', e)
+	w.partition.entries = append(w.partition.entries, e)
+	if len(w.partition.data) >= w.format.indexPartitionSize {
+		w.cutPartition()
+	}
+}
+
+func (w *tableWriter) cutPartition() {
+	if len(w.partition.data) > 0 {
+		w.partitions = append(w.partitions, w.partition)
+		w.partition = indexPartition{}
+	}
+}
+
+// writeIndex writes the index partitions, then the top-level index of them,
+// which becomes the table's index, and returns where that starts. An index
+// that fits in one partition is written flat instead.
+func (w *tableWriter) writeIndex() int64 {
+	w.cutPartition()
+	defer func() { w.partitions = nil }()
+	if len(w.partitions) <= 1 {
+		offset := w.offset
+		if len(w.partitions) == 1 {
+			w.writeBlock(w.partitions[0].data)
+			w.table.index = w.partitions[0].entries
+		}
+		return offset
+	}
+	var top []byte
+	for _, p := range w.partitions {
+		offset := w.offset
+		w.writeBlock(p.data)
+		e := IndexEntry{key: p.key, offset: offset, size: w.offset - offset}
+		w.table.index = append(w.table.index, e)
+		top = appendIndexLine(top, 'T', e)
+	}
+	offset := w.offset
+	w.writeBlock(top)
+	w.table.partitioned = true
+	return offset
+}
+
+// writeBlock writes out a block, sealed if the table is encrypted.
+func (w *tableWriter) writeBlock(b []byte) {
+	if w.table.cipher != nil {
+		w.write(w.table.cipher.seal(b, w.offset))
+	} else {
+		w.write(b)
+	}
+}
+
+func (w *tableWriter) write(p []byte) {
+	n, _ := w.writer.Write(p)
+	w.table.checksum = crc32.Update(w.table.checksum, castagnoli, p)
+	w.offset += int64(n)
+}
+
+func (w *tableWriter) finish() (*SSTable, error) {
+	w.limiter.Request(w.unpaid, w.pri)
+	w.finishBlock()
+	w.writeMeta(w.writeIndex())
+	if err := w.writer.Flush(); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	w.file.Close()
+	w.table.size = w.offset
+	w.table.entries = w.count
+	if err := w.fs.Rename(w.tmp, w.table.path); err != nil {
+		w.fs.Remove(w.tmp)
+		return nil, err
+	}
+	return w.table, nil
+}
+
+func (w *tableWriter) abandon() {
+	w.file.Close()
+	w.fs.Remove(w.tmp)
+}
+
+// SSTWriter builds an SSTable outside of any DB, for loading with
+// IngestExternalFiles. Keys must be added in strictly increasing order of the
+// comparator the target DB uses. Entries are written without sequence
+// numbers; those are assigned on ingestion.
+type SSTWriter struct {
+	w *tableWriter
+	cmp Comparator
+	last string
+	err error
+}
+
+// SSTWriterOptions should match the DB and column family the table is
+// ingested into. The table's blocks, index partitions and bloom filters are
+// cut and sized by the ColumnFamilyOptions, so a table linked in as it is
+// reads like one the family wrote itself; the other family options are
+// ignored. Zero fields take their defaults.
+type SSTWriterOptions struct {
+	// FS is the filesystem the table is written to. It defaults to
+	// vfs.Default, the operating system's.
+	FS vfs.FS
+	// Comparator orders the keys. It defaults to BytewiseComparator.
+	Comparator Comparator
+	ColumnFamilyOptions
+}
+
+func NewSSTWriter(path string, opts SSTWriterOptions) (*SSTWriter, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.Default
+	}
+	if opts.Comparator == nil {
+		opts.Comparator = BytewiseComparator
+	}
+	cf := &ColumnFamily{opts: opts.ColumnFamilyOptions.sanitize()}
+	w, err := newTableWriter(opts.FS, nil, path+".tmp", path, cf.newFilter(), cf.blockFormat())
+	if err != nil {
+		return nil, err
+	}
+	return &SSTWriter{w: w, cmp: opts.Comparator}, nil
+}
+
+func (sw *SSTWriter) Put(key string, value string) error {
+	if strings.ContainsRune(value, '\n') {
+		return fmt.Errorf("lsm: value for key %q contains a newline", key)
+	}
+	return sw.add(KindPut, key, value)
+}
+
+func (sw *SSTWriter) Delete(key string) error {
+	return sw.add(KindDelete, key, "")
+}
+
+func (sw *SSTWriter) add(kind Kind, key string, value string) error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if key == "" || strings.ContainsAny(key, " \n") {
+		return fmt.Errorf("lsm: invalid key %q", key)
+	}
+	if sw.w.count > 0 && sw.cmp.Compare(sw.last, key) >= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrKeyOrder, key, sw.last)
+	}
+	sw.w.add(0, kind, key, value)
+	sw.last = key
+	return nil
+}
+
+// Finish syncs the file and moves it into place. Abandon discards it instead.
+func (sw *SSTWriter) Finish() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	sw.err = errors.New("lsm: sst writer already finished")
+	_, err := sw.w.finish()
+	return err
+}
+
+func (sw *SSTWriter) Abandon() {
+	if sw.err != nil {
+		return
+	}
+	sw.err = errors.New("lsm: sst writer already finished")
+	sw.w.abandon()
+}
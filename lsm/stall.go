@@ -0,0 +1,150 @@
+package lsm
+
+import (
+	"time"
+)
+
+type WriteStallCondition int
+
+const (
+	WriteStallNormal WriteStallCondition = iota
+	// WriteStallDelayed writes are slowed down, more so the closer the DB is
+	// to stopping.
+	WriteStallDelayed
+	// WriteStallStopped writes block until flushes or compactions catch up.
+	WriteStallStopped
+)
+
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	}
+	return "normal"
+}
+
+const (
+	stallCauseMemtables = "too many immutable memtables"
+	stallCauseL0 = "too many level-0 files"
+	stallCausePendingBytes = "too many pending compaction bytes"
+	minWriteDelay = 100 * time.Microsecond
+)
+
+type writeStall struct {
+	condition WriteStallCondition
+	cause string
+	// severity is how far a delayed DB is from stopping, in (0, 1].
+	severity float64
+}
+
+// writeStallLocked works out the worst stall condition over every column
+// family.
+func (db *DB) writeStallLocked() writeStall {
+	var worst writeStall
+	// Counts step by one, so the first delayed value already has a
+	// severity; byte counts scale linearly from the soft limit.
+	consider := func(value, slowdown, stop, step float64, cause string) {
+		s := writeStall{cause: cause}
+		switch {
+		case value >= stop:
+			s.condition = WriteStallStopped
+		case value >= slowdown:
+			s.condition = WriteStallDelayed
+			s.severity = (value - slowdown + step) / (stop - slowdown + step)
+		default:
+			return
+		}
+		if s.condition > worst.condition || s.condition == worst.condition && s.severity > worst.severity {
+			worst = s
+		}
+	}
+
+	for _, cf := range db.families {
+		l0 := 0
+		for _, sstable := range cf.sstables {
+			if sstable.level == 0 {
+				l0++
+			}
+		}
+		pending := db.pendingCompactionBytesLocked(cf)
+		consider(float64(len(cf.imm)), float64(cf.opts.ImmutableMemtableSlowdown), float64(cf.opts.ImmutableMemtableStop), 1, stallCauseMemtables)
+		consider(float64(l0), float64(cf.opts.L0SlowdownFiles), float64(cf.opts.L0StopFiles), 1, stallCauseL0)
+		consider(float64(pending), float64(cf.opts.SoftPendingCompactionBytes), float64(cf.opts.HardPendingCompactionBytes), 0, stallCausePendingBytes)
+	}
+	return worst
+}
+
+// refreshWriteStall recomputes the stall condition, wakes stopped writers if
+// it changed and tells listeners about stalls that began or ended. It runs
+// whenever what the condition depends on changes: after a memtable is
+// frozen and after every flush, compaction and ingestion. Writes only read
+// the result.
+func (db *DB) refreshWriteStall() writeStall {
+	db.stallMu.Lock()
+	db.mu.RLock()
+	s := db.writeStallLocked()
+	db.mu.RUnlock()
+	prev := db.stall
+	db.stall = s
+	if prev.condition != s.condition {
+		db.stallCond.Broadcast()
+	}
+	db.stallMu.Unlock()
+
+	if prev.condition != s.condition || prev.cause != s.cause {
+		if prev.condition != WriteStallNormal {
+			info := WriteStallInfo{Cause: prev.cause, Condition: prev.condition}
+			db.notify(func(l EventListener) { l.OnWriteStallEnd(info) })
+		}
+		if s.condition != WriteStallNormal {
+			info := WriteStallInfo{Cause: s.cause, Condition: s.condition}
+			db.notify(func(l EventListener) { l.OnWriteStallBegin(info) })
+		}
+	}
+	return s
+}
+
+// throttleWrite delays or blocks a write according to the stall condition.
+func (db *DB) throttleWrite() {
+	s := db.writeStall()
+	if s.condition == WriteStallNormal {
+		return
+	}
+
+	start := time.Now()
+	if s.condition == WriteStallStopped {
+		db.stats.stoppedWrites.Add(1)
+		db.stallMu.Lock()
+		for db.stall.condition == WriteStallStopped {
+			db.mu.RLock()
+			closed := db.closed
+			db.mu.RUnlock()
+			if closed {
+				break
+			}
+			db.stallCond.Wait()
+		}
+		s = db.stall
+		db.stallMu.Unlock()
+	}
+	if s.condition == WriteStallDelayed {
+		db.stats.delayedWrites.Add(1)
+		time.Sleep(max(time.Duration(float64(db.maxWriteDelay)*s.severity), minWriteDelay))
+	}
+	db.stats.writeStallNanos.Add(int64(time.Since(start)))
+}
+
+// WriteStallCondition reports whether writes are currently delayed or
+// stopped. Servers use it to reject writes instead of blocking on them.
+func (db *DB) WriteStallCondition() WriteStallCondition {
+	return db.writeStall().condition
+}
+
+// writeStall returns the stall condition as last computed.
+func (db *DB) writeStall() writeStall {
+	db.stallMu.Lock()
+	defer db.stallMu.Unlock()
+	return db.stall
+}
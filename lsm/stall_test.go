@@ -0,0 +1,96 @@
+package lsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"distributedstore/vfs"
+)
+
+// openStallDB opens a DB that delays writes behind one immutable memtable
+// and stops them behind two, with flushes failing until the injector is
+// cleared.
+func openStallDB(t *testing.T) (*DB, *vfs.FaultFS) {
+	t.Helper()
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, func(opts *Options) {
+		opts.MaxWriteDelay = time.Millisecond
+		opts.ImmutableMemtableSlowdown = 1
+		opts.ImmutableMemtableStop = 2
+	})
+	fault.FailOn(vfs.OpCreate, "sst-*.tmp")
+	return db, fault
+}
+
+func TestWriteStallStopsWritesUntilFlushesCatchUp(t *testing.T) {
+	db, fault := openStallDB(t)
+	defer db.Close()
+
+	db.Put("a", "1")
+	db.Flush()
+	if c := db.WriteStallCondition(); c != WriteStallDelayed {
+		t.Fatalf("condition behind one failed flush = %s, want delayed", c)
+	}
+	db.Put("b", "2")
+	if s := db.Stats(); s.DelayedWrites != 1 {
+		t.Fatalf("delayed writes = %d, want 1", s.DelayedWrites)
+	}
+	db.Flush()
+	if v, _ := db.GetProperty("lsm.is-write-stopped"); v != "1" {
+		t.Fatalf("is-write-stopped = %q behind two failed flushes", v)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		db.Put("c", "3")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("write went through a stop")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	fault.SetInjector(nil)
+	db.Flush()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write still stopped after the flushes landed")
+	}
+	if c := db.WriteStallCondition(); c != WriteStallNormal {
+		t.Fatalf("condition = %s after the flushes landed", c)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		mustGet(t, db, key, want)
+	}
+	if s := db.Stats(); s.StoppedWrites != 1 || s.WriteStallTime <= 0 {
+		t.Fatalf("stopped writes %d, stall time %s", s.StoppedWrites, s.WriteStallTime)
+	}
+}
+
+func TestCloseReleasesStoppedWrites(t *testing.T) {
+	db, _ := openStallDB(t)
+	db.Put("a", "1")
+	db.Flush()
+	db.Put("b", "2")
+	db.Flush()
+
+	done := make(chan error, 1)
+	go func() {
+		batch := NewWriteBatch()
+		batch.Put("c", "3")
+		done <- db.Write(batch)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	db.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("stopped write returned %v after close, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close left a write stopped")
+	}
+}
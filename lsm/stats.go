@@ -0,0 +1,384 @@
+package lsm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type dbStats struct {
+	gets atomic.Int64
+	getHits atomic.Int64
+	memtableHits atomic.Int64
+	puts atomic.Int64
+	deletes atomic.Int64
+	writes atomic.Int64
+	userBytesWritten atomic.Int64
+	walBytesWritten atomic.Int64
+	flushes atomic.Int64
+	flushBytesWritten atomic.Int64
+	compactions atomic.Int64
+	compactionBytesRead atomic.Int64
+	compactionBytesWritten atomic.Int64
+	compactionFilterRemoved atomic.Int64
+	compactionFilterChanged atomic.Int64
+	blobBytesWritten atomic.Int64
+	ingestedFiles atomic.Int64
+	bloomUseful atomic.Int64
+	bloomFalsePositive atomic.Int64
+	prefixFilterUseful atomic.Int64
+	txnCommits atomic.Int64
+	txnRollbacks atomic.Int64
+	txnDeadlocks atomic.Int64
+	txnLockTimeouts atomic.Int64
+	delayedWrites atomic.Int64
+	stoppedWrites atomic.Int64
+	writeStallNanos atomic.Int64
+}
+
+type LevelStats struct {
+	Level int
+	NumFiles int
+	Bytes int64
+}
+
+type Stats struct {
+	// Levels covers every column family; L0 holds flushed tables, L1 the
+	// output of compactions.
+	Levels []LevelStats
+
+	MemtableEntries int
+	MemtableBytes int64
+	ImmutableMemtables int
+	ImmutableEntries int
+	ImmutableBytes int64
+
+	PendingFlushes int
+	// PendingCompactions counts column families due for compaction, and
+	// PendingCompactionBytes the size of the tables they would rewrite.
+	PendingCompactions int
+	PendingCompactionBytes int64
+	RunningCompactions int
+
+	// BlobFiles and BlobFileBytes cover the blob files holding separated
+	// values, of which BlobLiveBytes are still referenced.
+	BlobFiles int
+	BlobFileBytes int64
+	BlobLiveBytes int64
+
+	UserBytesWritten int64
+	WALBytesWritten int64
+	FlushBytesWritten int64
+	CompactionBytesRead int64
+	CompactionBytesWritten int64
+	// CompactionFilterRemoved and CompactionFilterChanged count the keys a
+	// compaction filter dropped and rewrote.
+	CompactionFilterRemoved int64
+	CompactionFilterChanged int64
+	// BlobBytesWritten counts the bytes flushes and compactions wrote to
+	// blob files.
+	BlobBytesWritten int64
+	// WriteAmplification is the bytes written to SSTables by flushes and
+	// compactions per byte flushed.
+	WriteAmplification float64
+
+	// BloomUseful counts table lookups the bloom filter ruled out, and
+	// BloomFalsePositive lookups it let through for a key the table lacked.
+	BloomUseful int64
+	BloomFalsePositive int64
+	// PrefixFilterUseful counts tables prefix iterators skipped because
+	// their prefix filter ruled the prefix out.
+	PrefixFilterUseful int64
+
+	BlockCacheHits int64
+	BlockCacheMisses int64
+	BlockCacheHitRate float64
+	BlockCacheUsage int64
+	// TableIndexBytes and TableFilterBytes estimate the memory open tables
+	// keep for their top-level indexes and bloom filters, and
+	// BlockCacheIndexUsage the part of BlockCacheUsage index partitions
+	// take.
+	TableIndexBytes int64
+	TableFilterBytes int64
+	BlockCacheIndexUsage int64
+
+	Gets int64
+	GetHits int64
+	MemtableHits int64
+	Puts int64
+	Deletes int64
+	Writes int64
+	Flushes int64
+	Compactions int64
+	IngestedFiles int64
+	TxnCommits int64
+	TxnRollbacks int64
+	TxnDeadlocks int64
+	TxnLockTimeouts int64
+
+	WriteStallCondition WriteStallCondition
+	DelayedWrites int64
+	StoppedWrites int64
+	WriteStallTime time.Duration
+}
+
+func (db *DB) Stats() Stats {
+	var s Stats
+	db.mu.RLock()
+	for _, cf := range db.families {
+		s.add(db.familyStatsLocked(cf))
+	}
+	db.mu.RUnlock()
+
+	db.flushMu.Lock()
+	s.PendingFlushes = db.pendingFlushes
+	db.flushMu.Unlock()
+
+	s.UserBytesWritten = db.stats.userBytesWritten.Load()
+	s.WALBytesWritten = db.stats.walBytesWritten.Load()
+	s.FlushBytesWritten = db.stats.flushBytesWritten.Load()
+	s.CompactionBytesRead = db.stats.compactionBytesRead.Load()
+	s.CompactionBytesWritten = db.stats.compactionBytesWritten.Load()
+	s.CompactionFilterRemoved = db.stats.compactionFilterRemoved.Load()
+	s.CompactionFilterChanged = db.stats.compactionFilterChanged.Load()
+	s.BlobBytesWritten = db.stats.blobBytesWritten.Load()
+	if s.FlushBytesWritten > 0 {
+		s.WriteAmplification = float64(s.FlushBytesWritten+s.CompactionBytesWritten) / float64(s.FlushBytesWritten)
+	}
+
+	s.BloomUseful = db.stats.bloomUseful.Load()
+	s.BloomFalsePositive = db.stats.bloomFalsePositive.Load()
+	s.PrefixFilterUseful = db.stats.prefixFilterUseful.Load()
+
+	s.BlockCacheHits = db.cache.hits.Load()
+	s.BlockCacheMisses = db.cache.misses.Load()
+	if lookups := s.BlockCacheHits + s.BlockCacheMisses; lookups > 0 {
+		s.BlockCacheHitRate = float64(s.BlockCacheHits) / float64(lookups)
+	}
+	s.BlockCacheUsage = db.cache.Usage()
+	s.BlockCacheIndexUsage = db.cache.IndexUsage()
+
+	s.Gets = db.stats.gets.Load()
+	s.GetHits = db.stats.getHits.Load()
+	s.MemtableHits = db.stats.memtableHits.Load()
+	s.Puts = db.stats.puts.Load()
+	s.Deletes = db.stats.deletes.Load()
+	s.Writes = db.stats.writes.Load()
+	s.Flushes = db.stats.flushes.Load()
+	s.Compactions = db.stats.compactions.Load()
+	s.IngestedFiles = db.stats.ingestedFiles.Load()
+	s.TxnCommits = db.stats.txnCommits.Load()
+	s.TxnRollbacks = db.stats.txnRollbacks.Load()
+	s.TxnDeadlocks = db.stats.txnDeadlocks.Load()
+	s.TxnLockTimeouts = db.stats.txnLockTimeouts.Load()
+
+	db.stallMu.Lock()
+	s.WriteStallCondition = db.stall.condition
+	db.stallMu.Unlock()
+	s.DelayedWrites = db.stats.delayedWrites.Load()
+	s.StoppedWrites = db.stats.stoppedWrites.Load()
+	s.WriteStallTime = time.Duration(db.stats.writeStallNanos.Load())
+	return s
+}
+
+// familyStatsLocked fills in the parts of Stats that belong to one column
+// family: its levels, table readers, memtables and compaction backlog.
+func (db *DB) familyStatsLocked(cf *ColumnFamily) Stats {
+	var s Stats
+	for _, sstable := range cf.sstables {
+		for len(s.Levels) <= sstable.level {
+			s.Levels = append(s.Levels, LevelStats{Level: len(s.Levels)})
+		}
+		s.Levels[sstable.level].NumFiles++
+		s.Levels[sstable.level].Bytes += sstable.size
+		s.TableIndexBytes += sstable.indexBytes()
+		s.TableFilterBytes += sstable.filter.bytes()
+	}
+	s.MemtableEntries = cf.memtable.Size()
+	s.MemtableBytes = cf.memtable.Bytes()
+	s.ImmutableMemtables = len(cf.imm)
+	for _, imm := range cf.imm {
+		s.ImmutableEntries += imm.Size()
+		s.ImmutableBytes += imm.Bytes()
+	}
+	s.BlobFiles = len(cf.blobs)
+	live := cf.blobLiveBytes()
+	for id, blob := range cf.blobs {
+		s.BlobFileBytes += blob.size
+		s.BlobLiveBytes += live[id]
+	}
+	if cf.compacting {
+		s.RunningCompactions = 1
+	}
+	if cf.compactionDue() {
+		s.PendingCompactions = 1
+		s.PendingCompactionBytes = db.pendingCompactionBytesLocked(cf)
+	}
+	return s
+}
+
+func (s *Stats) add(o Stats) {
+	for _, level := range o.Levels {
+		for len(s.Levels) <= level.Level {
+			s.Levels = append(s.Levels, LevelStats{Level: len(s.Levels)})
+		}
+		s.Levels[level.Level].NumFiles += level.NumFiles
+		s.Levels[level.Level].Bytes += level.Bytes
+	}
+	s.MemtableEntries += o.MemtableEntries
+	s.MemtableBytes += o.MemtableBytes
+	s.ImmutableMemtables += o.ImmutableMemtables
+	s.ImmutableEntries += o.ImmutableEntries
+	s.ImmutableBytes += o.ImmutableBytes
+	s.PendingCompactions += o.PendingCompactions
+	s.PendingCompactionBytes += o.PendingCompactionBytes
+	s.RunningCompactions += o.RunningCompactions
+	s.TableIndexBytes += o.TableIndexBytes
+	s.TableFilterBytes += o.TableFilterBytes
+	s.BlobFiles += o.BlobFiles
+	s.BlobFileBytes += o.BlobFileBytes
+	s.BlobLiveBytes += o.BlobLiveBytes
+}
+
+func (s Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Level  Files  Bytes\n")
+	for _, level := range s.Levels {
+		fmt.Fprintf(&b, "L%-5d %-6d %d\n", level.Level, level.NumFiles, level.Bytes)
+	}
+	fmt.Fprintf(&b, "memtable: %d entries, %d bytes\n", s.MemtableEntries, s.MemtableBytes)
+	fmt.Fprintf(&b, "immutable memtables: %d, %d entries, %d bytes\n", s.ImmutableMemtables, s.ImmutableEntries, s.ImmutableBytes)
+	fmt.Fprintf(&b, "pending flushes: %d, pending compactions: %d (%d bytes), running compactions: %d\n", s.PendingFlushes, s.PendingCompactions, s.PendingCompactionBytes, s.RunningCompactions)
+	fmt.Fprintf(&b, "bytes written: user %d, wal %d, flush %d, compaction %d (read %d), write amplification %.2f\n",
+		s.UserBytesWritten, s.WALBytesWritten, s.FlushBytesWritten, s.CompactionBytesWritten, s.CompactionBytesRead, s.WriteAmplification)
+	fmt.Fprintf(&b, "blob files: %d, %d bytes, %d live, %d bytes written\n", s.BlobFiles, s.BlobFileBytes, s.BlobLiveBytes, s.BlobBytesWritten)
+	fmt.Fprintf(&b, "compaction filter: %d removed, %d changed\n", s.CompactionFilterRemoved, s.CompactionFilterChanged)
+	fmt.Fprintf(&b, "bloom: %d useful, %d false positive, prefix %d useful\n", s.BloomUseful, s.BloomFalsePositive, s.PrefixFilterUseful)
+	fmt.Fprintf(&b, "block cache: %d hits, %d misses, hit rate %.3f, usage %d bytes (%d index)\n", s.BlockCacheHits, s.BlockCacheMisses, s.BlockCacheHitRate, s.BlockCacheUsage, s.BlockCacheIndexUsage)
+	fmt.Fprintf(&b, "table readers: %d index bytes, %d filter bytes\n", s.TableIndexBytes, s.TableFilterBytes)
+	fmt.Fprintf(&b, "ops: %d gets (%d hits, %d from memtables), %d puts, %d deletes, %d batches\n", s.Gets, s.GetHits, s.MemtableHits, s.Puts, s.Deletes, s.Writes)
+	fmt.Fprintf(&b, "background: %d flushes, %d compactions, %d ingested files\n", s.Flushes, s.Compactions, s.IngestedFiles)
+	fmt.Fprintf(&b, "txns: %d commits, %d rollbacks, %d deadlocks, %d lock timeouts\n", s.TxnCommits, s.TxnRollbacks, s.TxnDeadlocks, s.TxnLockTimeouts)
+	fmt.Fprintf(&b, "write stall: %s, %d delayed, %d stopped, %s stalled\n", s.WriteStallCondition, s.DelayedWrites, s.StoppedWrites, s.WriteStallTime)
+	return b.String()
+}
+
+const propertyPrefix = "lsm."
+
+// GetProperty returns a named property of the DB, or of the default column
+// family for per-family properties:
+//
+//	lsm.stats                                   Stats formatted as text
+//	lsm.num-files-at-level<N>                   tables at level N
+//	lsm.bytes-at-level<N>                       bytes at level N
+//	lsm.num-entries-active-mem-table
+//	lsm.cur-size-active-mem-table               bytes in the active memtable
+//	lsm.num-immutable-mem-table
+//	lsm.cur-size-all-mem-tables                 bytes in all memtables
+//	lsm.num-live-sst-files
+//	lsm.total-sst-files-size
+//	lsm.estimate-table-readers-mem              bytes of table indexes and filters
+//	lsm.num-blob-files
+//	lsm.total-blob-file-size
+//	lsm.live-blob-file-size                     bytes still referenced
+//	lsm.num-pending-flushes
+//	lsm.compaction-pending                      1 if a compaction is due
+//	lsm.estimate-pending-compaction-bytes
+//	lsm.num-running-compactions
+//	lsm.block-cache-usage
+//	lsm.column-families                         comma separated names
+//	lsm.comparator
+//	lsm.write-stall-condition                   normal, delayed or stopped
+//	lsm.is-write-stopped                        1 if writes are stopped
+func (db *DB) GetProperty(name string) (string, bool) {
+	return db.GetPropertyCF(db.DefaultColumnFamily(), name)
+}
+
+func (db *DB) GetPropertyCF(cf *ColumnFamily, name string) (string, bool) {
+	if !strings.HasPrefix(name, propertyPrefix) {
+		return "", false
+	}
+	prop := strings.TrimPrefix(name, propertyPrefix)
+
+	switch prop {
+	case "stats":
+		return db.Stats().String(), true
+	case "num-pending-flushes":
+		db.flushMu.Lock()
+		defer db.flushMu.Unlock()
+		return strconv.Itoa(db.pendingFlushes), true
+	case "block-cache-usage":
+		return strconv.FormatInt(db.cache.Usage(), 10), true
+	case "column-families":
+		return strings.Join(db.ColumnFamilies(), ","), true
+	case "comparator":
+		return db.cmp.Name(), true
+	case "write-stall-condition":
+		return db.WriteStallCondition().String(), true
+	case "is-write-stopped":
+		if db.WriteStallCondition() == WriteStallStopped {
+			return "1", true
+		}
+		return "0", true
+	}
+
+	db.mu.RLock()
+	s := db.familyStatsLocked(cf)
+	db.mu.RUnlock()
+
+	for _, p := range []string{"num-files-at-level", "bytes-at-level"} {
+		if !strings.HasPrefix(prop, p) {
+			continue
+		}
+		level, err := strconv.Atoi(strings.TrimPrefix(prop, p))
+		if err != nil || level < 0 {
+			return "", false
+		}
+		var ls LevelStats
+		if level < len(s.Levels) {
+			ls = s.Levels[level]
+		}
+		if p == "num-files-at-level" {
+			return strconv.Itoa(ls.NumFiles), true
+		}
+		return strconv.FormatInt(ls.Bytes, 10), true
+	}
+
+	switch prop {
+	case "num-entries-active-mem-table":
+		return strconv.Itoa(s.MemtableEntries), true
+	case "cur-size-active-mem-table":
+		return strconv.FormatInt(s.MemtableBytes, 10), true
+	case "num-immutable-mem-table":
+		return strconv.Itoa(s.ImmutableMemtables), true
+	case "cur-size-all-mem-tables":
+		return strconv.FormatInt(s.MemtableBytes+s.ImmutableBytes, 10), true
+	case "num-live-sst-files", "total-sst-files-size":
+		files, bytes := 0, int64(0)
+		for _, level := range s.Levels {
+			files += level.NumFiles
+			bytes += level.Bytes
+		}
+		if prop == "num-live-sst-files" {
+			return strconv.Itoa(files), true
+		}
+		return strconv.FormatInt(bytes, 10), true
+	case "estimate-table-readers-mem":
+		return strconv.FormatInt(s.TableIndexBytes+s.TableFilterBytes, 10), true
+	case "num-blob-files":
+		return strconv.Itoa(s.BlobFiles), true
+	case "total-blob-file-size":
+		return strconv.FormatInt(s.BlobFileBytes, 10), true
+	case "live-blob-file-size":
+		return strconv.FormatInt(s.BlobLiveBytes, 10), true
+	case "compaction-pending":
+		return strconv.Itoa(s.PendingCompactions), true
+	case "estimate-pending-compaction-bytes":
+		return strconv.FormatInt(s.PendingCompactionBytes, 10), true
+	case "num-running-compactions":
+		return strconv.Itoa(s.RunningCompactions), true
+	}
+	return "", false
+}
@@ -0,0 +1,71 @@
+package lsm
+
+import "testing"
+
+func TestStatsCountOperations(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	db.Put("a", "1")
+	db.Put("b", "2")
+	db.Delete("b")
+	db.Get("a")
+	db.Get("b")
+	db.Get("missing")
+
+	s := db.Stats()
+	if s.Puts != 2 || s.Deletes != 1 || s.Gets != 3 || s.GetHits != 1 {
+		t.Fatalf("puts %d deletes %d gets %d hits %d; want 2 1 3 1", s.Puts, s.Deletes, s.Gets, s.GetHits)
+	}
+	if s.MemtableEntries != 3 || s.UserBytesWritten == 0 || s.WALBytesWritten == 0 {
+		t.Fatalf("memtable entries %d, user bytes %d, wal bytes %d", s.MemtableEntries, s.UserBytesWritten, s.WALBytesWritten)
+	}
+
+	db.Flush()
+	s = db.Stats()
+	if s.Flushes != 1 || s.MemtableEntries != 0 || s.FlushBytesWritten == 0 {
+		t.Fatalf("after flush: flushes %d, memtable entries %d, flushed bytes %d", s.Flushes, s.MemtableEntries, s.FlushBytesWritten)
+	}
+	if len(s.Levels) == 0 || s.Levels[0].NumFiles != 1 || s.Levels[0].Bytes == 0 {
+		t.Fatalf("levels after flush: %+v", s.Levels)
+	}
+	if s.String() == "" {
+		t.Fatal("empty stats dump")
+	}
+}
+
+func TestGetPropertyPerFamily(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	other, err := db.CreateColumnFamily("other", DefaultColumnFamilyOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("a", "1")
+	db.Flush()
+	db.PutCF(other, "a", "1")
+	db.PutCF(other, "b", "1")
+
+	for _, c := range []struct {
+		cf *ColumnFamily
+		name string
+		want string
+	}{
+		{db.DefaultColumnFamily(), "lsm.num-files-at-level0", "1"},
+		{db.DefaultColumnFamily(), "lsm.num-live-sst-files", "1"},
+		{db.DefaultColumnFamily(), "lsm.num-entries-active-mem-table", "0"},
+		{other, "lsm.num-files-at-level0", "0"},
+		{other, "lsm.num-entries-active-mem-table", "2"},
+		{other, "lsm.column-families", "default,other"},
+		{other, "lsm.comparator", BytewiseComparator.Name()},
+		{other, "lsm.is-write-stopped", "0"},
+	} {
+		if v, ok := db.GetPropertyCF(c.cf, c.name); !ok || v != c.want {
+			t.Errorf("%s of %s = %q, %v; want %q", c.name, c.cf.Name(), v, ok, c.want)
+		}
+	}
+	for _, name := range []string{"num-files-at-level0", "lsm.no-such-property", "lsm.num-files-at-level-1", "lsm.bytes-at-levelx"} {
+		if v, ok := db.GetProperty(name); ok {
+			t.Errorf("GetProperty(%q) = %q, want unknown", name, v)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTxnDone = errors.New("lsm: transaction already committed or rolled back")
+	ErrNoSavepoint = errors.New("lsm: no savepoint set")
+)
+
+const defaultLockTimeout = time.Second
+
+type TxnOptions struct {
+	// LockTimeout bounds how long a single lock request waits. Zero means the
+	// default, a negative value means wait forever.
+	LockTimeout time.Duration
+}
+
+// Txn is a pessimistic transaction. Every key it reads is share-locked and
+// every key it writes is exclusively locked until Commit or Rollback; writes
+// are buffered and applied to the DB as a single batch on Commit.
+type Txn struct {
+	db *DB
+	id uint64
+	timeout time.Duration
+	batch *WriteBatch
+	locked map[string]LockMode
+	order []string
+	savepoints []int
+	done bool
+}
+
+func (db *DB) BeginTxn(opts TxnOptions) *Txn {
+	timeout := opts.LockTimeout
+	if timeout == 0 {
+		timeout = defaultLockTimeout
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	return &Txn{
+		db: db,
+		id: db.nextTxnId.Add(1),
+		timeout: timeout,
+		batch: NewWriteBatch(),
+		locked: make(map[string]LockMode),
+	}
+}
+
+func (txn *Txn) lock(cf *ColumnFamily, key string, mode LockMode) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	lockKey := fmt.Sprintf("%d/%s", cf.id, key)
+	if held, ok := txn.locked[lockKey]; ok && held >= mode {
+		return nil
+	}
+	err := txn.db.locks.acquire(txn.id, lockKey, mode, txn.timeout)
+	if err == ErrDeadlock {
+		txn.db.stats.txnDeadlocks.Add(1)
+		txn.Rollback()
+		return err
+	}
+	if err == ErrLockTimeout {
+		txn.db.stats.txnLockTimeouts.Add(1)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := txn.locked[lockKey]; !ok {
+		txn.order = append(txn.order, lockKey)
+	}
+	txn.locked[lockKey] = mode
+	return nil
+}
+
+func (txn *Txn) Get(key string) (string, bool, error) {
+	return txn.get(txn.db.DefaultColumnFamily(), key, LockShared)
+}
+
+func (txn *Txn) GetCF(cf *ColumnFamily, key string) (string, bool, error) {
+	return txn.get(cf, key, LockShared)
+}
+
+// GetForUpdate reads key under an exclusive lock, for read-modify-write
+// sequences that would otherwise deadlock upgrading a shared lock.
+func (txn *Txn) GetForUpdate(key string) (string, bool, error) {
+	return txn.get(txn.db.DefaultColumnFamily(), key, LockExclusive)
+}
+
+func (txn *Txn) GetForUpdateCF(cf *ColumnFamily, key string) (string, bool, error) {
+	return txn.get(cf, key, LockExclusive)
+}
+
+func (txn *Txn) get(cf *ColumnFamily, key string, mode LockMode) (string, bool, error) {
+	if err := txn.lock(cf, key, mode); err != nil {
+		return "", false, err
+	}
+	for i := len(txn.batch.ops) - 1; i >= 0; i-- {
+		op := txn.batch.ops[i]
+		if op.key != key || op.family(txn.db) != cf {
+			continue
+		}
+		if op.kind == KindDelete {
+			return "", false, nil
+		}
+		return op.value, true, nil
+	}
+	return txn.db.GetCF(cf, key)
+}
+
+func (txn *Txn) Put(key string, value string) error {
+	return txn.PutCF(txn.db.DefaultColumnFamily(), key, value)
+}
+
+func (txn *Txn) PutCF(cf *ColumnFamily, key string, value string) error {
+	if err := txn.lock(cf, key, LockExclusive); err != nil {
+		return err
+	}
+	txn.batch.PutCF(cf, key, value)
+	return nil
+}
+
+func (txn *Txn) Delete(key string) error {
+	return txn.DeleteCF(txn.db.DefaultColumnFamily(), key)
+}
+
+func (txn *Txn) DeleteCF(cf *ColumnFamily, key string) error {
+	if err := txn.lock(cf, key, LockExclusive); err != nil {
+		return err
+	}
+	txn.batch.DeleteCF(cf, key)
+	return nil
+}
+
+// SetSavepoint marks the current set of buffered writes. Locks taken after a
+// savepoint are kept when rolling back to it.
+func (txn *Txn) SetSavepoint() {
+	txn.savepoints = append(txn.savepoints, len(txn.batch.ops))
+}
+
+func (txn *Txn) RollbackToSavepoint() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if len(txn.savepoints) == 0 {
+		return ErrNoSavepoint
+	}
+	n := txn.savepoints[len(txn.savepoints)-1]
+	txn.savepoints = txn.savepoints[:len(txn.savepoints)-1]
+	txn.batch.ops = txn.batch.ops[:n]
+	return nil
+}
+
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := txn.db.Write(txn.batch); err != nil {
+		txn.Rollback()
+		return err
+	}
+	txn.db.stats.txnCommits.Add(1)
+	txn.finish()
+	return nil
+}
+
+func (txn *Txn) Rollback() {
+	if txn.done {
+		return
+	}
+	txn.batch.Clear()
+	txn.db.stats.txnRollbacks.Add(1)
+	txn.finish()
+}
+
+func (txn *Txn) finish() {
+	txn.done = true
+	txn.db.locks.releaseAll(txn.id, txn.order)
+}
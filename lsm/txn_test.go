@@ -0,0 +1,207 @@
+package lsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"distributedstore/vfs"
+)
+
+func TestTxnCommitAppliesBufferedWrites(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+	db.Put("gone", "x")
+
+	txn := db.BeginTxn(TxnOptions{})
+	txn.Put("a", "1")
+	txn.Delete("gone")
+	txn.SetSavepoint()
+	txn.Put("b", "2")
+	if err := txn.RollbackToSavepoint(); err != nil {
+		t.Fatalf("rollback to savepoint: %v", err)
+	}
+	if v, ok, err := txn.Get("a"); err != nil || !ok || v != "1" {
+		t.Fatalf("txn.Get(a) = %q, %v, %v; want its own write", v, ok, err)
+	}
+	if _, ok, _ := txn.Get("b"); ok {
+		t.Fatal("write rolled back to the savepoint is still visible")
+	}
+	mustMiss(t, db, "a")
+	mustGet(t, db, "gone", "x")
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	mustGet(t, db, "a", "1")
+	mustMiss(t, db, "b")
+	mustMiss(t, db, "gone")
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("second commit: %v, want ErrTxnDone", err)
+	}
+}
+
+func TestTxnRollbackDiscardsWritesAndReleasesLocks(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+
+	txn := db.BeginTxn(TxnOptions{})
+	txn.Put("a", "1")
+	if err := txn.RollbackToSavepoint(); !errors.Is(err, ErrNoSavepoint) {
+		t.Fatalf("rollback without savepoint: %v, want ErrNoSavepoint", err)
+	}
+	txn.Rollback()
+	mustMiss(t, db, "a")
+	if err := txn.Put("b", "2"); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("put after rollback: %v, want ErrTxnDone", err)
+	}
+
+	other := db.BeginTxn(TxnOptions{LockTimeout: 10 * time.Millisecond})
+	if err := other.Put("a", "2"); err != nil {
+		t.Fatalf("lock released by rollback: %v", err)
+	}
+	other.Rollback()
+}
+
+func TestTxnLockTimeout(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+
+	holder := db.BeginTxn(TxnOptions{})
+	if _, _, err := holder.GetForUpdate("k"); err != nil {
+		t.Fatalf("get for update: %v", err)
+	}
+	waiter := db.BeginTxn(TxnOptions{LockTimeout: 10 * time.Millisecond})
+	if _, _, err := waiter.Get("k"); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("read of exclusively locked key: %v, want ErrLockTimeout", err)
+	}
+	holder.Rollback()
+	waiter.Rollback()
+}
+
+func TestTxnDeadlockAbortsOneSide(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+
+	t1 := db.BeginTxn(TxnOptions{LockTimeout: -1})
+	t2 := db.BeginTxn(TxnOptions{LockTimeout: -1})
+	t1.Put("a", "1")
+	t2.Put("b", "2")
+
+	done := make(chan error, 1)
+	go func() { done <- t1.Put("b", "1") }()
+	// Wait for t1 to block on b before t2 closes the cycle.
+	waitUntilBlocked(db, t1)
+	if err := t2.Put("a", "2"); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("closing the cycle: %v, want ErrDeadlock", err)
+	}
+	t2.Rollback()
+	if err := <-done; err != nil {
+		t.Fatalf("survivor: %v", err)
+	}
+	if err := t1.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	mustGet(t, db, "a", "1")
+	mustGet(t, db, "b", "1")
+}
+
+func TestTxnDeadlockThroughQueuedSharedLocker(t *testing.T) {
+	db := openTestDB(t, nil)
+	defer db.Close()
+
+	t1 := db.BeginTxn(TxnOptions{LockTimeout: -1})
+	t2 := db.BeginTxn(TxnOptions{LockTimeout: -1})
+	t3 := db.BeginTxn(TxnOptions{LockTimeout: -1})
+	if _, _, err := t1.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	t3.Put("b", "3")
+
+	// t2 waits for a behind t1, and t3, asking for a shared lock on a, has
+	// to queue behind t2 rather than join t1.
+	writer := make(chan error, 1)
+	go func() { writer <- t2.Put("a", "2") }()
+	waitUntilBlocked(db, t2)
+	reader := make(chan error, 1)
+	go func() {
+		_, _, err := t3.Get("a")
+		reader <- err
+	}()
+	waitUntilBlocked(db, t3)
+
+	// t1 -> t3 -> t2 -> t1.
+	if err := t1.Put("b", "1"); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("closing the cycle through the queued reader: %v, want ErrDeadlock", err)
+	}
+	if err := <-writer; err != nil {
+		t.Fatalf("exclusive waiter: %v", err)
+	}
+	t2.Commit()
+	if err := <-reader; err != nil {
+		t.Fatalf("queued reader: %v", err)
+	}
+	t3.Commit()
+	mustGet(t, db, "a", "2")
+	mustGet(t, db, "b", "3")
+}
+
+// waitUntilBlocked waits for txn to block on a lock.
+func waitUntilBlocked(db *DB, txn *Txn) {
+	for {
+		db.locks.mu.Lock()
+		_, waiting := db.locks.waiting[txn.id]
+		db.locks.mu.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTxnCommitAfterCloseFails(t *testing.T) {
+	db := openTestDB(t, nil)
+	txn := db.BeginTxn(TxnOptions{})
+	txn.Put("a", "1")
+	db.Close()
+	if err := txn.Commit(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("commit after close: %v, want ErrClosed", err)
+	}
+	if err := db.BeginTxn(TxnOptions{}).Put("b", "2"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("lock after close: %v, want ErrClosed", err)
+	}
+	if err := db.Put("c", "3"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("put after close: %v, want ErrClosed", err)
+	}
+	if err := db.Delete("c"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("delete after close: %v, want ErrClosed", err)
+	}
+}
+
+func TestFailedWALSyncIsNotAcknowledged(t *testing.T) {
+	fault := vfs.NewFaultFS(vfs.NewMemFS())
+	db := openTestDBOn(t, fault, nil)
+	if err := db.Put("a", "1"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	fault.FailOn(vfs.OpSync, "wal-*.log")
+	if err := db.Put("b", "2"); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("put with a failing WAL sync: %v", err)
+	}
+	txn := db.BeginTxn(TxnOptions{})
+	txn.Put("c", "3")
+	if err := txn.Commit(); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("commit with a failing WAL sync: %v", err)
+	}
+	mustMiss(t, db, "b")
+	mustMiss(t, db, "c")
+
+	// Whatever a failed sync left behind may not be durable, so the WAL
+	// keeps failing writes even once syncs succeed again.
+	fault.SetInjector(nil)
+	if err := db.Put("d", "4"); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("put after a failed sync: %v", err)
+	}
+	mustGet(t, db, "a", "1")
+	db.Close()
+}
@@ -3,77 +3,263 @@ package lsm
 import (
 	"bufio"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 	"path/filepath"
 	"sort"
 	"regexp"
+
+	"distributedstore/vfs"
 )
 
 type WAL struct {
-	file *os.File
+	file vfs.File
 	writer *bufio.Writer
 	path string
+	size int64
+	// cipher seals each record on its own line, if the WAL is encrypted.
+	cipher *fileCipher
+	// err is the first write or sync that failed. Nothing written after it
+	// can be trusted to be durable, so every later Sync fails with it.
+	err error
 }
 
 func OpenWAL(path string) *WAL {
-	file, _ := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	return &WAL{file: file, writer: bufio.NewWriterSize(file, 64<<10), path: path}
+	return openWAL(vfs.Default, nil, path)
+}
+
+// openWAL opens a new WAL for appending, encrypted with the current key of
+// keys if it is set. If that fails, the WAL's writes fail with the error
+// instead.
+func openWAL(fs vfs.FS, keys KeyProvider, path string) *WAL {
+	c, err := currentCipher(keys)
+	var file vfs.File = errFile{err}
+	if err == nil {
+		file, err = fs.OpenAppend(path)
+		if err != nil {
+			file = errFile{err}
+		}
+	}
+	wal := &WAL{file: file, writer: bufio.NewWriterSize(file, 64<<10), path: path, cipher: c}
+	if c != nil {
+		n, _ := wal.writer.WriteString(c.header())
+		wal.size += int64(n)
+	}
+	return wal
 }
 
+// errFile stands in for a file that could not be opened, failing every
+// operation with the error that prevented it.
+type errFile struct {
+	err error
+}
+
+func (f errFile) Read([]byte) (int, error) { return 0, f.err }
+func (f errFile) ReadAt([]byte, int64) (int, error) { return 0, f.err }
+func (f errFile) Write([]byte) (int, error) { return 0, f.err }
+func (f errFile) Seek(int64, int) (int64, error) { return 0, f.err }
+func (f errFile) Sync() error { return f.err }
+func (f errFile) Close() error { return f.err }
+
 func (wal *WAL) Close() {
 	wal.writer.Flush()
 	wal.file.Close()
 }
 
-func (wal *WAL) Sync() {
-	wal.writer.Flush()
-	wal.file.Sync()
+// Sync makes the records written so far durable, or reports why they may
+// not be.
+func (wal *WAL) Sync() error {
+	if wal.err != nil {
+		return wal.err
+	}
+	if err := wal.writer.Flush(); err != nil {
+		wal.err = err
+	} else if err := wal.file.Sync(); err != nil {
+		wal.err = err
+	}
+	return wal.err
 }
 
-func (wal *WAL) WriteDel(seq int, key string) {
-	fmt.Fprintf(wal.writer, "DEL %d %s\n", seq, key)
+// Records for the default column family keep the original "PUT"/"DEL" form;
+// records for other families carry the family id as "PUTCF"/"DELCF".
+func (wal *WAL) WriteDel(cf int, seq int, key string) {
+	if cf == 0 {
+		wal.writeRecord(fmt.Sprintf("DEL %d %s\n", seq, key))
+		return
+	}
+	wal.writeRecord(fmt.Sprintf("DELCF %d %d %s\n", cf, seq, key))
+}
+
+func (wal *WAL) WritePut(cf int, seq int, key string, value string) {
+	if cf == 0 {
+		wal.writeRecord(fmt.Sprintf("PUT %d %s %s\n", seq, key, value))
+		return
+	}
+	wal.writeRecord(fmt.Sprintf("PUTCF %d %d %s %s\n", cf, seq, key, value))
+}
+
+func (wal *WAL) WriteBatchHeader(seq int, count int) {
+	wal.writeRecord(fmt.Sprintf("BATCH %d %d\n", seq, count))
 }
 
-func (wal *WAL) WritePut(seq int, key string, value string) {
-	fmt.Fprintf(wal.writer, "PUT %d %s %s\n", seq, key, value)
+func (wal *WAL) writeRecord(line string) {
+	if wal.err != nil {
+		return
+	}
+	var n int
+	var err error
+	if wal.cipher != nil {
+		n, err = wal.writer.Write(wal.cipher.seal([]byte(line), wal.size))
+	} else {
+		n, err = wal.writer.WriteString(line)
+	}
+	wal.size += int64(n)
+	if err != nil {
+		wal.err = err
+	}
 }
 
-func ReplayWAL(path string, onPut func(int, string, string), onDel func(int, string)) int {
-	file, _ := os.Open(path)
+// walRecord is one parsed WAL line. A batch header has count set to the
+// number of records that follow it.
+type walRecord struct {
+	line int
+	count int
+	cf int
+	seq int
+	kind Kind
+	key string
+	value string
+}
+
+func parseWALRecord(line string) (walRecord, error) {
+	var r walRecord
+	op, rest, _ := strings.Cut(line, " ")
+	if op == "PUTCF" || op == "DELCF" {
+		id, tail, _ := strings.Cut(rest, " ")
+		cf, err := strconv.Atoi(id)
+		if err != nil || cf <= 0 {
+			return r, fmt.Errorf("bad column family %q", id)
+		}
+		r.cf = cf
+		op, rest = op[:3], tail
+	}
+	parts := strings.SplitN(rest, " ", 3)
+	seq, err := strconv.Atoi(parts[0])
+	if err != nil || seq < 0 {
+		return r, fmt.Errorf("bad sequence number %q", parts[0])
+	}
+	r.seq = seq
+	switch {
+	case op == "BATCH" && len(parts) == 2:
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count <= 0 {
+			return r, fmt.Errorf("bad batch count %q", parts[1])
+		}
+		r.count = count
+	case op == "PUT" && len(parts) == 3 && parts[1] != "":
+		r.kind, r.key, r.value = KindPut, parts[1], parts[2]
+	case op == "DEL" && len(parts) == 2 && parts[1] != "":
+		r.kind, r.key = KindDelete, parts[1]
+	default:
+		return r, fmt.Errorf("malformed record")
+	}
+	return r, nil
+}
+
+// scanWAL reads a WAL and hands fn each record that replay applies: single
+// records, and batches once all of their records have been read back, so a
+// crash in the middle of writing one leaves none of it visible. Lines that
+// do not parse, and batches cut short by another one or holding such a line,
+// are passed to bad with their line number and skipped. A batch cut short by
+// the end of the file is dropped silently and reported as torn. Records that
+// do not decrypt count as lines that do not parse.
+func scanWAL(fs vfs.FS, keys KeyProvider, path string, fn func(header *walRecord, records []walRecord), bad func(line int, err error)) (torn bool, err error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return false, err
+	}
 	defer file.Close()
-	maxSeq := 0
-	scanner := bufio.NewScanner(file)
+	scanner, err := newLineScanner(file, keys)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var header *walRecord
+	var pending []walRecord
+	headerLine := 0
+	remaining := 0
+	broken := false
 	for scanner.Scan() {
+		n := scanner.Line()
 		line := strings.TrimSpace(scanner.Text())
-		parts := strings.SplitN(line, " ", 4)
-		switch parts[0] {
-		case "PUT":
-			seq, _ := strconv.Atoi(parts[1])
-			key := parts[2]
-			maxSeq = max(maxSeq, seq)
-			onPut(seq, key, parts[3])
-		case "DEL":
-			seq, _ := strconv.Atoi(parts[1])
-			key := parts[2]
-			maxSeq = max(maxSeq, seq)
-			onDel(seq, key)
+		if line == "" && scanner.Damaged() == nil {
+			continue
+		}
+		r, err := parseWALRecord(line)
+		if scanner.Damaged() != nil {
+			err = scanner.Damaged()
+		}
+		r.line = n
+		if err != nil {
+			bad(n, err)
+			if remaining > 0 {
+				broken = true
+				if remaining--; remaining == 0 {
+					header, pending = nil, nil
+				}
+			}
+			continue
+		}
+		if r.count > 0 {
+			if remaining > 0 && !broken {
+				bad(headerLine, fmt.Errorf("batch of %d records has only %d", header.count, len(pending)))
+			}
+			header, pending, headerLine, remaining, broken = &r, nil, n, r.count, false
+			continue
+		}
+		if remaining == 0 {
+			fn(nil, []walRecord{r})
+			continue
+		}
+		pending = append(pending, r)
+		if remaining--; remaining == 0 {
+			if !broken {
+				fn(header, pending)
+			}
+			header, pending = nil, nil
 		}
 	}
+	return remaining > 0, scanner.Err()
+}
+
+func ReplayWAL(path string, onPut func(int, int, string, string), onDel func(int, int, string)) int {
+	maxSeq, _ := replayWAL(vfs.Default, nil, path, onPut, onDel)
 	return maxSeq
 }
 
-func discoverWALs(dir string) []tableMeta {
-    entries, _ := os.ReadDir(dir)
+// replayWAL applies the records of a WAL. It fails only if the WAL cannot be
+// read at all, such as when its key is unavailable.
+func replayWAL(fs vfs.FS, keys KeyProvider, path string, onPut func(int, int, string, string), onDel func(int, int, string)) (int, error) {
+	maxSeq := 0
+	_, err := scanWAL(fs, keys, path, func(_ *walRecord, records []walRecord) {
+		for _, r := range records {
+			maxSeq = max(maxSeq, r.seq)
+			if r.kind == KindPut {
+				onPut(r.cf, r.seq, r.key, r.value)
+			} else {
+				onDel(r.cf, r.seq, r.key)
+			}
+		}
+	}, func(int, error) {})
+	return maxSeq, err
+}
+
+func discoverWALs(fs vfs.FS, dir string) []tableMeta {
+    names, _ := fs.List(dir)
     re := regexp.MustCompile(`^wal-(\d+)\.log$`)
 
     var wals []tableMeta
-    for _, e := range entries {
-        if e.IsDir() {
-            continue
-        }
-        name := e.Name()
+    for _, name := range names {
         m := re.FindStringSubmatch(name)
         if m == nil {
             continue
@@ -84,6 +84,7 @@ func (x *KeyValue) GetTimestamp() int64 {
 type PutRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Kv            *KeyValue              `protobuf:"bytes,1,opt,name=kv,proto3" json:"kv,omitempty"`
+	Family        string                 `protobuf:"bytes,2,opt,name=family,proto3" json:"family,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -125,6 +126,13 @@ func (x *PutRequest) GetKv() *KeyValue {
 	return nil
 }
 
+func (x *PutRequest) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
 type PutResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -172,6 +180,7 @@ func (x *PutResponse) GetSuccess() bool {
 type GetRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Family        string                 `protobuf:"bytes,2,opt,name=family,proto3" json:"family,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -213,6 +222,13 @@ func (x *GetRequest) GetKey() []byte {
 	return nil
 }
 
+func (x *GetRequest) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
 type GetResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Kv            *KeyValue              `protobuf:"bytes,1,opt,name=kv,proto3" json:"kv,omitempty"`
@@ -260,6 +276,7 @@ func (x *GetResponse) GetKv() *KeyValue {
 type DeleteRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Family        string                 `protobuf:"bytes,2,opt,name=family,proto3" json:"family,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -301,6 +318,13 @@ func (x *DeleteRequest) GetKey() []byte {
 	return nil
 }
 
+func (x *DeleteRequest) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
 type DeleteResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -345,6 +369,289 @@ func (x *DeleteResponse) GetSuccess() bool {
 	return false
 }
 
+type FlushRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushRequest) Reset() {
+	*x = FlushRequest{}
+	mi := &file_proto_lsm_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushRequest) ProtoMessage() {}
+
+func (x *FlushRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushRequest.ProtoReflect.Descriptor instead.
+func (*FlushRequest) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{7}
+}
+
+type FlushResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushResponse) Reset() {
+	*x = FlushResponse{}
+	mi := &file_proto_lsm_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushResponse) ProtoMessage() {}
+
+func (x *FlushResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushResponse.ProtoReflect.Descriptor instead.
+func (*FlushResponse) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{8}
+}
+
+// An empty start or end leaves that side of the range unbounded.
+type CompactRangeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Family        string                 `protobuf:"bytes,1,opt,name=family,proto3" json:"family,omitempty"`
+	Start         []byte                 `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           []byte                 `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompactRangeRequest) Reset() {
+	*x = CompactRangeRequest{}
+	mi := &file_proto_lsm_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompactRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompactRangeRequest) ProtoMessage() {}
+
+func (x *CompactRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompactRangeRequest.ProtoReflect.Descriptor instead.
+func (*CompactRangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CompactRangeRequest) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
+func (x *CompactRangeRequest) GetStart() []byte {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *CompactRangeRequest) GetEnd() []byte {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+type CompactRangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompactRangeResponse) Reset() {
+	*x = CompactRangeResponse{}
+	mi := &file_proto_lsm_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompactRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompactRangeResponse) ProtoMessage() {}
+
+func (x *CompactRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompactRangeResponse.ProtoReflect.Descriptor instead.
+func (*CompactRangeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{10}
+}
+
+// Keys from start to end inclusive are estimated; an empty start or end
+// leaves that side of the range unbounded.
+type ApproximateRangeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Family        string                 `protobuf:"bytes,1,opt,name=family,proto3" json:"family,omitempty"`
+	Start         []byte                 `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           []byte                 `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApproximateRangeRequest) Reset() {
+	*x = ApproximateRangeRequest{}
+	mi := &file_proto_lsm_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApproximateRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApproximateRangeRequest) ProtoMessage() {}
+
+func (x *ApproximateRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApproximateRangeRequest.ProtoReflect.Descriptor instead.
+func (*ApproximateRangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ApproximateRangeRequest) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
+func (x *ApproximateRangeRequest) GetStart() []byte {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ApproximateRangeRequest) GetEnd() []byte {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+type ApproximateRangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Size          int64                  `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	Keys          int64                  `protobuf:"varint,2,opt,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApproximateRangeResponse) Reset() {
+	*x = ApproximateRangeResponse{}
+	mi := &file_proto_lsm_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApproximateRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApproximateRangeResponse) ProtoMessage() {}
+
+func (x *ApproximateRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_lsm_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApproximateRangeResponse.ProtoReflect.Descriptor instead.
+func (*ApproximateRangeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_lsm_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ApproximateRangeResponse) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ApproximateRangeResponse) GetKeys() int64 {
+	if x != nil {
+		return x.Keys
+	}
+	return 0
+}
+
 var File_proto_lsm_proto protoreflect.FileDescriptor
 
 const file_proto_lsm_proto_rawDesc = "" +
@@ -353,25 +660,46 @@ const file_proto_lsm_proto_rawDesc = "" +
 	"\bKeyValue\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\fR\x05value\x12\x1c\n" +
-	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\"8\n" +
+	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\"P\n" +
 	"\n" +
 	"PutRequest\x12*\n" +
-	"\x02kv\x18\x01 \x01(\v2\x1a.distributedstore.KeyValueR\x02kv\"'\n" +
+	"\x02kv\x18\x01 \x01(\v2\x1a.distributedstore.KeyValueR\x02kv\x12\x16\n" +
+	"\x06family\x18\x02 \x01(\tR\x06family\"'\n" +
 	"\vPutResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"\x1e\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"6\n" +
 	"\n" +
 	"GetRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\fR\x03key\"9\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x16\n" +
+	"\x06family\x18\x02 \x01(\tR\x06family\"9\n" +
 	"\vGetResponse\x12*\n" +
-	"\x02kv\x18\x01 \x01(\v2\x1a.distributedstore.KeyValueR\x02kv\"!\n" +
+	"\x02kv\x18\x01 \x01(\v2\x1a.distributedstore.KeyValueR\x02kv\"9\n" +
 	"\rDeleteRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\fR\x03key\"*\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x16\n" +
+	"\x06family\x18\x02 \x01(\tR\x06family\"*\n" +
 	"\x0eDeleteResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess2\xe2\x01\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"\x0e\n" +
+	"\fFlushRequest\"\x0f\n" +
+	"\rFlushResponse\"U\n" +
+	"\x13CompactRangeRequest\x12\x16\n" +
+	"\x06family\x18\x01 \x01(\tR\x06family\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\fR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\fR\x03end\"\x16\n" +
+	"\x14CompactRangeResponse\"Y\n" +
+	"\x17ApproximateRangeRequest\x12\x16\n" +
+	"\x06family\x18\x01 \x01(\tR\x06family\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\fR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\fR\x03end\"B\n" +
+	"\x18ApproximateRangeResponse\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x03R\x04size\x12\x12\n" +
+	"\x04keys\x18\x02 \x01(\x03R\x04keys2\xe2\x01\n" +
 	"\vNodeService\x12B\n" +
 	"\x03Put\x12\x1c.distributedstore.PutRequest\x1a\x1d.distributedstore.PutResponse\x12B\n" +
 	"\x03Get\x12\x1c.distributedstore.GetRequest\x1a\x1d.distributedstore.GetResponse\x12K\n" +
-	"\x06Delete\x12\x1f.distributedstore.DeleteRequest\x1a .distributedstore.DeleteResponseB\x1eZ\x1cdistributedstore/proto;protob\x06proto3"
+	"\x06Delete\x12\x1f.distributedstore.DeleteRequest\x1a .distributedstore.DeleteResponse2\xa2\x02\n" +
+	"\fAdminService\x12H\n" +
+	"\x05Flush\x12\x1e.distributedstore.FlushRequest\x1a\x1f.distributedstore.FlushResponse\x12]\n" +
+	"\fCompactRange\x12%.distributedstore.CompactRangeRequest\x1a&.distributedstore.CompactRangeResponse\x12i\n" +
+	"\x10ApproximateRange\x12).distributedstore.ApproximateRangeRequest\x1a*.distributedstore.ApproximateRangeResponseB\x1eZ\x1cdistributedstore/proto;protob\x06proto3"
 
 var (
 	file_proto_lsm_proto_rawDescOnce sync.Once
@@ -385,30 +713,42 @@ func file_proto_lsm_proto_rawDescGZIP() []byte {
 	return file_proto_lsm_proto_rawDescData
 }
 
-var file_proto_lsm_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_proto_lsm_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_proto_lsm_proto_goTypes = []any{
-	(*KeyValue)(nil),       // 0: distributedstore.KeyValue
-	(*PutRequest)(nil),     // 1: distributedstore.PutRequest
-	(*PutResponse)(nil),    // 2: distributedstore.PutResponse
-	(*GetRequest)(nil),     // 3: distributedstore.GetRequest
-	(*GetResponse)(nil),    // 4: distributedstore.GetResponse
-	(*DeleteRequest)(nil),  // 5: distributedstore.DeleteRequest
-	(*DeleteResponse)(nil), // 6: distributedstore.DeleteResponse
+	(*KeyValue)(nil),                 // 0: distributedstore.KeyValue
+	(*PutRequest)(nil),               // 1: distributedstore.PutRequest
+	(*PutResponse)(nil),              // 2: distributedstore.PutResponse
+	(*GetRequest)(nil),               // 3: distributedstore.GetRequest
+	(*GetResponse)(nil),              // 4: distributedstore.GetResponse
+	(*DeleteRequest)(nil),            // 5: distributedstore.DeleteRequest
+	(*DeleteResponse)(nil),           // 6: distributedstore.DeleteResponse
+	(*FlushRequest)(nil),             // 7: distributedstore.FlushRequest
+	(*FlushResponse)(nil),            // 8: distributedstore.FlushResponse
+	(*CompactRangeRequest)(nil),      // 9: distributedstore.CompactRangeRequest
+	(*CompactRangeResponse)(nil),     // 10: distributedstore.CompactRangeResponse
+	(*ApproximateRangeRequest)(nil),  // 11: distributedstore.ApproximateRangeRequest
+	(*ApproximateRangeResponse)(nil), // 12: distributedstore.ApproximateRangeResponse
 }
 var file_proto_lsm_proto_depIdxs = []int32{
-	0, // 0: distributedstore.PutRequest.kv:type_name -> distributedstore.KeyValue
-	0, // 1: distributedstore.GetResponse.kv:type_name -> distributedstore.KeyValue
-	1, // 2: distributedstore.NodeService.Put:input_type -> distributedstore.PutRequest
-	3, // 3: distributedstore.NodeService.Get:input_type -> distributedstore.GetRequest
-	5, // 4: distributedstore.NodeService.Delete:input_type -> distributedstore.DeleteRequest
-	2, // 5: distributedstore.NodeService.Put:output_type -> distributedstore.PutResponse
-	4, // 6: distributedstore.NodeService.Get:output_type -> distributedstore.GetResponse
-	6, // 7: distributedstore.NodeService.Delete:output_type -> distributedstore.DeleteResponse
-	5, // [5:8] is the sub-list for method output_type
-	2, // [2:5] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	0,  // 0: distributedstore.PutRequest.kv:type_name -> distributedstore.KeyValue
+	0,  // 1: distributedstore.GetResponse.kv:type_name -> distributedstore.KeyValue
+	1,  // 2: distributedstore.NodeService.Put:input_type -> distributedstore.PutRequest
+	3,  // 3: distributedstore.NodeService.Get:input_type -> distributedstore.GetRequest
+	5,  // 4: distributedstore.NodeService.Delete:input_type -> distributedstore.DeleteRequest
+	7,  // 5: distributedstore.AdminService.Flush:input_type -> distributedstore.FlushRequest
+	9,  // 6: distributedstore.AdminService.CompactRange:input_type -> distributedstore.CompactRangeRequest
+	11, // 7: distributedstore.AdminService.ApproximateRange:input_type -> distributedstore.ApproximateRangeRequest
+	2,  // 8: distributedstore.NodeService.Put:output_type -> distributedstore.PutResponse
+	4,  // 9: distributedstore.NodeService.Get:output_type -> distributedstore.GetResponse
+	6,  // 10: distributedstore.NodeService.Delete:output_type -> distributedstore.DeleteResponse
+	8,  // 11: distributedstore.AdminService.Flush:output_type -> distributedstore.FlushResponse
+	10, // 12: distributedstore.AdminService.CompactRange:output_type -> distributedstore.CompactRangeResponse
+	12, // 13: distributedstore.AdminService.ApproximateRange:output_type -> distributedstore.ApproximateRangeResponse
+	8,  // [8:14] is the sub-list for method output_type
+	2,  // [2:8] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_lsm_proto_init() }
@@ -422,9 +762,9 @@ func file_proto_lsm_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_lsm_proto_rawDesc), len(file_proto_lsm_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   13,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_proto_lsm_proto_goTypes,
 		DependencyIndexes: file_proto_lsm_proto_depIdxs,
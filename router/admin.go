@@ -0,0 +1,50 @@
+package router
+
+import (
+	"context"
+
+	"distributedstore/lsm"
+	"distributedstore/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer exposes maintenance operations of a node's DB.
+type AdminServer struct {
+	proto.UnimplementedAdminServiceServer
+	db *lsm.DB
+}
+
+func NewAdminServer(db *lsm.DB) *AdminServer {
+	return &AdminServer{db: db}
+}
+
+func (s *AdminServer) Flush(ctx context.Context, req *proto.FlushRequest) (*proto.FlushResponse, error) {
+	s.db.Flush()
+	return &proto.FlushResponse{}, nil
+}
+
+// CompactRange runs until the compaction is done; cancelling the RPC cancels
+// the compaction.
+func (s *AdminServer) CompactRange(ctx context.Context, req *proto.CompactRangeRequest) (*proto.CompactRangeResponse, error) {
+	cf, err := lookupFamily(s.db, req.Family)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.CompactRangeCF(ctx, cf, string(req.Start), string(req.End)); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &proto.CompactRangeResponse{}, nil
+}
+
+func (s *AdminServer) ApproximateRange(ctx context.Context, req *proto.ApproximateRangeRequest) (*proto.ApproximateRangeResponse, error) {
+	cf, err := lookupFamily(s.db, req.Family)
+	if err != nil {
+		return nil, err
+	}
+	start, end := string(req.Start), string(req.End)
+	return &proto.ApproximateRangeResponse{
+		Size: s.db.ApproximateSizeCF(cf, start, end),
+		Keys: s.db.ApproximateKeyCountCF(cf, start, end),
+	}, nil
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"distributedstore/lsm"
+	"distributedstore/proto"
+	"distributedstore/vfs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func openTestDB(t *testing.T) *lsm.DB {
+	t.Helper()
+	opts := lsm.DefaultOptions()
+	opts.FS = vfs.NewMemFS()
+	db, err := lsm.Open("/db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAdminFlushAndCompact(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+	s := NewAdminServer(db)
+	db.Put("a", "1")
+	if _, err := s.Flush(context.Background(), &proto.FlushRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.GetProperty("lsm.num-entries-active-mem-table"); v != "0" {
+		t.Fatalf("memtable entries after flush: %s", v)
+	}
+	db.Put("b", "1")
+	if _, err := s.CompactRange(context.Background(), &proto.CompactRangeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.GetProperty("lsm.num-files-at-level1"); v != "1" {
+		t.Fatalf("level-1 files after compaction: %s", v)
+	}
+}
+
+func TestAdminCompactRangeErrors(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+	s := NewAdminServer(db)
+
+	_, err := s.CompactRange(context.Background(), &proto.CompactRangeRequest{Family: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown family: %v, want NotFound", err)
+	}
+	db.Put("a", "1")
+	db.Flush()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.CompactRange(ctx, &proto.CompactRangeRequest{}); status.Code(err) != codes.Canceled {
+		t.Fatalf("cancelled compaction: %v, want Canceled", err)
+	}
+}
@@ -12,13 +12,16 @@ import (
 type NodeClient struct {
 	conn *grpc.ClientConn
 	client proto.NodeServiceClient
+	admin proto.AdminServiceClient
 }
 
-func NewNodeClient(addr string) *NodeClient {
-	conn, _ := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewNodeClient(addr string, opts ...grpc.DialOption) *NodeClient {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, _ := grpc.Dial(addr, opts...)
 	return &NodeClient{
 		conn: conn,
 		client: proto.NewNodeServiceClient(conn),
+		admin: proto.NewAdminServiceClient(conn),
 	}
 }
 
@@ -26,20 +29,49 @@ func (c *NodeClient) Close() {
 	c.conn.Close()
 }
 
-func (c *NodeClient) Put(ctx context.Context, key, value string) {
-	c.client.Put(ctx, &proto.PutRequest{
+func (c *NodeClient) Put(ctx context.Context, family, key, value string) error {
+	_, err := c.client.Put(ctx, &proto.PutRequest{
 		Kv: &proto.KeyValue{Key: []byte(key), Value: []byte(value)},
+		Family: family,
 	})
+	return err
 }
 
-func (c *NodeClient) Get(ctx context.Context, key string) (string, bool) {
-	resp, err := c.client.Get(ctx, &proto.GetRequest{Key: []byte(key)})
+func (c *NodeClient) Get(ctx context.Context, family, key string) (string, bool) {
+	resp, err := c.client.Get(ctx, &proto.GetRequest{Key: []byte(key), Family: family})
 	if err != nil || resp.Kv == nil {
 		return "", false
 	}
 	return string(resp.Kv.Value), true
 }
 
-func (c *NodeClient) Delete(ctx context.Context, key string) {
-	c.client.Delete(ctx, &proto.DeleteRequest{Key: []byte(key)})
+func (c *NodeClient) Delete(ctx context.Context, family, key string) error {
+	_, err := c.client.Delete(ctx, &proto.DeleteRequest{Key: []byte(key), Family: family})
+	return err
+}
+
+func (c *NodeClient) Flush(ctx context.Context) error {
+	_, err := c.admin.Flush(ctx, &proto.FlushRequest{})
+	return err
+}
+
+func (c *NodeClient) CompactRange(ctx context.Context, family, start, end string) error {
+	_, err := c.admin.CompactRange(ctx, &proto.CompactRangeRequest{
+		Family: family,
+		Start: []byte(start),
+		End: []byte(end),
+	})
+	return err
+}
+
+func (c *NodeClient) ApproximateRange(ctx context.Context, family, start, end string) (RangeEstimate, error) {
+	resp, err := c.admin.ApproximateRange(ctx, &proto.ApproximateRangeRequest{
+		Family: family,
+		Start: []byte(start),
+		End: []byte(end),
+	})
+	if err != nil {
+		return RangeEstimate{}, err
+	}
+	return RangeEstimate{Size: resp.Size, Keys: resp.Keys}, nil
 }
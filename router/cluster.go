@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"distributedstore/proto"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ClusterConfig struct {
@@ -18,6 +21,7 @@ type ClusterConfig struct {
 	BasePort int
 	HTTPPort int
 	DataDir string
+	Options lsm.Options
 }
 
 func DefaultConfig() ClusterConfig {
@@ -26,6 +30,7 @@ func DefaultConfig() ClusterConfig {
 		BasePort: 50051,
 		HTTPPort: 8080,
 		DataDir: "./data",
+		Options: lsm.DefaultOptions(),
 	}
 }
 
@@ -34,9 +39,11 @@ type Cluster struct {
 	nodes []*nodeInstance
 	router *Router
 	httpServer *http.Server
+	metrics *Metrics
 }
 
 type nodeInstance struct {
+	name string
 	db *lsm.DB
 	server *grpc.Server
 	listener net.Listener
@@ -47,11 +54,11 @@ type nodeInstance struct {
 func NewCluster(numNodes int) *Cluster {
 	config := DefaultConfig()
 	config.NumNodes = numNodes
-	return &Cluster{config: config}
+	return &Cluster{config: config, metrics: NewMetrics()}
 }
 
 func NewClusterWithConfig(config ClusterConfig) *Cluster {
-	return &Cluster{config: config}
+	return &Cluster{config: config, metrics: NewMetrics()}
 }
 
 func (c *Cluster) WithHTTPPort(port int) *Cluster {
@@ -69,18 +76,47 @@ func (c *Cluster) WithDataDir(dir string) *Cluster {
 	return c
 }
 
+// WithInMemory keeps every node's data in memory, leaving nothing under the
+// data directory, for hermetic tests and ephemeral caches.
+func (c *Cluster) WithInMemory() *Cluster {
+	c.config.Options.InMemory = true
+	return c
+}
+
+// WithRateLimiter makes every node share rl for flush and compaction writes,
+// so together they stay within its rate. Use RateLimiter().SetRate to change
+// it while the cluster runs.
+func (c *Cluster) WithRateLimiter(rl *lsm.RateLimiter) *Cluster {
+	c.config.Options.RateLimiter = rl
+	return c
+}
+
+func (c *Cluster) RateLimiter() *lsm.RateLimiter {
+	return c.config.Options.RateLimiter
+}
+
+func (c *Cluster) WithOptions(opts lsm.Options) *Cluster {
+	c.config.Options = opts
+	return c
+}
+
 func (c *Cluster) Open() error {
 	var nodeAddrs []string
 	for i := 0; i < c.config.NumNodes; i++ {
 		port := c.config.BasePort + i
-		dataDir := fmt.Sprintf("%s/node%d", c.config.DataDir, i+1)
+		name := nodeName(i)
+		dataDir := fmt.Sprintf("%s/%s", c.config.DataDir, name)
 
-		node := c.startNode(port, dataDir)
+		node, err := c.startNode(name, port, dataDir)
+		if err != nil {
+			c.Close()
+			return err
+		}
 		c.nodes = append(c.nodes, node)
 		nodeAddrs = append(nodeAddrs, fmt.Sprintf("127.0.0.1:%d", port))
 	}
 
-	c.router = NewRouter(nodeAddrs)
+	c.router = NewRouterWithMetrics(nodeAddrs, c.metrics)
 	c.startHTTPServer()
 
 	fmt.Printf("Cluster started: %d nodes on ports %d-%d, HTTP on :%d\n",
@@ -88,23 +124,28 @@ func (c *Cluster) Open() error {
 	return nil
 }
 
-func (c *Cluster) startNode(port int, dataDir string) *nodeInstance {
-	db := lsm.Open(dataDir)
+func (c *Cluster) startNode(name string, port int, dataDir string) (*nodeInstance, error) {
+	db, err := lsm.Open(dataDir, c.config.Options)
+	if err != nil {
+		return nil, err
+	}
 
 	listener, _ := net.Listen("tcp", fmt.Sprintf(":%d", port))
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(grpc.UnaryInterceptor(c.metrics.UnaryServerInterceptor(name)))
 	proto.RegisterNodeServiceServer(server, NewNodeServer(db))
+	proto.RegisterAdminServiceServer(server, NewAdminServer(db))
 
 	go server.Serve(listener)
 
 	return &nodeInstance{
+		name: name,
 		db: db,
 		server: server,
 		listener: listener,
 		port: port,
 		dataDir: dataDir,
-	}
+	}, nil
 }
 
 func (c *Cluster) startHTTPServer() {
@@ -113,13 +154,18 @@ func (c *Cluster) startHTTPServer() {
 	mux.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
 		value := r.URL.Query().Get("value")
-		c.router.Put(r.Context(), key, value)
+		family := r.URL.Query().Get("family")
+		if err := c.router.Put(r.Context(), family, key, value); err != nil {
+			writeRPCError(w, err)
+			return
+		}
 		fmt.Fprintln(w, "OK")
 	})
 
 	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
-		value, found := c.router.Get(r.Context(), key)
+		family := r.URL.Query().Get("family")
+		value, found := c.router.Get(r.Context(), family, key)
 		if !found {
 			http.NotFound(w, r)
 			return
@@ -129,10 +175,55 @@ func (c *Cluster) startHTTPServer() {
 
 	mux.HandleFunc("/delete", func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
-		c.router.Delete(r.Context(), key)
+		family := r.URL.Query().Get("family")
+		if err := c.router.Delete(r.Context(), family, key); err != nil {
+			writeRPCError(w, err)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+
+	// The admin endpoints act on the node named by the node parameter, such
+	// as node1, or on every node if it is absent, and return once done.
+	mux.HandleFunc("/admin/flush", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.router.Flush(r.Context(), r.URL.Query().Get("node")); err != nil {
+			writeRPCError(w, err)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+
+	mux.HandleFunc("/admin/compact", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		err := c.router.CompactRange(r.Context(), q.Get("node"), q.Get("family"), q.Get("start"), q.Get("end"))
+		if err != nil {
+			writeRPCError(w, err)
+			return
+		}
 		fmt.Fprintln(w, "OK")
 	})
 
+	mux.HandleFunc("/admin/approximate-size", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		estimate, err := c.router.ApproximateRange(r.Context(), q.Get("node"), q.Get("family"), q.Get("start"), q.Get("end"))
+		if err != nil {
+			writeRPCError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(estimate)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]lsm.Stats, len(c.nodes))
+		for _, node := range c.nodes {
+			stats[node.name] = node.db.Stats()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeStorageMetrics(w, stats)
+		c.metrics.WritePrometheus(w)
+	})
+
 	c.httpServer = &http.Server{
 		Addr: fmt.Sprintf(":%d", c.config.HTTPPort),
 		Handler: mux,
@@ -143,6 +234,19 @@ func (c *Cluster) startHTTPServer() {
 	time.Sleep(50 * time.Millisecond)
 }
 
+// writeRPCError answers an HTTP request whose node RPC failed. A stalled node
+// answers 429 so clients know to back off and retry.
+func writeRPCError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case codes.NotFound:
+		code = http.StatusNotFound
+	}
+	http.Error(w, status.Convert(err).Message(), code)
+}
+
 func (c *Cluster) Close() {
 	if c.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -163,18 +267,45 @@ func (c *Cluster) Close() {
 }
 
 func (c *Cluster) Put(key, value string) error {
-	c.router.Put(context.Background(), key, value)
-	return nil
+	return c.PutCF("", key, value)
+}
+
+func (c *Cluster) PutCF(family, key, value string) error {
+	return c.router.Put(context.Background(), family, key, value)
 }
 
 func (c *Cluster) Get(key string) (string, bool, error) {
-	val, found := c.router.Get(context.Background(), key)
+	return c.GetCF("", key)
+}
+
+func (c *Cluster) GetCF(family, key string) (string, bool, error) {
+	val, found := c.router.Get(context.Background(), family, key)
 	return val, found, nil
 }
 
 func (c *Cluster) Delete(key string) error {
-	c.router.Delete(context.Background(), key)
-	return nil
+	return c.DeleteCF("", key)
+}
+
+func (c *Cluster) DeleteCF(family, key string) error {
+	return c.router.Delete(context.Background(), family, key)
+}
+
+// Flush flushes the named node, or every node if node is empty.
+func (c *Cluster) Flush(ctx context.Context, node string) error {
+	return c.router.Flush(ctx, node)
+}
+
+// CompactRange compacts keys from start to end of a column family on the
+// named node, or on every node if node is empty.
+func (c *Cluster) CompactRange(ctx context.Context, node, family, start, end string) error {
+	return c.router.CompactRange(ctx, node, family, start, end)
+}
+
+// ApproximateRange estimates the keys from start to end of a column family on
+// the named node, or across the cluster if node is empty.
+func (c *Cluster) ApproximateRange(ctx context.Context, node, family, start, end string) (RangeEstimate, error) {
+	return c.router.ApproximateRange(ctx, node, family, start, end)
 }
 
 func (c *Cluster) NumNodes() int {
@@ -0,0 +1,298 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"distributedstore/lsm"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the RPC latency
+// histograms.
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type rpcKey struct {
+	side string
+	node string
+	method string
+}
+
+type rpcStats struct {
+	codes map[string]int64
+	buckets []int64
+	sum float64
+	count int64
+}
+
+type routeKey struct {
+	node string
+	op string
+}
+
+// Metrics collects RPC and routing metrics for a cluster and renders them,
+// together with each node's storage stats, in the Prometheus text format.
+type Metrics struct {
+	mu sync.Mutex
+	rpcs map[rpcKey]*rpcStats
+	routed map[routeKey]int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rpcs: make(map[rpcKey]*rpcStats),
+		routed: make(map[routeKey]int64),
+	}
+}
+
+func (m *Metrics) observe(key rpcKey, elapsed time.Duration, err error) {
+	code := status.Code(err).String()
+	seconds := elapsed.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.rpcs[key]
+	if s == nil {
+		s = &rpcStats{codes: make(map[string]int64), buckets: make([]int64, len(latencyBuckets))}
+		m.rpcs[key] = s
+	}
+	s.codes[code]++
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+		}
+	}
+	s.sum += seconds
+	s.count++
+}
+
+func (m *Metrics) routedTo(node string, op string) {
+	m.mu.Lock()
+	m.routed[routeKey{node: node, op: op}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) UnaryServerInterceptor(node string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(rpcKey{side: "server", node: node, method: methodName(info.FullMethod)}, time.Since(start), err)
+		return resp, err
+	}
+}
+
+func (m *Metrics) UnaryClientInterceptor(node string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.observe(rpcKey{side: "client", node: node, method: methodName(method)}, time.Since(start), err)
+		return err
+	}
+}
+
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+type promWriter struct {
+	w io.Writer
+}
+
+func (p promWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate between names and values.
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(p.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// WritePrometheus writes the RPC and routing metrics.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	p := promWriter{w: w}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]rpcKey, 0, len(m.rpcs))
+	for key := range m.rpcs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.node != b.node {
+			return a.node < b.node
+		}
+		return a.method < b.method
+	})
+
+	for _, side := range []string{"server", "client"} {
+		p.family("grpc_"+side+"_handled_total", "counter", "RPCs completed on the "+side+" side, by status code.")
+		for _, key := range keys {
+			if key.side != side {
+				continue
+			}
+			s := m.rpcs[key]
+			codes := make([]string, 0, len(s.codes))
+			for code := range s.codes {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+			for _, code := range codes {
+				p.sample("grpc_"+side+"_handled_total", float64(s.codes[code]), "node", key.node, "method", key.method, "code", code)
+			}
+		}
+
+		p.family("grpc_"+side+"_errors_total", "counter", "RPCs that failed on the "+side+" side.")
+		for _, key := range keys {
+			if key.side != side {
+				continue
+			}
+			s := m.rpcs[key]
+			p.sample("grpc_"+side+"_errors_total", float64(s.count-s.codes["OK"]), "node", key.node, "method", key.method)
+		}
+
+		name := "grpc_" + side + "_handling_seconds"
+		p.family(name, "histogram", "RPC latency on the "+side+" side.")
+		for _, key := range keys {
+			if key.side != side {
+				continue
+			}
+			s := m.rpcs[key]
+			for i, le := range latencyBuckets {
+				p.sample(name+"_bucket", float64(s.buckets[i]), "node", key.node, "method", key.method, "le", strconv.FormatFloat(le, 'g', -1, 64))
+			}
+			p.sample(name+"_bucket", float64(s.count), "node", key.node, "method", key.method, "le", "+Inf")
+			p.sample(name+"_sum", s.sum, "node", key.node, "method", key.method)
+			p.sample(name+"_count", float64(s.count), "node", key.node, "method", key.method)
+		}
+	}
+
+	routes := make([]routeKey, 0, len(m.routed))
+	for key := range m.routed {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].node != routes[j].node {
+			return routes[i].node < routes[j].node
+		}
+		return routes[i].op < routes[j].op
+	})
+	p.family("router_requests_total", "counter", "Requests the router sent to each node.")
+	for _, key := range routes {
+		p.sample("router_requests_total", float64(m.routed[key]), "node", key.node, "op", key.op)
+	}
+}
+
+// writeStorageMetrics writes the storage stats of every node, keyed by node
+// name.
+func writeStorageMetrics(w io.Writer, stats map[string]lsm.Stats) {
+	p := promWriter{w: w}
+	nodes := make([]string, 0, len(stats))
+	for node := range stats {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	p.family("lsm_level_files", "gauge", "SSTables per level.")
+	for _, node := range nodes {
+		for _, level := range stats[node].Levels {
+			p.sample("lsm_level_files", float64(level.NumFiles), "node", node, "level", strconv.Itoa(level.Level))
+		}
+	}
+	p.family("lsm_level_bytes", "gauge", "SSTable bytes per level.")
+	for _, node := range nodes {
+		for _, level := range stats[node].Levels {
+			p.sample("lsm_level_bytes", float64(level.Bytes), "node", node, "level", strconv.Itoa(level.Level))
+		}
+	}
+
+	metrics := []struct {
+		name string
+		kind string
+		help string
+		value func(s lsm.Stats) float64
+	}{
+		{"lsm_memtable_entries", "gauge", "Entries in the active memtables.", func(s lsm.Stats) float64 { return float64(s.MemtableEntries) }},
+		{"lsm_memtable_bytes", "gauge", "Bytes in the active memtables.", func(s lsm.Stats) float64 { return float64(s.MemtableBytes) }},
+		{"lsm_immutable_memtables", "gauge", "Memtables waiting to be flushed.", func(s lsm.Stats) float64 { return float64(s.ImmutableMemtables) }},
+		{"lsm_immutable_memtable_bytes", "gauge", "Bytes in memtables waiting to be flushed.", func(s lsm.Stats) float64 { return float64(s.ImmutableBytes) }},
+		{"lsm_pending_flushes", "gauge", "Flushes queued or running.", func(s lsm.Stats) float64 { return float64(s.PendingFlushes) }},
+		{"lsm_pending_compactions", "gauge", "Column families due for compaction.", func(s lsm.Stats) float64 { return float64(s.PendingCompactions) }},
+		{"lsm_running_compactions", "gauge", "Compactions running.", func(s lsm.Stats) float64 { return float64(s.RunningCompactions) }},
+		{"lsm_pending_compaction_bytes", "gauge", "Bytes the pending compactions would rewrite.", func(s lsm.Stats) float64 { return float64(s.PendingCompactionBytes) }},
+		{"lsm_user_bytes_written_total", "counter", "Key and value bytes written by clients.", func(s lsm.Stats) float64 { return float64(s.UserBytesWritten) }},
+		{"lsm_wal_bytes_written_total", "counter", "Bytes appended to the WAL.", func(s lsm.Stats) float64 { return float64(s.WALBytesWritten) }},
+		{"lsm_flush_bytes_written_total", "counter", "SSTable bytes written by flushes.", func(s lsm.Stats) float64 { return float64(s.FlushBytesWritten) }},
+		{"lsm_compaction_bytes_read_total", "counter", "SSTable bytes read by compactions.", func(s lsm.Stats) float64 { return float64(s.CompactionBytesRead) }},
+		{"lsm_compaction_bytes_written_total", "counter", "SSTable bytes written by compactions.", func(s lsm.Stats) float64 { return float64(s.CompactionBytesWritten) }},
+		{"lsm_compaction_filter_removed_total", "counter", "Keys dropped by a compaction filter.", func(s lsm.Stats) float64 { return float64(s.CompactionFilterRemoved) }},
+		{"lsm_compaction_filter_changed_total", "counter", "Values rewritten by a compaction filter.", func(s lsm.Stats) float64 { return float64(s.CompactionFilterChanged) }},
+		{"lsm_blob_files", "gauge", "Blob files holding separated values.", func(s lsm.Stats) float64 { return float64(s.BlobFiles) }},
+		{"lsm_blob_file_bytes", "gauge", "Bytes in blob files.", func(s lsm.Stats) float64 { return float64(s.BlobFileBytes) }},
+		{"lsm_blob_live_bytes", "gauge", "Blob file bytes still referenced by SSTables.", func(s lsm.Stats) float64 { return float64(s.BlobLiveBytes) }},
+		{"lsm_blob_bytes_written_total", "counter", "Bytes written to blob files by flushes and compactions.", func(s lsm.Stats) float64 { return float64(s.BlobBytesWritten) }},
+		{"lsm_write_amplification", "gauge", "Flush and compaction bytes per flushed byte.", func(s lsm.Stats) float64 { return s.WriteAmplification }},
+		{"lsm_bloom_useful_total", "counter", "Table lookups ruled out by a bloom filter.", func(s lsm.Stats) float64 { return float64(s.BloomUseful) }},
+		{"lsm_bloom_false_positive_total", "counter", "Table lookups a bloom filter let through for a missing key.", func(s lsm.Stats) float64 { return float64(s.BloomFalsePositive) }},
+		{"lsm_prefix_filter_useful_total", "counter", "Tables prefix iterators skipped by their prefix filter.", func(s lsm.Stats) float64 { return float64(s.PrefixFilterUseful) }},
+		{"lsm_block_cache_hits_total", "counter", "Block cache hits.", func(s lsm.Stats) float64 { return float64(s.BlockCacheHits) }},
+		{"lsm_block_cache_misses_total", "counter", "Block cache misses.", func(s lsm.Stats) float64 { return float64(s.BlockCacheMisses) }},
+		{"lsm_table_index_bytes", "gauge", "Memory held by table top-level indexes.", func(s lsm.Stats) float64 { return float64(s.TableIndexBytes) }},
+		{"lsm_table_filter_bytes", "gauge", "Memory held by table bloom filters.", func(s lsm.Stats) float64 { return float64(s.TableFilterBytes) }},
+		{"lsm_block_cache_usage_bytes", "gauge", "Bytes held by the block cache.", func(s lsm.Stats) float64 { return float64(s.BlockCacheUsage) }},
+		{"lsm_block_cache_index_bytes", "gauge", "Block cache bytes taken by index partitions.", func(s lsm.Stats) float64 { return float64(s.BlockCacheIndexUsage) }},
+		{"lsm_gets_total", "counter", "Point lookups.", func(s lsm.Stats) float64 { return float64(s.Gets) }},
+		{"lsm_get_hits_total", "counter", "Point lookups that found a value.", func(s lsm.Stats) float64 { return float64(s.GetHits) }},
+		{"lsm_puts_total", "counter", "Puts, including those in batches.", func(s lsm.Stats) float64 { return float64(s.Puts) }},
+		{"lsm_deletes_total", "counter", "Deletes, including those in batches.", func(s lsm.Stats) float64 { return float64(s.Deletes) }},
+		{"lsm_write_batches_total", "counter", "Write batches applied.", func(s lsm.Stats) float64 { return float64(s.Writes) }},
+		{"lsm_flushes_total", "counter", "Memtables flushed.", func(s lsm.Stats) float64 { return float64(s.Flushes) }},
+		{"lsm_compactions_total", "counter", "Compactions run.", func(s lsm.Stats) float64 { return float64(s.Compactions) }},
+		{"lsm_ingested_files_total", "counter", "External SSTables ingested.", func(s lsm.Stats) float64 { return float64(s.IngestedFiles) }},
+		{"lsm_txn_commits_total", "counter", "Transactions committed.", func(s lsm.Stats) float64 { return float64(s.TxnCommits) }},
+		{"lsm_txn_rollbacks_total", "counter", "Transactions rolled back.", func(s lsm.Stats) float64 { return float64(s.TxnRollbacks) }},
+		{"lsm_txn_deadlocks_total", "counter", "Transactions aborted by deadlock detection.", func(s lsm.Stats) float64 { return float64(s.TxnDeadlocks) }},
+		{"lsm_txn_lock_timeouts_total", "counter", "Lock requests that timed out.", func(s lsm.Stats) float64 { return float64(s.TxnLockTimeouts) }},
+		{"lsm_write_stall_condition", "gauge", "0 if writes are normal, 1 if delayed, 2 if stopped.", func(s lsm.Stats) float64 { return float64(s.WriteStallCondition) }},
+		{"lsm_delayed_writes_total", "counter", "Writes delayed by a write stall.", func(s lsm.Stats) float64 { return float64(s.DelayedWrites) }},
+		{"lsm_stopped_writes_total", "counter", "Writes blocked by a write stall.", func(s lsm.Stats) float64 { return float64(s.StoppedWrites) }},
+		{"lsm_write_stall_seconds_total", "counter", "Time writes spent delayed or blocked.", func(s lsm.Stats) float64 { return s.WriteStallTime.Seconds() }},
+	}
+	for _, metric := range metrics {
+		p.family(metric.name, metric.kind, metric.help)
+		for _, node := range nodes {
+			p.sample(metric.name, metric.value(stats[node]), "node", node)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"distributedstore/lsm"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsCountRPCsByCode(t *testing.T) {
+	m := NewMetrics()
+	intercept := m.UnaryServerInterceptor("node0")
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.KV/Get"}
+	ok := func(ctx context.Context, req any) (any, error) { return "value", nil }
+	missing := func(ctx context.Context, req any) (any, error) { return nil, status.Error(codes.NotFound, "no key") }
+
+	if resp, err := intercept(context.Background(), nil, info, ok); err != nil || resp != "value" {
+		t.Fatalf("interceptor changed the response: %v, %v", resp, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := intercept(context.Background(), nil, info, missing); status.Code(err) != codes.NotFound {
+			t.Fatalf("interceptor changed the error: %v", err)
+		}
+	}
+	m.routedTo("node0", "get")
+
+	var b strings.Builder
+	m.WritePrometheus(&b)
+	out := b.String()
+	for _, want := range []string{
+		`grpc_server_handled_total{node="node0",method="Get",code="OK"} 1`,
+		`grpc_server_handled_total{node="node0",method="Get",code="NotFound"} 2`,
+		`grpc_server_errors_total{node="node0",method="Get"} 2`,
+		`grpc_server_handling_seconds_bucket{node="node0",method="Get",le="+Inf"} 3`,
+		`grpc_server_handling_seconds_count{node="node0",method="Get"} 3`,
+		`router_requests_total{node="node0",op="get"} 1`,
+		"# TYPE grpc_client_handled_total counter",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestStorageMetricsPerNode(t *testing.T) {
+	var b strings.Builder
+	writeStorageMetrics(&b, map[string]lsm.Stats{
+		"node1": {Puts: 3, Levels: []lsm.LevelStats{{Level: 0, NumFiles: 2, Bytes: 100}}},
+		"node0": {WriteStallCondition: lsm.WriteStallStopped},
+	})
+	out := b.String()
+	for _, want := range []string{
+		`lsm_puts_total{node="node1"} 3`,
+		`lsm_level_files{node="node1",level="0"} 2`,
+		`lsm_write_stall_condition{node="node0"} 2`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Index(out, `lsm_puts_total{node="node0"}`) > strings.Index(out, `lsm_puts_total{node="node1"}`) {
+		t.Error("nodes are not sorted")
+	}
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("escapeLabel = %s", got)
+	}
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"errors"
 
 	"distributedstore/lsm"
 	"distributedstore/proto"
@@ -19,13 +20,63 @@ func NewNodeServer(db *lsm.DB) *NodeServer {
 	return &NodeServer{db: db}
 }
 
+func (s *NodeServer) family(name string) (*lsm.ColumnFamily, error) {
+	return lookupFamily(s.db, name)
+}
+
+// lookupFamily resolves a column family named in a request, where an empty
+// name is the default family.
+func lookupFamily(db *lsm.DB, name string) (*lsm.ColumnFamily, error) {
+	if name == "" {
+		return db.DefaultColumnFamily(), nil
+	}
+	cf, ok := db.ColumnFamily(name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "column family %q not found", name)
+	}
+	return cf, nil
+}
+
+// admitWrite rejects writes while the DB has stopped them, so clients can back
+// off instead of holding an RPC that blocks until compaction catches up.
+func (s *NodeServer) admitWrite() error {
+	if s.db.WriteStallCondition() == lsm.WriteStallStopped {
+		return status.Error(codes.ResourceExhausted, "writes are stalled until flushes and compactions catch up")
+	}
+	return nil
+}
+
+// writeError converts a write the DB did not acknowledge into an RPC error.
+func writeError(err error) error {
+	if errors.Is(err, lsm.ErrClosed) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
 func (s *NodeServer) Put(ctx context.Context, req *proto.PutRequest) (*proto.PutResponse, error) {
-	s.db.Put(string(req.Kv.Key), string(req.Kv.Value))
+	cf, err := s.family(req.Family)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admitWrite(); err != nil {
+		return nil, err
+	}
+	if err := s.db.PutCF(cf, string(req.Kv.Key), string(req.Kv.Value)); err != nil {
+		return nil, writeError(err)
+	}
 	return &proto.PutResponse{Success: true}, nil
 }
 
 func (s *NodeServer) Get(ctx context.Context, req *proto.GetRequest) (*proto.GetResponse, error) {
-	value, found := s.db.Get(string(req.Key))
+	cf, err := s.family(req.Family)
+	if err != nil {
+		return nil, err
+	}
+	value, found, err := s.db.GetCF(cf, string(req.Key))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	if !found {
 		return nil, status.Error(codes.NotFound, "key not found")
 	}
@@ -35,6 +86,15 @@ func (s *NodeServer) Get(ctx context.Context, req *proto.GetRequest) (*proto.Get
 }
 
 func (s *NodeServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.DeleteResponse, error) {
-	s.db.Delete(string(req.Key))
+	cf, err := s.family(req.Family)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admitWrite(); err != nil {
+		return nil, err
+	}
+	if err := s.db.DeleteCF(cf, string(req.Key)); err != nil {
+		return nil, writeError(err)
+	}
 	return &proto.DeleteResponse{Success: true}, nil
 }
@@ -1,20 +1,41 @@
+// Package router spreads keys over a cluster of gRPC nodes by hash. Each
+// node also serves an admin service for flushes, compactions and
+// approximate sizes, which a cluster exposes over HTTP at /admin/flush,
+// /admin/compact and /admin/approximate-size for one node or summed over
+// all of them, next to Prometheus metrics at /metrics. A node whose writes
+// are stopped by a stall answers RESOURCE_EXHAUSTED.
 package router
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"hash/fnv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Router struct {
 	clients []*NodeClient
+	metrics *Metrics
 }
 
 func NewRouter(addrs []string) *Router {
+	return NewRouterWithMetrics(addrs, NewMetrics())
+}
+
+// NewRouterWithMetrics records client-side RPC metrics and routing counters
+// for the nodes at addrs, named node1, node2, ... in order.
+func NewRouterWithMetrics(addrs []string, metrics *Metrics) *Router {
 	var clients []*NodeClient
-	for _, addr := range addrs {
-		clients = append(clients, NewNodeClient(addr))
+	for i, addr := range addrs {
+		interceptor := metrics.UnaryClientInterceptor(nodeName(i))
+		clients = append(clients, NewNodeClient(addr, grpc.WithUnaryInterceptor(interceptor)))
 	}
-	return &Router{clients: clients}
+	return &Router{clients: clients, metrics: metrics}
 }
 
 func (r *Router) Close() {
@@ -23,24 +44,97 @@ func (r *Router) Close() {
 	}
 }
 
-func (r *Router) Put(ctx context.Context, key, value string) {
-	client := r.pickNode(key)
-	client.Put(ctx, key, value)
+func (r *Router) Put(ctx context.Context, family, key, value string) error {
+	client := r.pickNode(key, "put")
+	return client.Put(ctx, family, key, value)
 }
 
-func (r *Router) Get(ctx context.Context, key string) (string, bool) {
-	client := r.pickNode(key)
-	return client.Get(ctx, key)
+func (r *Router) Get(ctx context.Context, family, key string) (string, bool) {
+	client := r.pickNode(key, "get")
+	return client.Get(ctx, family, key)
 }
 
-func (r *Router) Delete(ctx context.Context, key string) {
-	client := r.pickNode(key)
-	client.Delete(ctx, key)
+func (r *Router) Delete(ctx context.Context, family, key string) error {
+	client := r.pickNode(key, "delete")
+	return client.Delete(ctx, family, key)
 }
 
-func (r *Router) pickNode(key string) *NodeClient {
+func (r *Router) pickNode(key string, op string) *NodeClient {
 	h := fnv.New32a()
 	h.Write([]byte(key))
 	idx := int(h.Sum32()) % len(r.clients)
+	r.metrics.routedTo(nodeName(idx), op)
 	return r.clients[idx]
 }
+
+// Flush flushes the named node, or every node if node is empty.
+func (r *Router) Flush(ctx context.Context, node string) error {
+	return r.forNodes(node, func(client *NodeClient) error {
+		return client.Flush(ctx)
+	})
+}
+
+// CompactRange compacts a key range of a column family on the named node, or
+// on every node if node is empty.
+func (r *Router) CompactRange(ctx context.Context, node, family, start, end string) error {
+	return r.forNodes(node, func(client *NodeClient) error {
+		return client.CompactRange(ctx, family, start, end)
+	})
+}
+
+// RangeEstimate is the approximate size in bytes and number of keys a key
+// range takes up.
+type RangeEstimate struct {
+	Size int64 `json:"size"`
+	Keys int64 `json:"keys"`
+}
+
+// ApproximateRange estimates the keys from start to end of a column family
+// on the named node, or adds up the estimates of every node if node is empty.
+func (r *Router) ApproximateRange(ctx context.Context, node, family, start, end string) (RangeEstimate, error) {
+	var mu sync.Mutex
+	var total RangeEstimate
+	err := r.forNodes(node, func(client *NodeClient) error {
+		e, err := client.ApproximateRange(ctx, family, start, end)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total.Size += e.Size
+		total.Keys += e.Keys
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+// forNodes runs fn against the named node, or against every node in parallel,
+// and returns their errors joined.
+func (r *Router) forNodes(node string, fn func(*NodeClient) error) error {
+	if node != "" {
+		for i, client := range r.clients {
+			if nodeName(i) == node {
+				return fn(client)
+			}
+		}
+		return status.Errorf(codes.NotFound, "node %q not found", node)
+	}
+
+	errs := make([]error, len(r.clients))
+	var wg sync.WaitGroup
+	for i, client := range r.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(client); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", nodeName(i), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func nodeName(idx int) string {
+	return fmt.Sprintf("node%d", idx+1)
+}
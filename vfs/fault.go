@@ -0,0 +1,272 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrInjected is returned by operations a FaultFS was told to fail.
+var ErrInjected = errors.New("vfs: injected fault")
+
+// ErrCrashed is returned by every operation of a FaultFS once it has
+// crashed.
+var ErrCrashed = errors.New("vfs: filesystem crashed")
+
+type Op int
+
+const (
+	OpCreate Op = iota
+	OpOpen
+	OpRead
+	OpWrite
+	OpSync
+	OpClose
+	OpRename
+	OpRemove
+	OpLink
+	OpMkdir
+	OpList
+	OpStat
+)
+
+var opNames = [...]string{"create", "open", "read", "write", "sync", "close", "rename", "remove", "link", "mkdir", "list", "stat"}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "unknown"
+}
+
+// mutates reports whether an operation changes the filesystem, and so
+// counts as a point a crash can happen at.
+func (op Op) mutates() bool {
+	switch op {
+	case OpCreate, OpWrite, OpSync, OpRename, OpRemove, OpLink, OpMkdir:
+		return true
+	}
+	return false
+}
+
+// FaultFS wraps a filesystem, failing the operations it is told to and
+// crashing after a given number of changes. After a crash every operation
+// fails, including those on files already open, so nothing more reaches the
+// wrapped filesystem; a MemFS underneath can then be CrashCloned to see what
+// a restart would find.
+//
+// Counting the changes a workload makes with Changes, then running it again
+// with CrashAfter set to each count in turn, tests recovery from a crash at
+// every point.
+type FaultFS struct {
+	fs FS
+	mu sync.Mutex
+	changes int
+	crashAfter int
+	crashed bool
+	inject func(op Op, name string) error
+}
+
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{fs: fs, crashAfter: -1}
+}
+
+// SetInjector calls fn before every operation; a non-nil error is returned
+// in place of performing it. Pass nil to stop injecting.
+func (f *FaultFS) SetInjector(fn func(op Op, name string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inject = fn
+}
+
+// FailOn makes every operation op on a file whose base name matches
+// pattern, as in filepath.Match, fail with ErrInjected.
+func (f *FaultFS) FailOn(op Op, pattern string) {
+	f.SetInjector(func(o Op, name string) error {
+		if ok, _ := filepath.Match(pattern, filepath.Base(name)); ok && o == op {
+			return ErrInjected
+		}
+		return nil
+	})
+}
+
+// CrashAfter makes the filesystem crash once n more changes have been made:
+// the change after them fails with ErrCrashed and so does everything after
+// it. A negative n never crashes.
+func (f *FaultFS) CrashAfter(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n < 0 {
+		f.crashAfter = -1
+		return
+	}
+	f.crashAfter = f.changes + n
+}
+
+// Crash crashes the filesystem now.
+func (f *FaultFS) Crash() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.crashed = true
+}
+
+func (f *FaultFS) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// Changes returns how many changes have been made so far.
+func (f *FaultFS) Changes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.changes
+}
+
+func (f *FaultFS) check(op Op, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	if op.mutates() {
+		if f.changes == f.crashAfter {
+			f.crashed = true
+			return ErrCrashed
+		}
+		f.changes++
+	}
+	if f.inject != nil {
+		return f.inject(op, name)
+	}
+	return nil
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	if err := f.check(OpCreate, name); err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	file, err := f.fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, name: name, file: file}, nil
+}
+
+func (f *FaultFS) OpenAppend(name string) (File, error) {
+	if err := f.check(OpCreate, name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := f.fs.OpenAppend(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, name: name, file: file}, nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	if err := f.check(OpOpen, name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, name: name, file: file}, nil
+}
+
+func (f *FaultFS) Rename(oldname, newname string) error {
+	if err := f.check(OpRename, oldname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return f.fs.Rename(oldname, newname)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if err := f.check(OpRemove, name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *FaultFS) RemoveAll(name string) error {
+	if err := f.check(OpRemove, name); err != nil {
+		return &os.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	return f.fs.RemoveAll(name)
+}
+
+func (f *FaultFS) Link(oldname, newname string) error {
+	if err := f.check(OpLink, oldname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	return f.fs.Link(oldname, newname)
+}
+
+func (f *FaultFS) MkdirAll(dir string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, dir); err != nil {
+		return &os.PathError{Op: "mkdir", Path: dir, Err: err}
+	}
+	return f.fs.MkdirAll(dir, perm)
+}
+
+func (f *FaultFS) List(dir string) ([]string, error) {
+	if err := f.check(OpList, dir); err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	return f.fs.List(dir)
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	if err := f.check(OpStat, name); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return f.fs.Stat(name)
+}
+
+type faultFile struct {
+	fs *FaultFS
+	name string
+	file File
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.fs.check(OpRead, f.name); err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return f.file.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.check(OpRead, f.name); err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return f.file.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fs.check(OpWrite, f.name); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	return f.file.Write(p)
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.check(OpSync, f.name); err != nil {
+		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	return f.file.Sync()
+}
+
+func (f *faultFile) Close() error {
+	if err := f.fs.check(OpClose, f.name); err != nil {
+		f.file.Close()
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,381 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is a filesystem held in memory. Every path resolves under a single
+// root, whether absolute or relative. Directory operations take effect at
+// once, as if every directory were synced, while file contents written
+// since a file's last Sync are lost by CrashClone.
+type MemFS struct {
+	mu sync.Mutex
+	root *memNode
+}
+
+type memNode struct {
+	dir bool
+	children map[string]*memNode
+	data []byte
+	synced []byte
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{root: newMemDir()}
+}
+
+func newMemDir() *memNode {
+	return &memNode{dir: true, children: make(map[string]*memNode), modTime: time.Now()}
+}
+
+func splitPath(name string) []string {
+	name = filepath.ToSlash(filepath.Clean(name))
+	var parts []string
+	for _, p := range strings.Split(name, "/") {
+		if p != "" && p != "." {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// lookupLocked returns the directory holding name, the last component of
+// name, and the node it names if it exists.
+func (m *MemFS) lookupLocked(op, name string) (*memNode, string, *memNode, error) {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return nil, "", m.root, nil
+	}
+	dir := m.root
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := dir.children[p]
+		if !ok {
+			return nil, "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if !next.dir {
+			return nil, "", nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		dir = next
+	}
+	base := parts[len(parts)-1]
+	return dir, base, dir.children[base], nil
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	return m.openFile("create", name, true, true, false)
+}
+
+func (m *MemFS) OpenAppend(name string) (File, error) {
+	return m.openFile("open", name, true, false, true)
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	return m.openFile("open", name, false, false, false)
+}
+
+func (m *MemFS) openFile(op, name string, write, truncate, appending bool) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, base, node, err := m.lookupLocked(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		if !write {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = &memNode{modTime: time.Now()}
+		dir.children[base] = node
+	}
+	if node.dir {
+		return nil, &fs.PathError{Op: op, Path: name, Err: syscall.EISDIR}
+	}
+	if truncate {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	return &memFile{fs: m, name: name, node: node, write: write, appending: appending}, nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldDir, oldBase, node, err := m.lookupLocked("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if node == nil || oldDir == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	newDir, newBase, existing, err := m.lookupLocked("rename", newname)
+	if err != nil {
+		return err
+	}
+	if newDir == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if existing != nil && existing.dir && (!node.dir || len(existing.children) > 0) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+	delete(oldDir.children, oldBase)
+	newDir.children[newBase] = node
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, base, node, err := m.lookupLocked("remove", name)
+	if err != nil {
+		return err
+	}
+	if node == nil || dir == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.dir && len(node.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(dir.children, base)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, base, node, err := m.lookupLocked("removeall", name)
+	if err != nil || node == nil {
+		return nil
+	}
+	if dir == nil {
+		m.root = newMemDir()
+		return nil
+	}
+	delete(dir.children, base)
+	return nil
+}
+
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, node, err := m.lookupLocked("link", oldname)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.dir {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	dir, base, existing, err := m.lookupLocked("link", newname)
+	if err != nil {
+		return err
+	}
+	if existing != nil || dir == nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	dir.children[base] = node
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := m.root
+	for _, p := range splitPath(name) {
+		next, ok := dir.children[p]
+		if !ok {
+			next = newMemDir()
+			dir.children[p] = next
+		}
+		if !next.dir {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		dir = next
+	}
+	return nil
+}
+
+func (m *MemFS) List(name string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, node, err := m.lookupLocked("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.dir {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+	names := make([]string, 0, len(node.children))
+	for n := range node.children {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, base, node, err := m.lookupLocked("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memFileInfo{name: base, size: int64(len(node.data)), dir: node.dir, modTime: node.modTime}, nil
+}
+
+// CrashClone returns a copy of the filesystem as it would be found after a
+// crash: every file holds only what had been synced. Hard links stay shared.
+func (m *MemFS) CrashClone() *MemFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copies := make(map[*memNode]*memNode)
+	var clone func(n *memNode) *memNode
+	clone = func(n *memNode) *memNode {
+		if c, ok := copies[n]; ok {
+			return c
+		}
+		c := &memNode{dir: n.dir, modTime: n.modTime}
+		copies[n] = c
+		if n.dir {
+			c.children = make(map[string]*memNode, len(n.children))
+			for name, child := range n.children {
+				c.children[name] = clone(child)
+			}
+			return c
+		}
+		c.data = append([]byte(nil), n.synced...)
+		c.synced = append([]byte(nil), n.synced...)
+		return c
+	}
+	return &MemFS{root: clone(m.root)}
+}
+
+type memFile struct {
+	fs *MemFS
+	name string
+	node *memNode
+	pos int64
+	write bool
+	appending bool
+	closed bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.pos >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !f.write {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.appending {
+		f.pos = int64(len(f.node.data))
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.pos:], p)
+	f.pos += int64(len(p))
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.node.synced = append([]byte(nil), f.node.data...)
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir bool
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) Size() int64 { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool { return fi.dir }
+func (fi memFileInfo) Sys() any { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
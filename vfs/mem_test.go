@@ -0,0 +1,124 @@
+package vfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"distributedstore/vfs"
+)
+
+func writeFile(t *testing.T, fsys vfs.FS, name string, data string, sync bool) {
+	t.Helper()
+	f, err := fsys.Create(name)
+	if err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+	f.Write([]byte(data))
+	if sync {
+		f.Sync()
+	}
+	f.Close()
+}
+
+func readFile(t *testing.T, fsys vfs.FS, name string) string {
+	t.Helper()
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestMemFSFiles(t *testing.T) {
+	m := vfs.NewMemFS()
+	if err := m.MkdirAll("/d/sub", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, m, "/d/a", "hello", false)
+	f, err := m.OpenAppend("/d/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(" world"))
+	f.Close()
+	if got := readFile(t, m, "/d/a"); got != "hello world" {
+		t.Fatalf("after append: %q", got)
+	}
+
+	if err := m.Link("/d/a", "/d/sub/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rename("/d/a", "/d/c"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, m, "/d/sub/b"); got != "hello world" {
+		t.Fatalf("link: %q", got)
+	}
+	if names, _ := m.List("/d"); !slices.Equal(names, []string{"c", "sub"}) {
+		t.Fatalf("list: %v", names)
+	}
+	if info, err := m.Stat("/d/c"); err != nil || info.Size() != 11 || info.IsDir() {
+		t.Fatalf("stat: %v, %v", info, err)
+	}
+	if err := m.RemoveAll("/d/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/d/sub/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat after RemoveAll: %v", err)
+	}
+}
+
+func TestMemFSErrors(t *testing.T) {
+	m := vfs.NewMemFS()
+	writeFile(t, m, "/a", "x", false)
+	if _, err := m.Open("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open missing: %v", err)
+	}
+	if _, err := m.Create("/nodir/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("create in missing dir: %v", err)
+	}
+	if _, err := m.Create("/a/b"); err == nil {
+		t.Error("created a file under a file")
+	}
+	if err := m.Link("/a", "/a"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("link over an existing file: %v", err)
+	}
+	if err := m.Link("/missing", "/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("link a missing file: %v", err)
+	}
+	if _, err := m.List("/a"); err == nil {
+		t.Error("listed a file")
+	}
+}
+
+func TestCrashCloneKeepsSyncedData(t *testing.T) {
+	m := vfs.NewMemFS()
+	writeFile(t, m, "/synced", "kept", true)
+	f, err := m.OpenAppend("/synced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(" lost"))
+	writeFile(t, m, "/unsynced", "lost", false)
+
+	crashed := m.CrashClone()
+	f.Close()
+	if got := readFile(t, crashed, "/synced"); got != "kept" {
+		t.Fatalf("synced file after crash: %q", got)
+	}
+	if got := readFile(t, crashed, "/unsynced"); got != "" {
+		t.Fatalf("unsynced file after crash: %q", got)
+	}
+	writeFile(t, crashed, "/new", "x", false)
+	if _, err := m.Stat("/new"); err == nil {
+		t.Fatal("clone shares files with the original")
+	}
+}
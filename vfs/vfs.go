@@ -0,0 +1,103 @@
+// Package vfs is the filesystem interface the lsm package does its file I/O
+// through, so a DB can run on the operating system's filesystem, in memory,
+// or on a filesystem that injects faults and crashes.
+package vfs
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// File is an open file. Files opened for reading support Read, ReadAt and
+// Seek; files opened for writing support Write and Sync.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	// Sync makes the data written so far durable.
+	Sync() error
+}
+
+// FS is a filesystem. Names are slash- or OS-separated paths as produced by
+// path/filepath.
+type FS interface {
+	// Create creates or truncates a file for writing.
+	Create(name string) (File, error)
+	// OpenAppend opens a file for appending, creating it if needed.
+	OpenAppend(name string) (File, error)
+	// Open opens a file for reading.
+	Open(name string) (File, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Link(oldname, newname string) error
+	MkdirAll(dir string, perm os.FileMode) error
+	// List returns the names of the entries of dir, sorted.
+	List(dir string) ([]string, error)
+	Stat(name string) (os.FileInfo, error)
+}
+
+// Default is the operating system's filesystem.
+var Default FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Create(name string) (File, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+}
+
+func (osFS) OpenAppend(name string) (File, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) MkdirAll(dir string, perm os.FileMode) error {
+	return os.MkdirAll(dir, perm)
+}
+
+func (osFS) List(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	sort.Strings(names)
+	return names, err
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadFile reads a whole file.
+func ReadFile(fs FS, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}